@@ -0,0 +1,101 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+// the achievement rules, an unlocked achievement is never locked again
+// the id is stored in database, do not change it
+var achievementRules = []types.Achievement{
+	{Id: "combo5", Name: "连击新手", Description: "一局游戏中达成 5 连击", Stat: types.StatCombo, Threshold: 5},
+	{Id: "combo10", Name: "连击大师", Description: "一局游戏中达成 10 连击", Stat: types.StatCombo, Threshold: 10},
+	{Id: "clear1", Name: "一扫而空", Description: "一局游戏中清空一次游戏区域", Stat: types.StatPerfectClears, Threshold: 1},
+	{Id: "clear3", Name: "洁癖", Description: "一局游戏中清空三次游戏区域", Stat: types.StatPerfectClears, Threshold: 3},
+	{Id: "bomb10", Name: "爆破专家", Description: "一局游戏中击中 10 个炸弹", Stat: types.StatBombHits, Threshold: 10},
+	{Id: "ko5", Name: "完胜", Description: "一局游戏中 KO 对手 5 次并获胜", Stat: types.StatKo, Threshold: 5, WinRequired: true},
+	{Id: "lines100", Name: "火力全开", Description: "一局游戏中发送 100 行", Stat: types.StatLines, Threshold: 100},
+	{Id: "wins1", Name: "首胜", Description: "赢得第一局游戏", Stat: types.StatWins, Threshold: 1},
+	{Id: "wins100", Name: "百战百胜", Description: "累计赢得 100 局游戏", Stat: types.StatWins, Threshold: 100},
+	{Id: "champion", Name: "冠军", Description: "赢得一次争霸赛", Stat: types.StatTitles, Threshold: 1},
+}
+
+var achievements = types.NewAchievements(achievementRules...)
+
+func initAchievements() {
+	for _, a := range queryAchievements() {
+		achievements.Load(a.uid, a.id, a.unlocked)
+	}
+	log.Info("initialize the achievements...")
+}
+
+// an unlock stored in database
+type achievementUnlock struct {
+	uid      int
+	id       string
+	unlocked int64
+}
+
+// evaluate the achievements of both players after a game
+// the players are notified in game asynchronously
+func checkAchievements(mr *matchRecord, ip string) {
+	checkUserAchievements(mr.Tid, mr.Winner, ip, types.AchievementEvent{
+		MatchEvents: mr.WinnerEvents,
+		Ko:          mr.WinnerKo,
+		Lines:       mr.WinnerLines,
+		Won:         true,
+	})
+	checkUserAchievements(mr.Tid, mr.Loser, ip, types.AchievementEvent{
+		MatchEvents: mr.LoserEvents,
+		Ko:          mr.LoserKo,
+		Lines:       mr.LoserLines,
+	})
+}
+
+func checkUserAchievements(tid, uid int, ip string, e types.AchievementEvent) {
+	u := getUserById(uid)
+	if u == nil {
+		return
+	}
+	e.Wins, e.Titles = u.Win, titlesOf(uid)
+	tNow := time.Now().Unix()
+	unlocked := achievements.Evaluate(uid, e, tNow)
+	if len(unlocked) == 0 {
+		return
+	}
+	res := make([]map[string]interface{}, 0, len(unlocked))
+	for _, a := range unlocked {
+		id := a.Id
+		pushFunc(func() { insertAchievement(uid, id, tNow) })
+		res = append(res, a.Wrap())
+		log.Info("user %s unlocks achievement %s", u.Nickname, a.Id)
+	}
+	go func() {
+		if err := clients.GetStub(ip).UnlockAchievements(tid, uid, res); err != nil {
+			log.Warn("can not inform game server of the achievements of user %d: %v", uid, err)
+		}
+	}()
+}
+
+// number of tournaments the user wins
+func titlesOf(uid int) int {
+	bp, err := leaderboards.get(boardTitles, windowGlobal)
+	if err != nil {
+		return 0
+	}
+	_, score, _ := bp.lb.Rank(uid)
+	return int(score)
+}
+
+// all the achievements, with the timestamp the user unlocks it, 0 if locked
+func wrapAchievements(uid int) []map[string]interface{} {
+	unlocked := achievements.GetUnlocked(uid)
+	res := make([]map[string]interface{}, 0, len(achievementRules))
+	for _, a := range achievements.GetRules() {
+		m := a.Wrap()
+		m["unlocked"] = unlocked[a.Id]
+		res = append(res, m)
+	}
+	return res
+}
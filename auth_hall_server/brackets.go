@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+// status of the match in the bracket
+const (
+	matchPending  = "pending" // paired, the table is not created yet
+	matchPlaying  = "playing"
+	matchFinished = "finished"
+	matchBye      = "bye"
+	matchForfeit  = "forfeit"
+)
+
+const maxTournamentsPerPage = 20
+
+var errTournamentNotArchived = fmt.Errorf("争霸赛不存在")
+
+// the result of the match played on the table
+type matchResult struct {
+	tid            int
+	score1, score2 int // KO of the players
+	forfeit        bool
+	replay         string
+}
+
+// a match of the bracket, for the live bracket and the archive
+type bracketMatch struct {
+	Id, Round      int
+	Stage          string
+	P1, P2         int
+	Nick1, Nick2   string
+	Winner         int
+	Score1, Score2 int
+	Tid            int
+	Status         string
+	Replay         string // empty until the game server records replays
+}
+
+// for hprose
+func (bm bracketMatch) Wrap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":     bm.Id,
+		"round":  bm.Round,
+		"stage":  bm.Stage,
+		"p1":     map[string]interface{}{"uid": bm.P1, "nickname": bm.Nick1, "score": bm.Score1},
+		"p2":     map[string]interface{}{"uid": bm.P2, "nickname": bm.Nick2, "score": bm.Score2},
+		"winner": bm.Winner,
+		"tid":    bm.Tid,
+		"status": bm.Status,
+		"replay": bm.Replay,
+	}
+}
+
+// a standing of the bracket
+type bracketStanding struct {
+	Uid, Rank    int
+	Nickname     string
+	Wins, Losses int
+	Payout       int
+}
+
+// for hprose
+func (bs bracketStanding) Wrap() map[string]interface{} {
+	return map[string]interface{}{
+		"uid":      bs.Uid,
+		"nickname": bs.Nickname,
+		"rank":     bs.Rank,
+		"wins":     bs.Wins,
+		"losses":   bs.Losses,
+		"payout":   bs.Payout,
+	}
+}
+
+// a tournament in the archive
+type tournamentSummary struct {
+	Id, Template int
+	Name, Format string
+	Start        int64
+	Status, Pool int
+	Gold, Silver string
+}
+
+// for hprose
+func (ts tournamentSummary) Wrap() map[string]interface{} {
+	status := "finished"
+	switch ts.Status {
+	case tournamentRunning:
+		status = "running"
+	case tournamentCancelled:
+		status = "cancelled"
+	}
+	return map[string]interface{}{
+		"id":         ts.Id,
+		"template":   ts.Template,
+		"name":       ts.Name,
+		"format":     ts.Format,
+		"start":      ts.Start,
+		"status":     status,
+		"prize_pool": ts.Pool,
+		"gold":       ts.Gold,
+		"silver":     ts.Silver,
+	}
+}
+
+func wrapBracket(ts tournamentSummary, matches []bracketMatch, standings []bracketStanding) map[string]interface{} {
+	ms := make([]map[string]interface{}, len(matches))
+	for i, m := range matches {
+		ms[i] = m.Wrap()
+	}
+	ss := make([]map[string]interface{}, len(standings))
+	for i, s := range standings {
+		ss[i] = s.Wrap()
+	}
+	res := ts.Wrap()
+	res["matches"] = ms
+	res["standings"] = ss
+	return res
+}
+
+func nicknameOf(uid int) string {
+	if u := getUserById(uid); u != nil {
+		return u.Nickname
+	}
+	return ""
+}
+
+// the matches of the bracket with the results of the tables
+// the director lock should be held
+func (t *tournament) bracketMatches() []bracketMatch {
+	if t.bracket == nil {
+		return nil
+	}
+	pairings := t.bracket.Matches()
+	res := make([]bracketMatch, 0, len(pairings))
+	for _, p := range pairings {
+		bm := bracketMatch{
+			Id:     p.Id,
+			Round:  p.Round,
+			Stage:  p.Stage,
+			P1:     p.P1,
+			P2:     p.P2,
+			Nick1:  nicknameOf(p.P1),
+			Winner: p.Winner,
+			Status: matchPending,
+		}
+		if !p.IsBye() {
+			bm.Nick2 = nicknameOf(p.P2)
+		}
+		r, ok := t.results[p.Id]
+		if ok {
+			bm.Tid, bm.Score1, bm.Score2, bm.Replay = r.tid, r.score1, r.score2, r.replay
+		}
+		switch {
+		case p.IsBye():
+			bm.Status = matchBye
+		case ok && r.forfeit:
+			bm.Status = matchForfeit
+		case p.Winner >= 0:
+			bm.Status = matchFinished
+		case ok:
+			bm.Status = matchPlaying
+		}
+		res = append(res, bm)
+	}
+	return res
+}
+
+// the standings of the bracket with the payouts
+// the director lock should be held
+func (t *tournament) bracketStandings() []bracketStanding {
+	if t.bracket == nil {
+		return nil
+	}
+	standings := t.bracket.Standings()
+	res := make([]bracketStanding, len(standings))
+	for i, s := range standings {
+		res[i] = bracketStanding{
+			Uid:      s.Uid,
+			Rank:     s.Rank,
+			Nickname: nicknameOf(s.Uid),
+			Wins:     s.Wins,
+			Losses:   s.Losses,
+			Payout:   t.payouts[s.Uid],
+		}
+	}
+	return res
+}
+
+func (t *tournament) summary() tournamentSummary {
+	status := tournamentRunning
+	if t.GetStatus() == types.TournamentStatEnd {
+		status = tournamentFinished
+	}
+	return tournamentSummary{
+		Id:       t.GetId(),
+		Template: t.template.Id,
+		Name:     t.template.Name,
+		Format:   t.template.Format,
+		Start:    t.start.Unix(),
+		Status:   status,
+		Pool:     t.prizePool(),
+		Gold:     t.GetGold(),
+		Silver:   t.GetSilver(),
+	}
+}
+
+// the live bracket of the tournament
+// the director lock should be held
+func (t *tournament) wrapBracket() map[string]interface{} {
+	return wrapBracket(t.summary(), t.bracketMatches(), t.bracketStandings())
+}
+
+// the live bracket of the running tournament
+func (td *tournamentDirector) bracket(t *tournament) map[string]interface{} {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	return t.wrapBracket()
+}
+
+// push the live bracket to the tables of the tournament on the game server
+// the director lock should be held
+func (td *tournamentDirector) pushBracket(t *tournament) {
+	ip, bracket := t.ip, t.wrapBracket()
+	pushed := make(map[int]bool)
+	for _, tid := range t.seats {
+		if pushed[tid] {
+			continue
+		}
+		pushed[tid] = true
+		tid := tid
+		td.later(func() {
+			if err := clients.GetStub(ip).Bracket(tid, bracket); err != nil {
+				log.Debug("can not push the bracket to table %d: %v", tid, err)
+			}
+		})
+	}
+}
+
+// archive the bracket of the finished tournament
+// the director lock should be held
+func (td *tournamentDirector) archive(t *tournament) {
+	ts, matches, standings := t.summary(), t.bracketMatches(), t.bracketStandings()
+	pushFunc(func() { archiveTournament(ts, matches, standings) })
+}
+
+// the bracket of the tournament, running or archived
+func getTournamentBracket(id int) (map[string]interface{}, error) {
+	if t := director.get(id); t != nil {
+		return director.bracket(t), nil
+	}
+	ts, matches, standings, err := queryTournamentBracket(id)
+	if err != nil {
+		return nil, err
+	}
+	return wrapBracket(ts, matches, standings), nil
+}
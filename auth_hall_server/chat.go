@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gogames/go_tetris/utils"
+)
+
+// lobby chat and the moderation
+// table chat on game servers goes through the same checks
+const (
+	// number of lobby messages kept in memory
+	lobbyHistory = 200
+	// a user can send chatRateCount messages in chatRateWindow
+	chatRateWindow = 10 * time.Second
+	chatRateCount  = 5
+	maxChatLength  = 200
+	// channel of the chat log, the table id for table chat
+	channelLobby = 0
+)
+
+// sanctions
+const (
+	sanctionMute = iota // can not send messages
+	sanctionBan         // can not send or read the lobby messages
+)
+
+var (
+	errChatTooFast   = fmt.Errorf("发言过于频繁, 请稍后再试")
+	errChatTooLong   = fmt.Errorf("消息不能超过 %d 个字", maxChatLength)
+	errMuted         = "你已被禁言, 解禁时间 %s"
+	errChatBanned    = fmt.Errorf("你已被禁止使用聊天")
+	errNotModerator  = fmt.Errorf("只有管理员才能进行该操作")
+	errNotSanctioned = fmt.Errorf("该用户没有被禁言或者禁止聊天")
+)
+
+var chatFilter = utils.NewWordFilter()
+
+type chatMessage struct {
+	Id       int
+	Uid      int
+	Nickname string
+	Content  string
+	System   bool
+	Created  int64
+}
+
+// for hprose
+func (cm *chatMessage) Wrap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":       cm.Id,
+		"nickname": cm.Nickname,
+		"content":  cm.Content,
+		"system":   cm.System,
+		"created":  cm.Created,
+	}
+}
+
+// mute or ban
+type sanction struct {
+	Uid, Kind, By int
+	Until         int64 // 0 means forever
+	Reason        string
+}
+
+func (s *sanction) expired(tNow int64) bool {
+	return s.Until > 0 && s.Until <= tNow
+}
+
+type lobby struct {
+	messages  []*chatMessage
+	nextId    int
+	sent      map[int][]time.Time       // uid -> the time of the recent messages
+	sanctions map[int]map[int]*sanction // uid -> kind -> sanction
+	mu        sync.RWMutex
+}
+
+var lobbyChat = &lobby{
+	messages:  make([]*chatMessage, 0, lobbyHistory),
+	nextId:    1,
+	sent:      make(map[int][]time.Time),
+	sanctions: make(map[int]map[int]*sanction),
+}
+
+func initChat() {
+	for _, s := range querySanctions() {
+		lobbyChat.loadSanction(s)
+	}
+	log.Info("initialize the lobby chat...")
+}
+
+// check if the user could send the message, return the filtered message
+func (l *lobby) check(uid int, content string) (string, error) {
+	if content == "" {
+		return "", errEmptyMessage
+	}
+	if utf8.RuneCountInString(content) > maxChatLength {
+		return "", errChatTooLong
+	}
+	tNow := time.Now()
+	if s, ok := l.getSanction(uid, sanctionBan); ok {
+		return "", fmt.Errorf("%v: %s", errChatBanned, s.Reason)
+	}
+	if s, ok := l.getSanction(uid, sanctionMute); ok {
+		return "", fmt.Errorf(errMuted, time.Unix(s.Until, 0).Format("2006-01-02 15:04:05"))
+	}
+
+	// rate limit
+	l.mu.Lock()
+	sent := l.sent[uid]
+	for len(sent) > 0 && tNow.Sub(sent[0]) > chatRateWindow {
+		sent = sent[1:]
+	}
+	if len(sent) >= chatRateCount {
+		l.sent[uid] = sent
+		l.mu.Unlock()
+		return "", errChatTooFast
+	}
+	l.sent[uid] = append(sent, tNow)
+	l.mu.Unlock()
+
+	filtered, _ := chatFilter.Filter(content)
+	return filtered, nil
+}
+
+// post a message to the lobby
+func (l *lobby) post(uid int, nickname, content string, system bool) *chatMessage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cm := &chatMessage{
+		Id:       l.nextId,
+		Uid:      uid,
+		Nickname: nickname,
+		Content:  content,
+		System:   system,
+		Created:  time.Now().Unix(),
+	}
+	l.nextId++
+	if len(l.messages) >= lobbyHistory {
+		l.messages = l.messages[1:]
+	}
+	l.messages = append(l.messages, cm)
+	return cm
+}
+
+// get the messages after the id
+func (l *lobby) since(id int) []map[string]interface{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	res := make([]map[string]interface{}, 0)
+	for _, cm := range l.messages {
+		if cm.Id > id {
+			res = append(res, cm.Wrap())
+		}
+	}
+	return res
+}
+
+func (l *lobby) loadSanction(s *sanction) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sanctions[s.Uid] == nil {
+		l.sanctions[s.Uid] = make(map[int]*sanction)
+	}
+	l.sanctions[s.Uid][s.Kind] = s
+}
+
+// get the sanction in effect
+func (l *lobby) getSanction(uid, kind int) (*sanction, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s, ok := l.sanctions[uid][kind]
+	if !ok || s.expired(time.Now().Unix()) {
+		return nil, false
+	}
+	return s, true
+}
+
+// lift the sanction, return false if there is no such sanction
+func (l *lobby) lift(uid, kind int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.sanctions[uid][kind]; !ok {
+		return false
+	}
+	delete(l.sanctions[uid], kind)
+	return true
+}
+
+// check the chat message, used by both the lobby and the tables
+func checkChat(tid, uid int, content string) (string, error) {
+	filtered, err := lobbyChat.check(uid, content)
+	if err != nil {
+		return "", err
+	}
+	pushFunc(func() { insertChatLog(tid, uid, filtered) })
+	return filtered, nil
+}
+
+// a system message in the lobby
+func lobbySysText(text string) {
+	lobbyChat.post(-1, "", text, true)
+	pushFunc(func() { insertChatLog(channelLobby, -1, text) })
+}
+
+// check if the user is a moderator or an administrator
+func isModerator(uid int) bool {
+	u := getUserById(uid)
+	return u != nil && (admins[uid] || moderators[u.Nickname])
+}
+
+// mute or ban the user for minutes, forever if minutes is not positive
+func sanctionUser(by, uid, kind, minutes int, reason string) {
+	s := &sanction{Uid: uid, Kind: kind, By: by, Reason: reason}
+	if minutes > 0 {
+		s.Until = time.Now().Add(time.Duration(minutes) * time.Minute).Unix()
+	}
+	lobbyChat.loadSanction(s)
+	pushFunc(func() { insertSanction(s) })
+}
+
+// lift the mute or ban of the user
+func liftSanction(uid, kind int) error {
+	if !lobbyChat.lift(uid, kind) {
+		return errNotSanctioned
+	}
+	pushFunc(func() { deleteSanction(uid, kind) })
+	return nil
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/astaxie/beego/config"
 	"github.com/gogames/go_tetris/utils"
@@ -11,6 +13,7 @@ import (
 var (
 	conf                                                config.ConfigContainer
 	btcUser, btcPass, btcServer                         string
+	walletBackend                                       = walletBitcoind
 	gameServerRpcPort, gameServerSocketPort             string
 	dsn                                                 string
 	logPath                                             string
@@ -20,6 +23,8 @@ var (
 	emailSMTPPort                                       int
 	cookieDomain                                        string
 	privKey                                             []byte
+	admins                                              = make(map[int]bool)
+	moderators                                          = make(map[string]bool)
 )
 
 func initConf() {
@@ -75,4 +80,64 @@ func initConf() {
 		utils.SetDomain(cookieDomain)
 	}
 	privKey = []byte(privKeyString)
+	// uids of the administrators, separated by comma
+	for _, s := range parseList("admins") {
+		uid, err := strconv.Atoi(s)
+		if err != nil {
+			panic("can not parse the uid of admin " + s + ": " + err.Error())
+		}
+		admins[uid] = true
+	}
+	// nicknames of the chat moderators, separated by comma
+	for _, nickname := range parseList("moderators") {
+		moderators[nickname] = true
+	}
+	// words filtered in the chat, separated by comma
+	chatFilter.Set(parseList("chatFilterWords")...)
+	// the wallet of bitcoin, bitcoind or fake
+	if backend := conf.String("btcWallet"); backend != "" {
+		walletBackend = backend
+	}
+	// confirmations to credit the deposit
+	if n, err := conf.Int("depositConfirmations"); err == nil && n > 0 {
+		depositConfirmations = n
+	}
+	// daily limits of the withdrawals in mBTC, of a user and of all, 0 means no limit
+	if n, err := conf.Int("withdrawUserDailyLimit"); err == nil && n >= 0 {
+		withdrawUserDailyLimit = n
+	}
+	if n, err := conf.Int("withdrawDailyLimit"); err == nil && n >= 0 {
+		withdrawDailyLimit = n
+	}
+	// the withdrawals of the amount or more are reviewed by an admin, 0 means no review
+	if n, err := conf.Int("withdrawReviewThreshold"); err == nil && n >= 0 {
+		withdrawReviewThreshold = n
+	}
+	// max withdrawals sent in a transaction
+	if n, err := conf.Int("withdrawBatchSize"); err == nil && n > 0 {
+		withdrawBatchSize = n
+	}
+	// the play chips given out daily
+	if n, err := conf.Int("dailyChips"); err == nil && n >= 0 {
+		dailyChips = n
+	}
+	// length of a ranked season in days
+	if days, err := conf.Int("seasonDays"); err == nil && days > 0 {
+		seasonDays = days
+	}
+	// house fee of the spectator prediction pool in percent
+	if fee, err := conf.Int("predictionFee"); err == nil && fee >= 0 && fee < 100 {
+		predictionFee = fee
+	}
+}
+
+// parse the list separated by comma
+func parseList(key string) []string {
+	res := make([]string, 0)
+	for _, v := range strings.Split(conf.String(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
 }
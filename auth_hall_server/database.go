@@ -8,6 +8,7 @@ import (
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gogames/go_tetris/types"
+	"github.com/gogames/go_tetris/wallet"
 )
 
 const (
@@ -29,8 +30,277 @@ const (
 		session BLOB,
 		PRIMARY KEY (sessionId)
 	) ENGINE=innoDB;`
+	sqlCreateSuspects = `CREATE TABLE suspects (
+		tid INT,
+		uid INT,
+		reason VARCHAR(255),
+		created INT
+	) ENGINE=innoDB;`
+	sqlCreateHeldResults = `CREATE TABLE held_results (
+		id INT AUTO_INCREMENT,
+		tid INT,
+		winner INT,
+		loser INT,
+		bet INT,
+		currency VARCHAR(16) DEFAULT 'mBTC',
+		reasons BLOB,
+		status INT DEFAULT 0, -- 0 -> pending  1 -> approved  2 -> voided
+		created INT,
+		PRIMARY KEY (id)
+	) ENGINE=innoDB;`
+	sqlCreateFriends = `CREATE TABLE friends (
+		uid INT,
+		fid INT,
+		relation INT, -- 0 -> requested  1 -> accepted  2 -> blocked
+		created INT,
+		PRIMARY KEY (uid, fid)
+	) ENGINE=innoDB;`
+	sqlCreateMessages = `CREATE TABLE messages (
+		id INT AUTO_INCREMENT,
+		sender INT,
+		receiver INT,
+		content VARCHAR(1024),
+		isRead INT DEFAULT 0,
+		created INT,
+		PRIMARY KEY (id),
+		INDEX idx_receiver (receiver, isRead)
+	) ENGINE=innoDB;`
+	sqlCreateChatLog = `CREATE TABLE chat_log (
+		id INT AUTO_INCREMENT,
+		channel INT, -- 0 -> lobby  others -> table id
+		uid INT, -- -1 -> system
+		content VARCHAR(1024),
+		created INT,
+		PRIMARY KEY (id),
+		INDEX idx_channel (channel)
+	) ENGINE=innoDB;`
+	sqlCreateChatSanctions = `CREATE TABLE chat_sanctions (
+		uid INT,
+		kind INT, -- 0 -> mute  1 -> ban
+		until INT, -- 0 -> forever
+		byUid INT,
+		reason VARCHAR(256),
+		created INT,
+		PRIMARY KEY (uid, kind)
+	) ENGINE=innoDB;`
+	sqlCreateLeaderboards = `CREATE TABLE leaderboards (
+		board VARCHAR(16),
+		period VARCHAR(16), -- global, 2006-W01 or 2006-01
+		uid INT,
+		score DOUBLE,
+		PRIMARY KEY (board, period, uid)
+	) ENGINE=innoDB;`
+	sqlCreateMatches = `CREATE TABLE matches (
+		id INT AUTO_INCREMENT,
+		tid INT,
+		mode VARCHAR(16), -- normal, rated or tournament
+		bet INT,
+		currency VARCHAR(16) DEFAULT 'mBTC',
+		winner INT,
+		loser INT,
+		winnerKo INT,
+		loserKo INT,
+		winnerLines INT,
+		loserLines INT,
+		duration INT,
+		endReason INT, -- 0 -> normal  1 -> 1p quit  2 -> 2p quit
+		server VARCHAR(64),
+		replay VARCHAR(128),
+		created INT,
+		PRIMARY KEY (id),
+		INDEX idx_winner (winner),
+		INDEX idx_loser (loser)
+	) ENGINE=innoDB;`
+	sqlCreateAchievements = `CREATE TABLE achievements (
+		uid INT,
+		achievement VARCHAR(32),
+		unlocked INT,
+		PRIMARY KEY (uid, achievement)
+	) ENGINE=innoDB;`
+	sqlCreateSeasons = `CREATE TABLE seasons (
+		id INT AUTO_INCREMENT,
+		name VARCHAR(64),
+		start INT,
+		end INT,
+		status INT DEFAULT 0, -- 0 -> active  1 -> ended
+		PRIMARY KEY (id)
+	) ENGINE=innoDB;`
+	sqlCreateSeasonPlayers = `CREATE TABLE season_players (
+		season INT,
+		uid INT,
+		games INT,
+		finalRank INT DEFAULT 0, -- 0 -> not ranked
+		finalRating DOUBLE DEFAULT 0,
+		PRIMARY KEY (season, uid)
+	) ENGINE=innoDB;`
+	sqlCreatePredictionPools = `CREATE TABLE prediction_pools (
+		id INT AUTO_INCREMENT,
+		tid INT,
+		winnerSide INT DEFAULT -1, -- 0 -> 1p  1 -> 2p  -1 -> refunded
+		fee INT DEFAULT 0,
+		status INT DEFAULT 0, -- 0 -> pending  1 -> settled  2 -> refunded
+		created INT,
+		settled INT DEFAULT 0,
+		PRIMARY KEY (id)
+	) ENGINE=innoDB;`
+	sqlCreatePredictions = `CREATE TABLE predictions (
+		pool INT,
+		uid INT,
+		side INT, -- 0 -> 1p  1 -> 2p
+		stake INT,
+		payout INT DEFAULT 0,
+		status INT DEFAULT 0, -- 0 -> pending  1 -> settled  2 -> refunded
+		created INT,
+		PRIMARY KEY (pool, uid)
+	) ENGINE=innoDB;`
+	sqlCreateTournamentTemplates = `CREATE TABLE tournament_templates (
+		id INT AUTO_INCREMENT,
+		name VARCHAR(64),
+		size INT,
+		feeKind VARCHAR(16),
+		fee INT,
+		prize INT,
+		split VARCHAR(64),
+		ruleset VARCHAR(16),
+		recurrence VARCHAR(64),
+		registerMinutes INT,
+		enabled INT DEFAULT 1,
+		format VARCHAR(16) DEFAULT 'single',
+		minPlayers INT DEFAULT 2,
+		PRIMARY KEY (id)
+	) ENGINE=innoDB;`
+	sqlCreateTournaments = `CREATE TABLE tournaments (
+		id INT AUTO_INCREMENT,
+		template INT,
+		name VARCHAR(64),
+		start INT,
+		status INT DEFAULT 0, -- 0 -> running  1 -> finished  2 -> cancelled
+		gold INT DEFAULT 0,
+		silver INT DEFAULT 0,
+		format VARCHAR(16) DEFAULT 'single',
+		pool INT DEFAULT 0,
+		PRIMARY KEY (id)
+	) ENGINE=innoDB;`
+	sqlCreateTournamentEntries = `CREATE TABLE tournament_entries (
+		tournament INT,
+		uid INT,
+		feeKind VARCHAR(16),
+		fee INT,
+		status INT DEFAULT 0, -- 0 -> frozen  1 -> paid  2 -> refunded
+		payout INT DEFAULT 0,
+		PRIMARY KEY (tournament, uid)
+	) ENGINE=innoDB;`
+	sqlCreateTournamentMatches = `CREATE TABLE tournament_matches (
+		tournament INT,
+		matchId INT,
+		round INT,
+		stage VARCHAR(16),
+		p1 INT,
+		p2 INT,
+		nick1 VARCHAR(64),
+		nick2 VARCHAR(64),
+		winner INT,
+		score1 INT,
+		score2 INT,
+		tid INT,
+		status VARCHAR(16),
+		replay VARCHAR(256),
+		PRIMARY KEY (tournament, matchId)
+	) ENGINE=innoDB;`
+	sqlCreateTournamentStandings = `CREATE TABLE tournament_standings (
+		tournament INT,
+		uid INT,
+		nickname VARCHAR(64),
+		place INT,
+		wins INT,
+		losses INT,
+		payout INT DEFAULT 0,
+		PRIMARY KEY (tournament, uid)
+	) ENGINE=innoDB;`
+	sqlCreateLedgerEntries = `CREATE TABLE ledger_entries (
+		id INT AUTO_INCREMENT,
+		kind VARCHAR(32),
+		ref VARCHAR(128),
+		created INT,
+		PRIMARY KEY (id)
+	) ENGINE=innoDB;`
+	sqlCreateLedgerPostings = `CREATE TABLE ledger_postings (
+		entry INT,
+		account VARCHAR(64),
+		asset VARCHAR(16),
+		amount INT,
+		INDEX (entry),
+		INDEX (account, asset)
+	) ENGINE=innoDB;`
+	sqlCreateDeposits = `CREATE TABLE deposits (
+		txid VARCHAR(128),
+		address VARCHAR(64),
+		account VARCHAR(64),
+		amount INT,
+		confirmations INT DEFAULT 0,
+		status INT DEFAULT 0, -- 0 -> pending  1 -> confirmed  2 -> credited  3 -> dropped  4 -> reversed  5 -> unconfirmed
+		covered INT DEFAULT 0, -- the part the house covers while it is unconfirmed
+		created INT,
+		updated INT,
+		PRIMARY KEY (txid, address)
+	) ENGINE=innoDB;`
+	sqlCreateWalletCursors = `CREATE TABLE wallet_cursors (
+		name VARCHAR(32),
+		blockHash VARCHAR(128),
+		PRIMARY KEY (name)
+	) ENGINE=innoDB;`
+	sqlCreateWithdrawals = `CREATE TABLE withdrawals (
+		id INT AUTO_INCREMENT,
+		uid INT,
+		nickname VARCHAR(64),
+		address VARCHAR(64),
+		amount INT,
+		idemKey VARCHAR(64),
+		status INT DEFAULT 0, -- 0 -> pending  1 -> review  2 -> sending  3 -> sent  4 -> failed  5 -> rejected
+		batch INT DEFAULT 0,
+		txid VARCHAR(128) DEFAULT '',
+		created INT,
+		updated INT,
+		PRIMARY KEY (id),
+		UNIQUE KEY idx_key (uid, idemKey),
+		INDEX idx_status (status),
+		INDEX idx_created (created)
+	) ENGINE=innoDB;`
+	sqlCreateWithdrawalBatches = `CREATE TABLE withdrawal_batches (
+		id INT AUTO_INCREMENT,
+		status INT DEFAULT 2, -- 2 -> sending  3 -> sent  4 -> failed
+		txid VARCHAR(128) DEFAULT '',
+		created INT,
+		updated INT,
+		PRIMARY KEY (id)
+	) ENGINE=innoDB;`
+	sqlCreateSchedules = `CREATE TABLE schedules (
+		name VARCHAR(32),
+		lastRun INT,
+		PRIMARY KEY (name)
+	) ENGINE=innoDB;`
 )
 
+// rating columns for the users table created before the rating system
+var sqlAlterUsersRating = []string{
+	fmt.Sprintf("ALTER TABLE users ADD COLUMN Rating DOUBLE DEFAULT %v", types.DefaultRating),
+	fmt.Sprintf("ALTER TABLE users ADD COLUMN RatingDev DOUBLE DEFAULT %v", types.DefaultRatingDev),
+	fmt.Sprintf("ALTER TABLE users ADD COLUMN Volatility DOUBLE DEFAULT %v", types.DefaultVolatility),
+	"ALTER TABLE users ADD COLUMN LastRated INT DEFAULT 0",
+}
+
+// chips columns for the users table created before the chips currency
+var sqlAlterUsersChips = []string{
+	"ALTER TABLE users ADD COLUMN Chips INT DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN FreezedChips INT DEFAULT 0",
+}
+
+// columns for the accounting table created before the kinds of the bitcoin movements
+var sqlAlterAccounting = []string{
+	"ALTER TABLE accounting ADD COLUMN kind VARCHAR(32) DEFAULT ''",
+	"ALTER TABLE accounting ADD COLUMN created INT DEFAULT 0",
+}
+
 var db *sql.DB
 
 func initDatabase() {
@@ -40,7 +310,7 @@ func initDatabase() {
 		panic(err.Error())
 	}
 	createTable()
-	convFreezedToBalance()
+	voidPendingHeldResults()
 	go keepDatabaseAlive()
 	log.Info("initialize database...")
 }
@@ -52,22 +322,111 @@ func createTable() {
 			log.Debug("can not create user table: %v", err)
 		}
 	}
+	for _, sql := range sqlAlterUsersRating {
+		if _, err := db.Exec(sql); err != nil {
+			log.Debug("can not add rating column to user table: %v", err)
+		}
+	}
+	for _, sql := range sqlAlterUsersChips {
+		if _, err := db.Exec(sql); err != nil {
+			log.Debug("can not add chips column to user table: %v", err)
+		}
+	}
 	if _, err := db.Exec(sqlCreateAccounting); err != nil {
 		log.Debug("can not create accounting table: %v", err)
 	}
+	for _, sql := range sqlAlterAccounting {
+		if _, err := db.Exec(sql); err != nil {
+			log.Debug("can not add column to accounting table: %v", err)
+		}
+	}
 	if _, err := db.Exec(sqlCreateEnergy); err != nil {
 		log.Debug("can not create energy table: %v", err)
 	}
 	if _, err := db.Exec(sqlCreateSession); err != nil {
 		log.Debug("can not create session table: %v", err)
 	}
+	if _, err := db.Exec(sqlCreateSuspects); err != nil {
+		log.Debug("can not create suspects table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateHeldResults); err != nil {
+		log.Debug("can not create held results table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateFriends); err != nil {
+		log.Debug("can not create friends table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateMessages); err != nil {
+		log.Debug("can not create messages table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateChatLog); err != nil {
+		log.Debug("can not create chat log table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateChatSanctions); err != nil {
+		log.Debug("can not create chat sanctions table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateLeaderboards); err != nil {
+		log.Debug("can not create leaderboards table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateMatches); err != nil {
+		log.Debug("can not create matches table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateAchievements); err != nil {
+		log.Debug("can not create achievements table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateSeasons); err != nil {
+		log.Debug("can not create seasons table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateSeasonPlayers); err != nil {
+		log.Debug("can not create season players table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreatePredictionPools); err != nil {
+		log.Debug("can not create prediction pools table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreatePredictions); err != nil {
+		log.Debug("can not create predictions table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateTournamentTemplates); err != nil {
+		log.Debug("can not create tournament templates table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateTournaments); err != nil {
+		log.Debug("can not create tournaments table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateTournamentEntries); err != nil {
+		log.Debug("can not create tournament entries table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateTournamentMatches); err != nil {
+		log.Debug("can not create tournament matches table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateTournamentStandings); err != nil {
+		log.Debug("can not create tournament standings table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateLedgerEntries); err != nil {
+		log.Debug("can not create ledger entries table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateLedgerPostings); err != nil {
+		log.Debug("can not create ledger postings table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateDeposits); err != nil {
+		log.Debug("can not create deposits table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateWalletCursors); err != nil {
+		log.Debug("can not create wallet cursors table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateWithdrawals); err != nil {
+		log.Debug("can not create withdrawals table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateWithdrawalBatches); err != nil {
+		log.Debug("can not create withdrawal batches table: %v", err)
+	}
+	if _, err := db.Exec(sqlCreateSchedules); err != nil {
+		log.Debug("can not create schedules table: %v", err)
+	}
 }
 
-// init set bitcoin freezed to 0, add it to balance
-func convFreezedToBalance() {
-	_, err := db.Exec("UPDATE users SET Balance = Balance + Freezed, Freezed = 0;")
-	if err != nil {
-		panic("can not convert freezed to balance: " + err.Error())
+// the freezed bitcoin of held results is returned by the ledger on restart, void them
+func voidPendingHeldResults() {
+	if _, err := db.Exec("UPDATE held_results SET status = ? WHERE status = ?", heldVoided, heldPending); err != nil {
+		panic("can not void the pending held results: " + err.Error())
 	}
 }
 
@@ -79,72 +438,8 @@ func keepDatabaseAlive() {
 	}
 }
 
-// bitcoin withdraw
-func insertWithdraw(txid, nickname, toAddr string, amount int) error {
-	tx, err := db.Begin()
-	defer func() {
-		if err != nil {
-			log.Error("can not insert into withdraw: %v", err)
-			log.Error("txid -> %v\nnickname -> %v\ntoAddr -> %v\namount -> %vmBTC", txid, nickname, toAddr, amount)
-		}
-	}()
-	if err != nil {
-		return err
-	}
-	if err = func() error {
-		if _, err := tx.Exec("INSERT INTO accounting(txid, amount, account, address, isDeposit) VALUES(?, ?, ?, ?, ?)",
-			txid, amount, nickname, toAddr, 0); err != nil {
-			return err
-		}
-		if _, err := tx.Exec("UPDATE users SET Balance = Balance - ? WHERE Nickname = ?", amount, nickname); err != nil {
-			return err
-		}
-		return nil
-	}(); err != nil {
-		tx.Rollback()
-		return err
-	}
-	err = tx.Commit()
-	return err
-}
-
-// bitcoin deposit
-func insertDeposit(txid, nickname, fromAddr string, amount int) error {
-	// first check if it exists
-	row := db.QueryRow("SELECT 1 FROM accounting WHERE isDeposit = 1 AND txid = ?", txid)
-	if row.Scan(new(int)) != sql.ErrNoRows {
-		return fmt.Errorf("the deposit %s is already exist", txid)
-	}
-	// then insert
-	tx, err := db.Begin()
-	defer func() {
-		if err != nil {
-			log.Error("can not insert into deposit: %v", err)
-			log.Error("txid -> %v\nnickname -> %v\nfromAddr -> %v\namount -> %vmBTC", txid, nickname, fromAddr, amount)
-		}
-	}()
-	if err != nil {
-		return err
-	}
-	if err = func() error {
-		if _, err := tx.Exec("INSERT INTO accounting(txid, amount, account, address, isDeposit) VALUES(?, ?, ?, ?, ?)",
-			txid, amount, nickname, fromAddr, 1); err != nil {
-			return err
-		}
-		if _, err := tx.Exec("UPDATE users SET Balance = Balance + ? WHERE Nickname = ?", amount, nickname); err != nil {
-			return err
-		}
-		return nil
-	}(); err != nil {
-		tx.Rollback()
-		return err
-	}
-	err = tx.Commit()
-	return err
-}
-
 // buy energy
-func buyEnergy(uid, amount int) error {
+func buyEnergy(uid, amount int, e *types.JournalEntry) error {
 	tx, err := db.Begin()
 	defer func() {
 		if err != nil {
@@ -162,7 +457,7 @@ func buyEnergy(uid, amount int) error {
 		if _, err := tx.Exec("INSERT INTO energy(uid, amount, created) VALUES(?, ?, ?)", uid, amount, time.Now().Unix()); err != nil {
 			return err
 		}
-		return nil
+		return insertJournalEntry(tx, e)
 	}(); err != nil {
 		tx.Rollback()
 		return err
@@ -181,6 +476,27 @@ func insertOrUpdateUser(us ...*types.User) {
 	}
 }
 
+// update the ratings of the players of a game in one transaction
+func updateRatings(us ...*types.User) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Error("can not start transaction for updating ratings: %v", err)
+		return
+	}
+	for _, u := range us {
+		r := u.GetRating()
+		if _, err = tx.Exec("UPDATE users SET Rating = ?, RatingDev = ?, Volatility = ?, LastRated = ? WHERE Uid = ?",
+			r.Rating, r.Dev, r.Vol, u.GetLastRated(), u.GetUid()); err != nil {
+			log.Error("can not update the rating of user %v: %v", u.Nickname, err)
+			tx.Rollback()
+			return
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		log.Error("can not commit the ratings: %v", err)
+	}
+}
+
 // store session into db before the program exit
 func storeSession(sesses map[string]map[string]interface{}) {
 	tx, err := db.Begin()
@@ -228,7 +544,7 @@ func queryUsers() []*types.User {
 		u := &types.User{}
 		if err := rows.Scan(&u.Uid, &u.Avatar, &u.Email, &u.Password, &u.Nickname,
 			&u.Energy, &u.Level, &u.Win, &u.Lose, &u.Addr, &u.Balance, &u.Freezed,
-			&u.Updated); err != nil {
+			&u.Updated, &u.Rating, &u.RatingDev, &u.Volatility, &u.LastRated, &u.Chips, &u.FreezedChips); err != nil {
 			log.Error("can not scan user, error: %v", err)
 			return nil
 		}
@@ -244,7 +560,7 @@ func queryUser(field string, val interface{}) *types.User {
 	u := &types.User{}
 	if err := row.Scan(&u.Uid, &u.Avatar, &u.Email, &u.Password, &u.Nickname,
 		&u.Energy, &u.Level, &u.Win, &u.Lose, &u.Addr, &u.Balance, &u.Freezed,
-		&u.Updated); err != nil {
+		&u.Updated, &u.Rating, &u.RatingDev, &u.Volatility, &u.LastRated, &u.Chips, &u.FreezedChips); err != nil {
 		log.Error("can not scan user, error: %v", err)
 		return nil
 	}
@@ -284,3 +600,1186 @@ func deleteSessions() {
 		log.Debug("can not delete all sessions in database: %v", err)
 	}
 }
+
+// suspect reported by game server
+func insertSuspect(tid, uid int, reason string) {
+	if _, err := db.Exec("INSERT INTO suspects(tid, uid, reason, created) VALUES(?, ?, ?, ?)",
+		tid, uid, reason, time.Now().Unix()); err != nil {
+		log.Error("can not insert suspect %d of table %d: %v", uid, tid, err)
+	}
+}
+
+// game result held for review, returns the id
+func insertHeldResult(hr *heldResult) (int, error) {
+	reasons, err := json.Marshal(hr.Reasons)
+	if err != nil {
+		return -1, err
+	}
+	res, err := db.Exec("INSERT INTO held_results(tid, winner, loser, bet, currency, reasons, status, created) VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+		hr.Tid, hr.Winner, hr.Loser, hr.Bet, hr.Currency, reasons, heldPending, hr.Created)
+	if err != nil {
+		log.Error("can not insert held result of table %d: %v", hr.Tid, err)
+		return -1, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// update status of held result
+// update the status of the held result, with the entry and the users of the review in one transaction
+func updateHeldResult(id, status int, e *types.JournalEntry, us ...*types.User) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = func() error {
+		if _, err := tx.Exec("UPDATE held_results SET status = ? WHERE id = ?", status, id); err != nil {
+			return err
+		}
+		if err := storeEntryTx(tx, e); err != nil {
+			return err
+		}
+		return updateUserRows(tx, us...)
+	}(); err != nil {
+		tx.Rollback()
+		log.Error("can not update held result %d to status %d: %v", id, status, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// query all relations between users
+func queryFriends() [][3]int {
+	rows, err := db.Query("SELECT uid, fid, relation FROM friends")
+	if err != nil {
+		log.Error("can not query friends: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	res := make([][3]int, 0)
+	for rows.Next() {
+		var r [3]int
+		if err := rows.Scan(&r[0], &r[1], &r[2]); err != nil {
+			log.Error("can not scan friend: %v", err)
+			return nil
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// insert or update the relation
+func updateFriend(uid, fid, relation int) {
+	if _, err := db.Exec("INSERT INTO friends(uid, fid, relation, created) VALUES(?, ?, ?, ?) ON DUPLICATE KEY UPDATE relation = ?",
+		uid, fid, relation, time.Now().Unix(), relation); err != nil {
+		log.Error("can not update relation of %d to %d: %v", uid, fid, err)
+	}
+}
+
+// delete the relation
+func deleteFriend(uid, fid int) {
+	if _, err := db.Exec("DELETE FROM friends WHERE uid = ? AND fid = ?", uid, fid); err != nil {
+		log.Error("can not delete relation of %d to %d: %v", uid, fid, err)
+	}
+}
+
+// direct message
+func insertMessage(msg *directMessage) error {
+	res, err := db.Exec("INSERT INTO messages(sender, receiver, content, isRead, created) VALUES(?, ?, ?, ?, ?)",
+		msg.Sender, msg.Receiver, msg.Content, 0, msg.Created)
+	if err != nil {
+		log.Error("can not insert message from %d to %d: %v", msg.Sender, msg.Receiver, err)
+		return err
+	}
+	id, err := res.LastInsertId()
+	msg.Id = int(id)
+	return err
+}
+
+func scanMessages(rows *sql.Rows) ([]*directMessage, error) {
+	defer rows.Close()
+	msgs := make([]*directMessage, 0)
+	for rows.Next() {
+		msg := new(directMessage)
+		if err := rows.Scan(&msg.Id, &msg.Sender, &msg.Receiver, &msg.Content, &msg.Read, &msg.Created); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// unread messages of the user, they are marked read
+func queryUnreadMessages(uid int) ([]*directMessage, error) {
+	rows, err := db.Query("SELECT id, sender, receiver, content, isRead, created FROM messages WHERE receiver = ? AND isRead = 0 ORDER BY id",
+		uid)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rows)
+	if err != nil || len(msgs) == 0 {
+		return msgs, err
+	}
+	_, err = db.Exec("UPDATE messages SET isRead = 1 WHERE receiver = ? AND id <= ?", uid, msgs[len(msgs)-1].Id)
+	return msgs, err
+}
+
+// the latest messages between two users
+func queryConversation(a, b, limit int) ([]*directMessage, error) {
+	rows, err := db.Query(`SELECT id, sender, receiver, content, isRead, created FROM messages
+		WHERE (sender = ? AND receiver = ?) OR (sender = ? AND receiver = ?) ORDER BY id DESC LIMIT ?`,
+		a, b, b, a, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// log the chat message
+func insertChatLog(channel, uid int, content string) {
+	if _, err := db.Exec("INSERT INTO chat_log(channel, uid, content, created) VALUES(?, ?, ?, ?)",
+		channel, uid, content, time.Now().Unix()); err != nil {
+		log.Error("can not log chat message of %d in channel %d: %v", uid, channel, err)
+	}
+}
+
+// query all chat sanctions
+func querySanctions() []*sanction {
+	rows, err := db.Query("SELECT uid, kind, until, byUid, reason FROM chat_sanctions")
+	if err != nil {
+		log.Error("can not query chat sanctions: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	res := make([]*sanction, 0)
+	for rows.Next() {
+		s := new(sanction)
+		if err := rows.Scan(&s.Uid, &s.Kind, &s.Until, &s.By, &s.Reason); err != nil {
+			log.Error("can not scan chat sanction: %v", err)
+			return nil
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+// insert or update the chat sanction
+func insertSanction(s *sanction) {
+	if _, err := db.Exec(`INSERT INTO chat_sanctions(uid, kind, until, byUid, reason, created) VALUES(?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE until = ?, byUid = ?, reason = ?`,
+		s.Uid, s.Kind, s.Until, s.By, s.Reason, time.Now().Unix(), s.Until, s.By, s.Reason); err != nil {
+		log.Error("can not insert chat sanction of %d: %v", s.Uid, err)
+	}
+}
+
+// delete the chat sanction
+func deleteSanction(uid, kind int) {
+	if _, err := db.Exec("DELETE FROM chat_sanctions WHERE uid = ? AND kind = ?", uid, kind); err != nil {
+		log.Error("can not delete chat sanction of %d: %v", uid, err)
+	}
+}
+
+// query the scores of the leaderboard in the period
+func queryLeaderboard(board, period string) map[int]float64 {
+	rows, err := db.Query("SELECT uid, score FROM leaderboards WHERE board = ? AND period = ?", board, period)
+	if err != nil {
+		log.Error("can not query leaderboard %s of %s: %v", board, period, err)
+		return nil
+	}
+	defer rows.Close()
+	res := make(map[int]float64)
+	for rows.Next() {
+		var uid int
+		var score float64
+		if err := rows.Scan(&uid, &score); err != nil {
+			log.Error("can not scan leaderboard %s of %s: %v", board, period, err)
+			return nil
+		}
+		res[uid] = score
+	}
+	return res
+}
+
+// insert or update the score of the user
+func updateLeaderboard(board, period string, uid int, score float64) {
+	if _, err := db.Exec("INSERT INTO leaderboards(board, period, uid, score) VALUES(?, ?, ?, ?) ON DUPLICATE KEY UPDATE score = ?",
+		board, period, uid, score, score); err != nil {
+		log.Error("can not update leaderboard %s of %s for user %d: %v", board, period, uid, err)
+	}
+}
+
+// insert the match, with the settle entry if any and the users of the result in one transaction
+func insertMatch(mr *matchRecord, e *types.JournalEntry, us ...*types.User) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Error("can not start transaction for the match of table %d: %v", mr.Tid, err)
+		return
+	}
+	if _, err = tx.Exec(`INSERT INTO matches(tid, mode, bet, currency, winner, loser, winnerKo, loserKo, winnerLines, loserLines,
+		duration, endReason, server, replay, created) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		mr.Tid, mr.Mode, mr.Bet, mr.Currency, mr.Winner, mr.Loser, mr.WinnerKo, mr.LoserKo, mr.WinnerLines, mr.LoserLines,
+		mr.Duration, mr.EndReason, mr.Server, mr.Replay, mr.Created); err != nil {
+		log.Error("can not insert the match of table %d: %v", mr.Tid, err)
+		tx.Rollback()
+		return
+	}
+	if err = storeEntryTx(tx, e); err != nil {
+		log.Critical("can not store the settlement of the match of table %d: %v", mr.Tid, err)
+		tx.Rollback()
+		return
+	}
+	if err = updateUserRows(tx, us...); err != nil {
+		log.Error("can not update the users with the match of table %d: %v", mr.Tid, err)
+		tx.Rollback()
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Error("can not commit the match of table %d: %v", mr.Tid, err)
+	}
+}
+
+const sqlSelectMatches = `SELECT id, tid, mode, bet, currency, winner, loser, winnerKo, loserKo, winnerLines, loserLines,
+	duration, endReason, server, replay, created FROM matches `
+
+func scanMatches(rows *sql.Rows) ([]*matchRecord, error) {
+	defer rows.Close()
+	mrs := make([]*matchRecord, 0)
+	for rows.Next() {
+		mr := new(matchRecord)
+		if err := rows.Scan(&mr.Id, &mr.Tid, &mr.Mode, &mr.Bet, &mr.Currency, &mr.Winner, &mr.Loser, &mr.WinnerKo, &mr.LoserKo,
+			&mr.WinnerLines, &mr.LoserLines, &mr.Duration, &mr.EndReason, &mr.Server, &mr.Replay, &mr.Created); err != nil {
+			return nil, err
+		}
+		mrs = append(mrs, mr)
+	}
+	return mrs, nil
+}
+
+// the latest matches of the user
+func queryMatches(uid, offset, limit int) ([]*matchRecord, error) {
+	rows, err := db.Query(sqlSelectMatches+"WHERE winner = ? OR loser = ? ORDER BY id DESC LIMIT ?, ?",
+		uid, uid, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMatches(rows)
+}
+
+// the latest matches between two users
+func queryHeadToHead(a, b, offset, limit int) ([]*matchRecord, error) {
+	rows, err := db.Query(sqlSelectMatches+"WHERE (winner = ? AND loser = ?) OR (winner = ? AND loser = ?) ORDER BY id DESC LIMIT ?, ?",
+		a, b, b, a, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMatches(rows)
+}
+
+// number of games the user wins against the opponent
+func countWinsAgainst(uid, opponent int) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM matches WHERE winner = ? AND loser = ?", uid, opponent).Scan(&n)
+	return n, err
+}
+
+// query all unlocked achievements
+func queryAchievements() []achievementUnlock {
+	rows, err := db.Query("SELECT uid, achievement, unlocked FROM achievements")
+	if err != nil {
+		log.Error("can not query achievements: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	res := make([]achievementUnlock, 0)
+	for rows.Next() {
+		var a achievementUnlock
+		if err := rows.Scan(&a.uid, &a.id, &a.unlocked); err != nil {
+			log.Error("can not scan achievement: %v", err)
+			return nil
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+// insert the unlocked achievement
+func insertAchievement(uid int, id string, unlocked int64) {
+	if _, err := db.Exec("INSERT IGNORE INTO achievements(uid, achievement, unlocked) VALUES(?, ?, ?)",
+		uid, id, unlocked); err != nil {
+		log.Error("can not insert achievement %s of user %d: %v", id, uid, err)
+	}
+}
+
+// the active season, nil if there is none
+func queryCurrentSeason() (*season, error) {
+	var id int
+	var name string
+	var start, end int64
+	err := db.QueryRow("SELECT id, name, start, end FROM seasons WHERE status = 0 ORDER BY id DESC LIMIT 1").
+		Scan(&id, &name, &start, &end)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newSeason(id, name, start, end), nil
+}
+
+// insert a season, return the season id
+func insertSeason(name string, start, end int64) (int, error) {
+	res, err := db.Exec("INSERT INTO seasons(name, start, end, status) VALUES(?, ?, ?, 0)", name, start, end)
+	if err != nil {
+		return -1, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func endSeason(id int) error {
+	_, err := db.Exec("UPDATE seasons SET status = 1 WHERE id = ?", id)
+	return err
+}
+
+// number of rated games of the players in the season
+func querySeasonGames(sid int) map[int]int {
+	rows, err := db.Query("SELECT uid, games FROM season_players WHERE season = ?", sid)
+	if err != nil {
+		log.Error("can not query players of season %d: %v", sid, err)
+		return nil
+	}
+	defer rows.Close()
+	res := make(map[int]int)
+	for rows.Next() {
+		var uid, games int
+		if err := rows.Scan(&uid, &games); err != nil {
+			log.Error("can not scan player of season %d: %v", sid, err)
+			return nil
+		}
+		res[uid] = games
+	}
+	return res
+}
+
+func updateSeasonGames(sid, uid, games int) {
+	if _, err := db.Exec("INSERT INTO season_players(season, uid, games) VALUES(?, ?, ?) ON DUPLICATE KEY UPDATE games = ?",
+		sid, uid, games, games); err != nil {
+		log.Error("can not update games of user %d in season %d: %v", uid, sid, err)
+	}
+}
+
+// the final rank and rating of the player in the season
+func updateSeasonPlayer(sid, uid, rank int, rating float64) error {
+	_, err := db.Exec("UPDATE season_players SET finalRank = ?, finalRating = ? WHERE season = ? AND uid = ?",
+		rank, rating, sid, uid)
+	return err
+}
+
+// insert a prediction pool, return the pool id
+func insertPredictionPool(tid int) (int, error) {
+	res, err := db.Exec("INSERT INTO prediction_pools(tid, status, created) VALUES(?, ?, ?)",
+		tid, predictionPending, time.Now().Unix())
+	if err != nil {
+		return -1, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// insert the stake of the prediction and the freezed balance in one transaction
+func insertPrediction(pool int, u *types.User, side, stake int) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Error("can not start transaction for the prediction of %v: %v", u.Nickname, err)
+		return
+	}
+	if _, err = tx.Exec(`INSERT INTO predictions(pool, uid, side, stake, status, created) VALUES(?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE stake = stake + ?`,
+		pool, u.GetUid(), side, stake, predictionPending, time.Now().Unix(), stake); err != nil {
+		log.Error("can not insert the prediction of %v in pool %d: %v", u.Nickname, pool, err)
+		tx.Rollback()
+		return
+	}
+	sql, args := u.SqlGeneratorUpdate()
+	if _, err = tx.Exec(sql, args...); err != nil {
+		log.Error("can not update user %v with the prediction: %v", u.Nickname, err)
+		tx.Rollback()
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Error("can not commit the prediction of %v: %v", u.Nickname, err)
+	}
+}
+
+// settle or refund the prediction pool, the payouts and the users are updated in one transaction
+func settlePredictionPool(pool, side, fee, status int, payouts map[int]int, us ...*types.User) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Error("can not start transaction for the prediction pool %d: %v", pool, err)
+		return
+	}
+	if err = func() error {
+		if _, err := tx.Exec("UPDATE prediction_pools SET winnerSide = ?, fee = ?, status = ?, settled = ? WHERE id = ?",
+			side, fee, status, time.Now().Unix(), pool); err != nil {
+			return err
+		}
+		for uid, payout := range payouts {
+			if _, err := tx.Exec("UPDATE predictions SET payout = ?, status = ? WHERE pool = ? AND uid = ?",
+				payout, status, pool, uid); err != nil {
+				return err
+			}
+		}
+		for _, u := range us {
+			sql, args := u.SqlGeneratorUpdate()
+			if _, err := tx.Exec(sql, args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}(); err != nil {
+		log.Error("can not settle the prediction pool %d: %v", pool, err)
+		tx.Rollback()
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Error("can not commit the prediction pool %d: %v", pool, err)
+	}
+}
+
+func queryTemplates() []*tournamentTemplate {
+	rows, err := db.Query("SELECT id, name, size, feeKind, fee, prize, split, ruleset, recurrence, registerMinutes, enabled, format, minPlayers FROM tournament_templates")
+	if err != nil {
+		log.Error("can not query tournament templates: %v", err)
+		return nil
+	}
+	defer rows.Close()
+	res := make([]*tournamentTemplate, 0)
+	for rows.Next() {
+		tt := new(tournamentTemplate)
+		var split, recurrence string
+		if err := rows.Scan(&tt.Id, &tt.Name, &tt.Size, &tt.FeeKind, &tt.Fee, &tt.Prize, &split, &tt.Ruleset,
+			&recurrence, &tt.RegisterMinutes, &tt.Enabled, &tt.Format, &tt.MinPlayers); err != nil {
+			log.Error("can not scan tournament template: %v", err)
+			return nil
+		}
+		if tt.Split, err = parseSplit(split); err != nil {
+			log.Error("the prize split of tournament template %d is invalid: %v", tt.Id, err)
+			continue
+		}
+		if tt.Recurrence, err = types.ParseRecurrence(recurrence); err != nil {
+			log.Error("the recurrence of tournament template %d is invalid: %v", tt.Id, err)
+			continue
+		}
+		res = append(res, tt)
+	}
+	return res
+}
+
+// insert a tournament template, return the template id
+func insertTemplate(tt *tournamentTemplate) (int, error) {
+	res, err := db.Exec("INSERT INTO tournament_templates(name, size, feeKind, fee, prize, split, ruleset, recurrence, registerMinutes, enabled, format, minPlayers) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		tt.Name, tt.Size, tt.FeeKind, tt.Fee, tt.Prize, formatSplit(tt.Split), tt.Ruleset, tt.Recurrence.String(), tt.RegisterMinutes, tt.Enabled, tt.Format, tt.MinPlayers)
+	if err != nil {
+		return -1, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func updateTemplateEnabled(id int, enabled bool) error {
+	_, err := db.Exec("UPDATE tournament_templates SET enabled = ? WHERE id = ?", enabled, id)
+	return err
+}
+
+// insert a tournament, return the tournament id
+func insertTournament(template int, name string, start int64) (int, error) {
+	res, err := db.Exec("INSERT INTO tournaments(template, name, start, status) VALUES(?, ?, ?, ?)",
+		template, name, start, tournamentRunning)
+	if err != nil {
+		return -1, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func endTournament(id, status int) {
+	if _, err := db.Exec("UPDATE tournaments SET status = ? WHERE id = ?", status, id); err != nil {
+		log.Error("can not end the tournament %d: %v", id, err)
+	}
+}
+
+func updateTournamentGetters(id, gold, silver int) {
+	if _, err := db.Exec("UPDATE tournaments SET gold = ?, silver = ? WHERE id = ?", gold, silver, id); err != nil {
+		log.Error("can not update the gold and silver getters of tournament %d: %v", id, err)
+	}
+}
+
+// the running tournaments are lost on restart
+func cancelRunningTournaments() error {
+	_, err := db.Exec("UPDATE tournaments SET status = ? WHERE status = ?", tournamentCancelled, tournamentRunning)
+	return err
+}
+
+// the entry fee is frozen, the applicant may apply again after the refund
+func insertEntry(tournament, uid int, feeKind string, fee int) {
+	if _, err := db.Exec("INSERT INTO tournament_entries(tournament, uid, feeKind, fee, status) VALUES(?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE feeKind = VALUES(feeKind), fee = VALUES(fee), status = VALUES(status), payout = 0",
+		tournament, uid, feeKind, fee, entryFrozen); err != nil {
+		log.Error("can not insert the entry of user %d to tournament %d: %v", uid, tournament, err)
+	}
+}
+
+func updateEntry(tournament, uid, status, payout int) {
+	if _, err := db.Exec("UPDATE tournament_entries SET status = ?, payout = ? WHERE tournament = ? AND uid = ?",
+		status, payout, tournament, uid); err != nil {
+		log.Error("can not update the entry of user %d to tournament %d: %v", uid, tournament, err)
+	}
+}
+
+// the entries of the tournaments interrupted by the restart, not refunded yet
+func queryInterruptedEntries() ([]interruptedEntry, error) {
+	rows, err := db.Query("SELECT e.tournament, e.uid, e.feeKind, e.fee, e.status FROM tournament_entries e JOIN tournaments t ON t.id = e.tournament WHERE t.status = ? AND e.status != ?",
+		tournamentRunning, entryRefunded)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]interruptedEntry, 0)
+	for rows.Next() {
+		var e interruptedEntry
+		if err := rows.Scan(&e.tournament, &e.uid, &e.feeKind, &e.fee, &e.status); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// archive the bracket of the finished tournament
+func archiveTournament(ts tournamentSummary, matches []bracketMatch, standings []bracketStanding) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Error("can not archive the tournament %d: %v", ts.Id, err)
+		return
+	}
+	if err = func() error {
+		if _, err := tx.Exec("UPDATE tournaments SET format = ?, pool = ? WHERE id = ?", ts.Format, ts.Pool, ts.Id); err != nil {
+			return err
+		}
+		for _, m := range matches {
+			if _, err := tx.Exec("REPLACE INTO tournament_matches(tournament, matchId, round, stage, p1, p2, nick1, nick2, winner, score1, score2, tid, status, replay) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				ts.Id, m.Id, m.Round, m.Stage, m.P1, m.P2, m.Nick1, m.Nick2, m.Winner, m.Score1, m.Score2, m.Tid, m.Status, m.Replay); err != nil {
+				return err
+			}
+		}
+		for _, s := range standings {
+			if _, err := tx.Exec("REPLACE INTO tournament_standings(tournament, uid, nickname, place, wins, losses, payout) VALUES(?, ?, ?, ?, ?, ?, ?)",
+				ts.Id, s.Uid, s.Nickname, s.Rank, s.Wins, s.Losses, s.Payout); err != nil {
+				return err
+			}
+		}
+		return nil
+	}(); err != nil {
+		tx.Rollback()
+		log.Error("can not archive the tournament %d: %v", ts.Id, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Error("can not archive the tournament %d: %v", ts.Id, err)
+	}
+}
+
+const sqlSelectTournamentSummary = "SELECT t.id, t.template, t.name, t.format, t.start, t.status, t.pool, IFNULL(g.Nickname, ''), IFNULL(s.Nickname, '') FROM tournaments t LEFT JOIN users g ON g.Uid = t.gold LEFT JOIN users s ON s.Uid = t.silver"
+
+func scanTournamentSummary(row interface {
+	Scan(dest ...interface{}) error
+}) (tournamentSummary, error) {
+	var ts tournamentSummary
+	err := row.Scan(&ts.Id, &ts.Template, &ts.Name, &ts.Format, &ts.Start, &ts.Status, &ts.Pool, &ts.Gold, &ts.Silver)
+	return ts, err
+}
+
+// the finished tournaments, the latest first
+func queryTournamentArchive(offset, limit int) ([]tournamentSummary, error) {
+	rows, err := db.Query(sqlSelectTournamentSummary+" WHERE t.status = ? ORDER BY t.start DESC LIMIT ?, ?",
+		tournamentFinished, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]tournamentSummary, 0)
+	for rows.Next() {
+		ts, err := scanTournamentSummary(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ts)
+	}
+	return res, rows.Err()
+}
+
+// the archived bracket of the tournament
+func queryTournamentBracket(id int) (tournamentSummary, []bracketMatch, []bracketStanding, error) {
+	ts, err := scanTournamentSummary(db.QueryRow(sqlSelectTournamentSummary+" WHERE t.id = ?", id))
+	if err == sql.ErrNoRows {
+		err = errTournamentNotArchived
+	}
+	if err != nil {
+		return ts, nil, nil, err
+	}
+	rows, err := db.Query("SELECT matchId, round, stage, p1, p2, nick1, nick2, winner, score1, score2, tid, status, replay FROM tournament_matches WHERE tournament = ? ORDER BY matchId", id)
+	if err != nil {
+		return ts, nil, nil, err
+	}
+	matches := make([]bracketMatch, 0)
+	for rows.Next() {
+		var m bracketMatch
+		if err := rows.Scan(&m.Id, &m.Round, &m.Stage, &m.P1, &m.P2, &m.Nick1, &m.Nick2, &m.Winner, &m.Score1, &m.Score2, &m.Tid, &m.Status, &m.Replay); err != nil {
+			rows.Close()
+			return ts, nil, nil, err
+		}
+		matches = append(matches, m)
+	}
+	rows.Close()
+	rows, err = db.Query("SELECT uid, nickname, place, wins, losses, payout FROM tournament_standings WHERE tournament = ? ORDER BY place, uid", id)
+	if err != nil {
+		return ts, nil, nil, err
+	}
+	defer rows.Close()
+	standings := make([]bracketStanding, 0)
+	for rows.Next() {
+		var s bracketStanding
+		if err := rows.Scan(&s.Uid, &s.Nickname, &s.Rank, &s.Wins, &s.Losses, &s.Payout); err != nil {
+			return ts, nil, nil, err
+		}
+		standings = append(standings, s)
+	}
+	return ts, matches, standings, rows.Err()
+}
+
+// store the journal entry in the transaction
+func insertJournalEntry(tx *sql.Tx, e *types.JournalEntry) error {
+	res, err := tx.Exec("INSERT INTO ledger_entries(kind, ref, created) VALUES(?, ?, ?)", e.Kind, e.Ref, e.Created)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	e.Id = int(id)
+	for _, p := range e.Postings {
+		if _, err := tx.Exec("INSERT INTO ledger_postings(entry, account, asset, amount) VALUES(?, ?, ?, ?)",
+			e.Id, p.Account, p.Asset, p.Amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// store the journal entry, all postings or none
+func storeJournalEntry(e *types.JournalEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := insertJournalEntry(tx, e); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// store the journal entry and move the balances of the users by it, all or nothing
+func storeEntry(e *types.JournalEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := storeEntryTx(tx, e); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// store the entry with the balances in the transaction, nothing if the entry is nil
+func storeEntryTx(tx *sql.Tx, e *types.JournalEntry) error {
+	if e == nil {
+		return nil
+	}
+	if err := insertJournalEntry(tx, e); err != nil {
+		return err
+	}
+	return updateUserBalances(tx, e)
+}
+
+// store the settlement without a match, the entry and the users in one transaction
+func storeSettlement(e *types.JournalEntry, us ...*types.User) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Error("can not start transaction for the settlement: %v", err)
+		return
+	}
+	if err = func() error {
+		if err := storeEntryTx(tx, e); err != nil {
+			return err
+		}
+		return updateUserRows(tx, us...)
+	}(); err != nil {
+		log.Critical("can not store the settlement: %v", err)
+		tx.Rollback()
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Critical("can not commit the settlement: %v", err)
+	}
+}
+
+// update the rows of the users in the transaction, the balances are moved by the entries
+func updateUserRows(tx *sql.Tx, us ...*types.User) error {
+	for _, u := range us {
+		sql, args := u.SqlGeneratorUpdate()
+		if _, err := tx.Exec(sql, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move the balances of the user rows by the deltas of the entry
+func updateUserBalances(tx *sql.Tx, e *types.JournalEntry) error {
+	for uid, deltas := range e.UserDeltas() {
+		for ub, delta := range deltas {
+			f, ok := ub.Field()
+			if !ok || delta == 0 {
+				continue
+			}
+			res, err := tx.Exec(fmt.Sprintf("UPDATE users SET %s = %s + ? WHERE Uid = ?", f, f), delta, uid)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n != 1 {
+				return fmt.Errorf("the user %d is not stored", uid)
+			}
+		}
+	}
+	return nil
+}
+
+const sqlSumPostings = "SELECT account, asset, SUM(amount) FROM ledger_postings GROUP BY account, asset"
+
+func scanPostingSums(rows *sql.Rows, l *types.Ledger) error {
+	defer rows.Close()
+	for rows.Next() {
+		var account, asset string
+		var balance int
+		if err := rows.Scan(&account, &asset, &balance); err != nil {
+			return err
+		}
+		l.Load(account, asset, balance)
+	}
+	return rows.Err()
+}
+
+// load the balances of the ledger from the postings
+func loadLedger() error {
+	rows, err := db.Query(sqlSumPostings)
+	if err != nil {
+		return err
+	}
+	return scanPostingSums(rows, ledger)
+}
+
+// the balances of the stored postings and the stored users
+// read in one transaction, the entries stored with the users are seen with them or not at all
+func queryStoredBalances() (*types.Ledger, []*types.User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+	l := types.NewLedger()
+	rows, err := tx.Query(sqlSumPostings)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := scanPostingSums(rows, l); err != nil {
+		return nil, nil, err
+	}
+	if rows, err = tx.Query("SELECT * FROM users"); err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	us := make([]*types.User, 0)
+	for rows.Next() {
+		u := &types.User{}
+		if err := rows.Scan(&u.Uid, &u.Avatar, &u.Email, &u.Password, &u.Nickname,
+			&u.Energy, &u.Level, &u.Win, &u.Lose, &u.Addr, &u.Balance, &u.Freezed,
+			&u.Updated, &u.Rating, &u.RatingDev, &u.Volatility, &u.LastRated, &u.Chips, &u.FreezedChips); err != nil {
+			return nil, nil, err
+		}
+		us = append(us, u)
+	}
+	return l, us, rows.Err()
+}
+
+// store the new deposit, false if it is known
+// the deposits credited before the tracking are in accounting, they are stored as credited
+func insertDepositRecord(d *wallet.Deposit) (bool, error) {
+	status := wallet.DepositPending
+	row := db.QueryRow("SELECT 1 FROM accounting WHERE isDeposit = 1 AND txid = ?", d.TxId)
+	if row.Scan(new(int)) != sql.ErrNoRows {
+		status = wallet.DepositCredited
+	}
+	tNow := time.Now().Unix()
+	res, err := db.Exec("INSERT IGNORE INTO deposits(txid, address, account, amount, confirmations, status, created, updated) VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+		d.TxId, d.Address, d.Account, d.Amount, d.Confirmations, status, tNow, tNow)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1 && status == wallet.DepositPending, err
+}
+
+func updateDepositRecord(d *wallet.Deposit) error {
+	_, err := db.Exec("UPDATE deposits SET confirmations = ?, status = ?, updated = ? WHERE txid = ? AND address = ?",
+		d.Confirmations, d.Status, time.Now().Unix(), d.TxId, d.Address)
+	return err
+}
+
+// credit the deposit to the balance, with the accounting and the journal entry
+// the part covered by the house while it is unconfirmed is not credited again
+func creditDeposit(d *wallet.Deposit, e *types.JournalEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = func() error {
+		if _, err := tx.Exec("INSERT INTO accounting(txid, amount, account, address, isDeposit, kind, created) VALUES(?, ?, ?, ?, ?, ?, ?)",
+			d.TxId, d.Amount, d.Account, d.Address, 1, journalDeposit, e.Created); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE users SET Balance = Balance + ? WHERE Nickname = ?", d.Amount-d.Covered, d.Account); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE deposits SET confirmations = ?, status = ?, covered = 0, updated = ? WHERE txid = ? AND address = ?",
+			d.Confirmations, d.Status, e.Created, d.TxId, d.Address); err != nil {
+			return err
+		}
+		return insertJournalEntry(tx, e)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// take the reversed deposit back from the balance, with the accounting and the journal entry
+// the credit of the unconfirmed deposit is taken out of the accounting, it is credited again once confirmed
+func reverseDeposit(d *wallet.Deposit, take int, e *types.JournalEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = func() error {
+		if d.Status == wallet.DepositUnconfirmed {
+			if _, err := tx.Exec("DELETE FROM accounting WHERE txid = ? AND isDeposit = 1", d.TxId); err != nil {
+				return err
+			}
+		} else if _, err := tx.Exec("INSERT INTO accounting(txid, amount, account, address, isDeposit, kind, created) VALUES(?, ?, ?, ?, ?, ?, ?)",
+			d.TxId+"-reversed", d.Amount, d.Account, d.Address, 0, journalDepositReversed, e.Created); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE users SET Balance = Balance - ? WHERE Nickname = ?", take, d.Account); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE deposits SET confirmations = ?, status = ?, covered = ?, updated = ? WHERE txid = ? AND address = ?",
+			d.Confirmations, d.Status, d.Covered, e.Created, d.TxId, d.Address); err != nil {
+			return err
+		}
+		return insertJournalEntry(tx, e)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// the deposits not final, the credited are tracked until the confirmations of final
+func queryTrackedDeposits(final int) ([]*wallet.Deposit, error) {
+	rows, err := db.Query("SELECT txid, address, account, amount, confirmations, status, covered FROM deposits WHERE status IN (?, ?, ?) OR (status = ? AND confirmations < ?)",
+		wallet.DepositPending, wallet.DepositConfirmed, wallet.DepositUnconfirmed, wallet.DepositCredited, final)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*wallet.Deposit, 0)
+	for rows.Next() {
+		d := new(wallet.Deposit)
+		if err := rows.Scan(&d.TxId, &d.Address, &d.Account, &d.Amount, &d.Confirmations, &d.Status, &d.Covered); err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+// the block hash of the cursor, empty if it is not saved
+func queryWalletCursor(name string) (string, error) {
+	var blockHash string
+	err := db.QueryRow("SELECT blockHash FROM wallet_cursors WHERE name = ?", name).Scan(&blockHash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return blockHash, err
+}
+
+func updateWalletCursor(name, blockHash string) error {
+	_, err := db.Exec("INSERT INTO wallet_cursors(name, blockHash) VALUES(?, ?) ON DUPLICATE KEY UPDATE blockHash = VALUES(blockHash)", name, blockHash)
+	return err
+}
+
+const sqlSelectWithdrawal = "SELECT id, uid, nickname, address, amount, idemKey, status, batch, txid, created, updated FROM withdrawals"
+
+func scanWithdrawal(row interface {
+	Scan(dest ...interface{}) error
+}) (*withdrawal, error) {
+	w := new(withdrawal)
+	err := row.Scan(&w.Id, &w.Uid, &w.Nickname, &w.Address, &w.Amount, &w.Key, &w.Status, &w.Batch, &w.TxId, &w.Created, &w.Updated)
+	return w, err
+}
+
+func queryWithdrawals(query string, args ...interface{}) ([]*withdrawal, error) {
+	rows, err := db.Query(sqlSelectWithdrawal+" "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*withdrawal, 0)
+	for rows.Next() {
+		w, err := scanWithdrawal(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, w)
+	}
+	return res, rows.Err()
+}
+
+// nil if it is not exist
+func queryWithdrawal(id int) (*withdrawal, error) {
+	w, err := scanWithdrawal(db.QueryRow(sqlSelectWithdrawal+" WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+// nil if it is not exist
+func queryWithdrawalByKey(uid int, key string) (*withdrawal, error) {
+	w, err := scanWithdrawal(db.QueryRow(sqlSelectWithdrawal+" WHERE uid = ? AND idemKey = ?", uid, key))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+// the oldest first
+func queryWithdrawalsByStatus(status, offset, limit int) ([]*withdrawal, error) {
+	return queryWithdrawals("WHERE status = ? ORDER BY id LIMIT ?, ?", status, offset, limit)
+}
+
+func queryWithdrawalsOfBatch(batch int) ([]*withdrawal, error) {
+	return queryWithdrawals("WHERE batch = ? ORDER BY id", batch)
+}
+
+// the latest first
+func queryWithdrawalsOf(uid, offset, limit int) ([]*withdrawal, error) {
+	return queryWithdrawals("WHERE uid = ? ORDER BY id DESC LIMIT ?, ?", uid, offset, limit)
+}
+
+// the amounts requested since the time by the user and by all, the failed and the rejected are not counted
+func queryWithdrawnSince(uid int, since int64) (userSum, totalSum int, err error) {
+	err = db.QueryRow("SELECT COALESCE(SUM(CASE WHEN uid = ? THEN amount ELSE 0 END), 0), COALESCE(SUM(amount), 0) FROM withdrawals WHERE created >= ? AND status NOT IN (?, ?)",
+		uid, since, withdrawFailed, withdrawRejected).Scan(&userSum, &totalSum)
+	return
+}
+
+// the amounts of the withdrawals not finished by uid
+func queryOutstandingWithdrawals() (map[int]int, error) {
+	rows, err := db.Query("SELECT uid, SUM(amount) FROM withdrawals WHERE status IN (?, ?, ?) GROUP BY uid",
+		withdrawPending, withdrawReview, withdrawSending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make(map[int]int)
+	for rows.Next() {
+		var uid, amount int
+		if err := rows.Scan(&uid, &amount); err != nil {
+			return nil, err
+		}
+		res[uid] = amount
+	}
+	return res, rows.Err()
+}
+
+// the request freezes the amount, with the journal entry
+func insertWithdrawal(w *withdrawal, e *types.JournalEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = func() error {
+		res, err := tx.Exec("INSERT INTO withdrawals(uid, nickname, address, amount, idemKey, status, created, updated) VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+			w.Uid, w.Nickname, w.Address, w.Amount, w.Key, w.Status, w.Created, w.Updated)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		w.Id = int(id)
+		if _, err := tx.Exec("UPDATE users SET Balance = Balance - ?, Freezed = Freezed + ? WHERE Uid = ?", w.Amount, w.Amount, w.Uid); err != nil {
+			return err
+		}
+		return insertJournalEntry(tx, e)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func updateWithdrawalStatus(id, status int) error {
+	_, err := db.Exec("UPDATE withdrawals SET status = ?, updated = ? WHERE id = ?", status, time.Now().Unix(), id)
+	return err
+}
+
+// the ids of the batches of the status
+func queryWithdrawalBatches(status int) ([]int, error) {
+	rows, err := db.Query("SELECT id FROM withdrawal_batches WHERE status = ? ORDER BY id", status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		res = append(res, id)
+	}
+	return res, rows.Err()
+}
+
+// put the withdrawals to a new batch in sending
+func insertWithdrawalBatch(ws []*withdrawal) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	var batch int
+	if err = func() error {
+		now := time.Now().Unix()
+		res, err := tx.Exec("INSERT INTO withdrawal_batches(status, created, updated) VALUES(?, ?, ?)", withdrawSending, now, now)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		batch = int(id)
+		for _, w := range ws {
+			if _, err := tx.Exec("UPDATE withdrawals SET status = ?, batch = ?, updated = ? WHERE id = ?", withdrawSending, batch, now, w.Id); err != nil {
+				return err
+			}
+			w.Status, w.Batch, w.Updated = withdrawSending, batch, now
+		}
+		return nil
+	}(); err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	return batch, tx.Commit()
+}
+
+// the batch is sent, the frozen goes to the wallet, with the accounting and the journal entry
+func completeWithdrawals(batch int, txid string, ws []*withdrawal, e *types.JournalEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = func() error {
+		if _, err := tx.Exec("UPDATE withdrawal_batches SET status = ?, txid = ?, updated = ? WHERE id = ?", withdrawSent, txid, e.Created, batch); err != nil {
+			return err
+		}
+		for _, w := range ws {
+			if _, err := tx.Exec("UPDATE withdrawals SET status = ?, txid = ?, updated = ? WHERE id = ?", withdrawSent, txid, e.Created, w.Id); err != nil {
+				return err
+			}
+			// a transaction of the batch pays many withdrawals
+			if _, err := tx.Exec("INSERT INTO accounting(txid, amount, account, address, isDeposit, kind, created) VALUES(?, ?, ?, ?, ?, ?, ?)",
+				fmt.Sprintf("%s-%d", txid, w.Id), w.Amount, w.Nickname, w.Address, 0, journalWithdraw, e.Created); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("UPDATE users SET Freezed = Freezed - ? WHERE Uid = ?", w.Amount, w.Uid); err != nil {
+				return err
+			}
+		}
+		return insertJournalEntry(tx, e)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// the withdrawals failed or rejected, the frozen returns to the balance, with the journal entry
+// the batch is 0 for a rejected withdrawal
+func releaseWithdrawals(status, batch int, ws []*withdrawal, e *types.JournalEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = func() error {
+		if batch > 0 {
+			if _, err := tx.Exec("UPDATE withdrawal_batches SET status = ?, updated = ? WHERE id = ?", status, e.Created, batch); err != nil {
+				return err
+			}
+		}
+		for _, w := range ws {
+			if _, err := tx.Exec("UPDATE withdrawals SET status = ?, updated = ? WHERE id = ?", status, e.Created, w.Id); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("UPDATE users SET Balance = Balance + ?, Freezed = Freezed - ? WHERE Uid = ?", w.Amount, w.Amount, w.Uid); err != nil {
+				return err
+			}
+		}
+		return insertJournalEntry(tx, e)
+	}(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// the last run of the scheduled job, 0 if it never runs
+func querySchedule(name string) (int64, error) {
+	var lastRun int64
+	err := db.QueryRow("SELECT lastRun FROM schedules WHERE name = ?", name).Scan(&lastRun)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return lastRun, err
+}
+
+func updateSchedule(name string, lastRun int64) error {
+	_, err := db.Exec("INSERT INTO schedules(name, lastRun) VALUES(?, ?) ON DUPLICATE KEY UPDATE lastRun = VALUES(lastRun)", name, lastRun)
+	return err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gogames/go_tetris/types"
+	"github.com/gogames/go_tetris/wallet"
+)
+
+// the deposits of bitcoin go through pending, confirmed and credited
+// the credited deposits reorged away are reversed
+// the credited deposits reorged below the confirmations are reversed until they are confirmed again
+
+const (
+	// the name of the block cursor of the deposits in the database
+	depositCursor       = "deposit"
+	depositScanInterval = 5 * time.Second
+)
+
+var (
+	depositConfirmations = 3
+	deposits             *wallet.DepositTracker
+)
+
+func initDeposits() {
+	cursor, err := queryWalletCursor(depositCursor)
+	if err != nil {
+		panic("can not query the cursor of the deposits: " + err.Error())
+	}
+	tracked, err := queryTrackedDeposits(depositConfirmations + wallet.DepositReorgDepth)
+	if err != nil {
+		panic("can not query the tracked deposits: " + err.Error())
+	}
+	deposits = wallet.NewDepositTracker(btcWallet, depositHandler{}, depositConfirmations, cursor, tracked...)
+	go scanDeposits()
+}
+
+func scanDeposits() {
+	for {
+		if err := deposits.Scan(); err != nil {
+			log.Error("can not scan the deposits: %v", err)
+		}
+		time.Sleep(depositScanInterval)
+	}
+}
+
+// store the deposits and move the balances
+type depositHandler struct{}
+
+func (depositHandler) Seen(d *wallet.Deposit) (bool, error) { return insertDepositRecord(d) }
+
+func (depositHandler) Update(d *wallet.Deposit) error { return updateDepositRecord(d) }
+
+func (depositHandler) Credit(d *wallet.Deposit) error {
+	u := getUserByNickname(d.Account)
+	if u == nil {
+		return fmt.Errorf(errUserNotExist, d.Account)
+	}
+	// the house is paid back the part it covered while the deposit is unconfirmed
+	ps := types.Transfer(types.AccountWallet, availableOf(u.Uid), types.AssetMBTC, d.Amount-d.Covered)
+	ps = append(ps, types.Transfer(types.AccountWallet, types.AccountHouse, types.AssetMBTC, d.Covered)...)
+	e, err := newEntry(journalDeposit, d.TxId, ps...)
+	if err != nil {
+		return err
+	}
+	if err := creditDeposit(d, e); err != nil {
+		return err
+	}
+	d.Covered = 0
+	return commitEntry(e)
+}
+
+// the coins are back to the wallet, the balance spent already is the loss of the house
+// the house covers it until the unconfirmed deposit is confirmed again
+func (depositHandler) Reverse(d *wallet.Deposit) error {
+	u := getUserByNickname(d.Account)
+	if u == nil {
+		return fmt.Errorf(errUserNotExist, d.Account)
+	}
+	take := d.Amount
+	if b := u.GetBalance(); b < take {
+		take = b
+	}
+	ps := []types.Posting{
+		types.NewPosting(types.AccountWallet, types.AssetMBTC, d.Amount),
+		types.NewPosting(availableOf(u.Uid), types.AssetMBTC, -take),
+		types.NewPosting(types.AccountHouse, types.AssetMBTC, take-d.Amount),
+	}
+	e, err := postEntry(journalDepositReversed, d.TxId, ps...)
+	if err != nil {
+		return err
+	}
+	if d.Status == wallet.DepositUnconfirmed {
+		d.Covered = d.Amount - take
+	}
+	if err := reverseDeposit(d, take, e); err != nil {
+		d.Covered = 0
+		// undo the posting, reversed again in the next scan
+		for i := range ps {
+			ps[i].Amount = -ps[i].Amount
+		}
+		if _, uerr := postEntry(journalDepositReversed, d.TxId, ps...); uerr != nil {
+			log.Critical("can not undo the reversal of the deposit %s of %v: %v", d.TxId, u.Nickname, uerr)
+		}
+		return err
+	}
+	if d.Status == wallet.DepositUnconfirmed {
+		log.Warn("the deposit %s of %v is reorged below %d confirmations, the credit is reversed, %d mBTC covered by the house",
+			d.TxId, u.Nickname, depositConfirmations, d.Covered)
+	} else if take < d.Amount {
+		log.Critical("the deposit %s of %v is reorged away but spent, the house loses %d mBTC", d.TxId, u.Nickname, d.Amount-take)
+	}
+	return nil
+}
+
+func (depositHandler) SaveCursor(cursor string) error {
+	return updateWalletCursor(depositCursor, cursor)
+}
+
+func wrapDeposit(d wallet.Deposit) map[string]interface{} {
+	status := "pending"
+	if d.Status == wallet.DepositConfirmed {
+		status = "confirmed"
+	}
+	return map[string]interface{}{
+		"txid":          d.TxId,
+		"address":       d.Address,
+		"amount":        d.Amount,
+		"confirmations": d.Confirmations,
+		"required":      deposits.Confirmations(),
+		"status":        status,
+	}
+}
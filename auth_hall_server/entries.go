@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+// entry fees and prize pools of the tournaments
+// the fee is frozen at the application, and refunded if the applicant quits the registration
+// when the tournament starts the mBTC fees go into the prize pool with the house contribution,
+// the energy fees are consumed
+// all fees are refunded if the tournament is cancelled
+
+// status of the entry in database
+const (
+	entryFrozen = iota
+	entryPaid
+	entryRefunded
+)
+
+var (
+	errAlreadyApplied        = fmt.Errorf("你已经报名了")
+	errInsufficientFeeEnergy = fmt.Errorf("能量不足, 无法支付报名费")
+)
+
+// freeze the entry fee of the applicant
+func (t *tournament) freezeFee(u *types.User) error {
+	fee, uid := t.template.Fee, u.GetUid()
+	var ps []types.Posting
+	switch t.template.FeeKind {
+	case feeMBTC:
+		if u.GetBalance() < fee {
+			return errBalNotSufficient
+		}
+		ps = types.Transfer(availableOf(uid), frozenOf(uid), types.AssetMBTC, fee)
+	case feeEnergy:
+		if u.GetEnergy() < fee {
+			return errInsufficientFeeEnergy
+		}
+		ps = types.Transfer(energyOf(uid), types.AccountHouse, types.AssetEnergy, fee)
+	}
+	if err := transact(journalTournamentFee, t.GetId(), ps...); err != nil {
+		log.Critical("can not freeze the entry fee of %v to tournament %d: %v", u.Nickname, t.GetId(), err)
+		return err
+	}
+	t.feesMu.Lock()
+	t.fees[uid] = fee
+	if t.template.FeeKind == feeMBTC {
+		t.pool += fee
+	}
+	t.feesMu.Unlock()
+	id, kind := t.GetId(), t.template.FeeKind
+	pushFunc(func() { insertEntry(id, uid, kind, fee) })
+	return nil
+}
+
+func (t *tournament) hasApplied(uid int) bool {
+	t.feesMu.Lock()
+	defer t.feesMu.Unlock()
+	_, ok := t.fees[uid]
+	return ok
+}
+
+// refund the entry fee of the applicant
+func (t *tournament) refundFee(uid int) {
+	t.feesMu.Lock()
+	fee, ok := t.fees[uid]
+	collected := t.collected
+	if ok {
+		delete(t.fees, uid)
+		if t.template.FeeKind == feeMBTC {
+			t.pool -= fee
+		}
+	}
+	t.feesMu.Unlock()
+	if !ok {
+		return
+	}
+	u := getUserById(uid)
+	if u == nil {
+		log.Critical("can not refund the entry fee %d to user %d of tournament %d, the user is not exist", fee, uid, t.GetId())
+		return
+	}
+	var ps []types.Posting
+	switch t.template.FeeKind {
+	case feeMBTC:
+		// the fee is frozen before the tournament starts, and in the prize pool of the house after
+		from := frozenOf(uid)
+		if collected {
+			from = types.AccountHouse
+		}
+		ps = types.Transfer(from, availableOf(uid), types.AssetMBTC, fee)
+	case feeEnergy:
+		ps = types.Transfer(types.AccountHouse, energyOf(uid), types.AssetEnergy, fee)
+	}
+	if err := transact(journalTournamentRefund, t.GetId(), ps...); err != nil {
+		log.Critical("can not refund the entry fee %d to %v of tournament %d: %v", fee, u.Nickname, t.GetId(), err)
+		return
+	}
+	id := t.GetId()
+	pushFunc(func() { updateEntry(id, uid, entryRefunded, 0) })
+}
+
+// refund all entry fees, the tournament is cancelled
+func (t *tournament) refundFees() {
+	for _, uid := range t.applicants() {
+		t.refundFee(uid)
+	}
+}
+
+func (t *tournament) applicants() []int {
+	t.feesMu.Lock()
+	defer t.feesMu.Unlock()
+	res := make([]int, 0, len(t.fees))
+	for uid := range t.fees {
+		res = append(res, uid)
+	}
+	return res
+}
+
+// the fees of the players become the prize pool when the tournament starts
+// the fees of the applicants not playing are refunded
+func (t *tournament) collectFees(players map[int]int) {
+	for _, uid := range t.applicants() {
+		if _, ok := players[uid]; !ok {
+			t.refundFee(uid)
+		}
+	}
+	t.feesMu.Lock()
+	defer t.feesMu.Unlock()
+	for uid, fee := range t.fees {
+		u := getUserById(uid)
+		if u == nil {
+			log.Critical("can not collect the entry fee %d from user %d of tournament %d, the user is not exist", fee, uid, t.GetId())
+			continue
+		}
+		if t.template.FeeKind == feeMBTC {
+			if err := transact(journalTournamentCollect, t.GetId(), types.Transfer(frozenOf(uid), types.AccountHouse, types.AssetMBTC, fee)...); err != nil {
+				log.Critical("can not collect the entry fee %d from %v of tournament %d: %v", fee, u.Nickname, t.GetId(), err)
+				continue
+			}
+		}
+		id, uid := t.GetId(), uid
+		pushFunc(func() {
+			updateEntry(id, uid, entryPaid, 0)
+		})
+	}
+	t.collected = true
+}
+
+// share the prizes of the places by the standings
+// the players of the same rank share the prizes of their places evenly
+func sharePrizes(prizes []int, standings []types.Standing) map[int]int {
+	payouts := make(map[int]int)
+	for i := 0; i < len(standings) && i < len(prizes); {
+		j := i
+		for j < len(standings) && standings[j].Rank == standings[i].Rank {
+			j++
+		}
+		sum := 0
+		for k := i; k < j && k < len(prizes); k++ {
+			sum += prizes[k]
+		}
+		for k := i; k < j; k++ {
+			if share := sum / (j - i); share > 0 {
+				payouts[standings[k].Uid] = share
+			}
+		}
+		i = j
+	}
+	return payouts
+}
+
+// pay the prize pool to the players by the standings, return the payouts
+// the house pays what the fees do not cover, and keeps the rest of the pool
+func (t *tournament) payPrizes(standings []types.Standing) map[int]int {
+	pool := t.prizePool()
+	payouts := sharePrizes(t.template.prizes(pool), standings)
+	id := t.GetId()
+	for uid, payout := range payouts {
+		u := getUserById(uid)
+		if u == nil {
+			log.Critical("can not pay the prize %d to user %d of tournament %d, the user is not exist", payout, uid, id)
+			continue
+		}
+		if err := transact(journalTournamentPrize, id, types.Transfer(types.AccountHouse, availableOf(uid), types.AssetMBTC, payout)...); err != nil {
+			log.Critical("can not pay the prize %d to %v of tournament %d: %v", payout, u.Nickname, id, err)
+			continue
+		}
+		uid, payout := uid, payout
+		pushFunc(func() { updateEntry(id, uid, entryPaid, payout) })
+	}
+	return payouts
+}
+
+type interruptedEntry struct {
+	tournament, uid, fee, status int
+	feeKind                      string
+}
+
+// refund the entries of the tournaments interrupted by the restart
+// the frozen mBTC fees are back to the balance by the ledger already
+func refundInterruptedEntries() error {
+	entries, err := queryInterruptedEntries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		u := getUserById(e.uid)
+		if u == nil {
+			log.Error("can not refund the entry fee %d %s of user %d to tournament %d, the user is not exist", e.fee, e.feeKind, e.uid, e.tournament)
+			continue
+		}
+		var ps []types.Posting
+		switch {
+		case e.feeKind == feeEnergy:
+			ps = types.Transfer(types.AccountHouse, energyOf(e.uid), types.AssetEnergy, e.fee)
+		case e.status == entryPaid:
+			ps = types.Transfer(types.AccountHouse, availableOf(e.uid), types.AssetMBTC, e.fee)
+		}
+		if err := transact(journalTournamentRefund, e.tournament, ps...); err != nil {
+			log.Error("can not refund the entry fee %d %s of %v to tournament %d: %v", e.fee, e.feeKind, u.Nickname, e.tournament, err)
+			continue
+		}
+		e := e
+		pushFunc(func() { updateEntry(e.tournament, e.uid, entryRefunded, 0) })
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+// relations between users
+var friends = types.NewFriends()
+
+func initFriends() {
+	for _, r := range queryFriends() {
+		friends.Load(r[0], r[1], r[2])
+	}
+	log.Info("initialize friends...")
+}
+
+// write the relations between the users into database
+func persistRelation(a, b int) {
+	for _, p := range [][2]int{{a, b}, {b, a}} {
+		from, to := p[0], p[1]
+		if r, ok := friends.GetRelation(from, to); ok {
+			pushFunc(func() { updateFriend(from, to, r) })
+		} else {
+			pushFunc(func() { deleteFriend(from, to) })
+		}
+	}
+}
+
+// presence of the user
+const (
+	presenceOffline    = "offline"
+	presenceOnline     = "online"
+	presencePlaying    = "playing"
+	presenceSpectating = "spectating"
+)
+
+// presence is derived from the sessions and the busy users
+// the table of a private game is not shown
+func getPresence(uid int) map[string]interface{} {
+	res := map[string]interface{}{"status": presenceOffline}
+	if users.IsBusyUser(uid) {
+		halls := []*types.Tables{normalHall.Tables}
+		for _, t := range director.all() {
+			halls = append(halls, t.Tables)
+		}
+		for _, h := range halls {
+			tid, isOb, ok := h.FindUser(uid)
+			if !ok {
+				continue
+			}
+			res["status"] = presencePlaying
+			if isOb {
+				res["status"] = presenceSpectating
+			}
+			if t := h.GetTableById(tid); t != nil && !t.IsPrivate() {
+				res["table_id"] = tid
+				res["tournament"] = isTournament(tid)
+			}
+			return res
+		}
+	}
+	if session.HasValue(sessKeyUserId, uid) {
+		res["status"] = presenceOnline
+	}
+	return res
+}
+
+// user info shown in the friend list
+func wrapFriend(uid int) map[string]interface{} {
+	u := getUserById(uid)
+	if u == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"id":       uid,
+		"nickname": u.Nickname,
+		"avatar":   u.Avatar,
+		"rating":   u.GetRating().Rating,
+		"presence": getPresence(uid),
+	}
+}
+
+const maxMessageLength = 512
+
+var (
+	errEmptyMessage   = fmt.Errorf("消息不能为空")
+	errMessageTooLong = fmt.Errorf("消息不能超过 %d 个字", maxMessageLength)
+)
+
+// direct message between friends, kept until the receiver reads it
+type directMessage struct {
+	Id, Sender, Receiver int
+	Content              string
+	Read                 bool
+	Created              int64
+}
+
+func newDirectMessage(sender, receiver int, content string) (*directMessage, error) {
+	if content == "" {
+		return nil, errEmptyMessage
+	}
+	if utf8.RuneCountInString(content) > maxMessageLength {
+		return nil, errMessageTooLong
+	}
+	return &directMessage{
+		Sender:   sender,
+		Receiver: receiver,
+		Content:  content,
+		Created:  time.Now().Unix(),
+	}, nil
+}
+
+// for hprose
+func (dm *directMessage) Wrap() map[string]interface{} {
+	var nickname string
+	if u := getUserById(dm.Sender); u != nil {
+		nickname = u.Nickname
+	}
+	return map[string]interface{}{
+		"id":      dm.Id,
+		"sender":  nickname,
+		"from":    dm.Sender,
+		"to":      dm.Receiver,
+		"content": dm.Content,
+		"read":    dm.Read,
+		"created": dm.Created,
+	}
+}
+
+func wrapMessages(msgs []*directMessage) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0, len(msgs))
+	for _, msg := range msgs {
+		res = append(res, msg.Wrap())
+	}
+	return res
+}
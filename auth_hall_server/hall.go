@@ -7,7 +7,6 @@ import (
 )
 
 var normalHall = types.NewNormalHall()
-var tournamentHall *types.TournamentHall
 
 func initHall() {
 	go releaseExpires()
@@ -25,10 +24,14 @@ func releaseExpires() {
 			if err := clients.GetStub(ip).Delete(tid); err != nil {
 				log.Warn("can not inform game server %v to delete table %v: %v", ip, tid, err)
 			}
+			// the game never ends, refund the predictions
+			refundPredictions(tid)
 			// release the busy users in cache, including the observers and players
 			users.SetFree(tt.GetAllUsers()...)
 			// release the expire table also
 			normalHall.ReleaseExpireTable(tid)
+			clearSuspects(tid)
+			clearSeriesGames(tid)
 		}
 		time.Sleep(5 * time.Second)
 	}
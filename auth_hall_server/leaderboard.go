@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+// leaderboards
+const (
+	boardRating = "rating"
+	boardWins   = "wins"
+	boardTitles = "titles"
+	// the net winnings of the bets, a board per currency
+	boardWinnings = "winnings"
+)
+
+// windows of the leaderboards
+// the rating board of a weekly or monthly window ranks the rating gained in it
+const (
+	windowGlobal  = "global"
+	windowWeekly  = "weekly"
+	windowMonthly = "monthly"
+)
+
+const leaderboardPageSize = 20
+
+var (
+	boards = []string{boardRating, boardWins, boardTitles,
+		winningsBoard(types.AssetMBTC), winningsBoard(types.AssetChips)}
+	windows = []string{windowGlobal, windowWeekly, windowMonthly}
+)
+
+var (
+	errInvalidBoard  = fmt.Errorf("排行榜不存在")
+	errNotOnTheBoard = fmt.Errorf("你还没有上榜")
+)
+
+// the winnings board of the currency, e.g. winnings_chips
+func winningsBoard(currency string) string { return boardWinnings + "_" + currency }
+
+// the period of the window at the time, the leaderboard is reset when the period changes
+func periodOf(window string, t time.Time) string {
+	switch window {
+	case windowWeekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case windowMonthly:
+		return t.Format("2006-01")
+	}
+	return windowGlobal
+}
+
+// the leaderboard of a period
+type boardPeriod struct {
+	period string
+	lb     *types.Leaderboard
+}
+
+type leaderboardSet struct {
+	boards map[string]map[string]*boardPeriod // board -> window -> leaderboard
+	mu     sync.Mutex
+}
+
+var leaderboards = &leaderboardSet{boards: make(map[string]map[string]*boardPeriod)}
+
+// the global rating and wins come from the users cache,
+// the others are loaded from database
+func initLeaderboards() {
+	tNow := time.Now()
+	for _, board := range boards {
+		leaderboards.boards[board] = make(map[string]*boardPeriod)
+		for _, window := range windows {
+			bp := &boardPeriod{period: periodOf(window, tNow), lb: types.NewLeaderboard()}
+			leaderboards.boards[board][window] = bp
+			if window == windowGlobal && (board == boardRating || board == boardWins) {
+				continue
+			}
+			for uid, score := range queryLeaderboard(board, bp.period) {
+				bp.lb.Set(uid, score)
+			}
+		}
+	}
+	// only the users who played rated games are ranked by the rating
+	for _, u := range users.GetAllUsers() {
+		if u.GetLastRated() > 0 {
+			leaderboards.boards[boardRating][windowGlobal].lb.Set(u.GetUid(), u.GetRating().Rating)
+		}
+		if u.Win > 0 {
+			leaderboards.boards[boardWins][windowGlobal].lb.Set(u.GetUid(), float64(u.Win))
+		}
+	}
+	log.Info("initialize the leaderboards...")
+}
+
+// get the leaderboard of the current period
+func (ls *leaderboardSet) get(board, window string) (*boardPeriod, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	bp, ok := ls.boards[board][window]
+	if !ok {
+		return nil, errInvalidBoard
+	}
+	if period := periodOf(window, time.Now()); period != bp.period {
+		bp = &boardPeriod{period: period, lb: types.NewLeaderboard()}
+		ls.boards[board][window] = bp
+	}
+	return bp, nil
+}
+
+// set the global score of the user, it is persisted by the users table
+func (ls *leaderboardSet) set(board string, uid int, score float64) {
+	if bp, err := ls.get(board, windowGlobal); err == nil {
+		bp.lb.Set(uid, score)
+	}
+}
+
+// add the score of the user in the windows
+func (ls *leaderboardSet) add(board string, uid int, delta float64, windows ...string) {
+	for _, window := range windows {
+		bp, err := ls.get(board, window)
+		if err != nil {
+			continue
+		}
+		score := bp.lb.Add(uid, delta)
+		period := bp.period
+		pushFunc(func() { updateLeaderboard(board, period, uid, score) })
+	}
+}
+
+// the winner of a game, the users table keeps the global wins
+func recordWin(u *types.User) {
+	leaderboards.set(boardWins, u.GetUid(), float64(u.Win))
+	leaderboards.add(boardWins, u.GetUid(), 1, windowWeekly, windowMonthly)
+}
+
+// the rating changes after a rated game
+func recordRating(u *types.User, before float64) {
+	after := u.GetRating().Rating
+	leaderboards.set(boardRating, u.GetUid(), after)
+	leaderboards.add(boardRating, u.GetUid(), after-before, windowWeekly, windowMonthly)
+}
+
+// the winner takes the bet of the loser in the currency
+func recordWinnings(currency string, winner, loser, bet int) {
+	if bet <= 0 {
+		return
+	}
+	leaderboards.add(winningsBoard(currency), winner, float64(bet), windows...)
+	leaderboards.add(winningsBoard(currency), loser, -float64(bet), windows...)
+}
+
+// the champion of a tournament
+func recordTitle(uid int) {
+	leaderboards.add(boardTitles, uid, 1, windows...)
+}
+
+func wrapRankEntry(rank int, uid int, score float64) map[string]interface{} {
+	nickname := ""
+	if u := getUserById(uid); u != nil {
+		nickname = u.Nickname
+	}
+	return map[string]interface{}{
+		"rank":     rank,
+		"nickname": nickname,
+		"score":    score,
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+// every movement of the balance, the frozen bets and the energy is posted to the ledger
+// the cached users mirror the user accounts of the ledger
+// the stored users are moved with the stored entries in one transaction, the reconciliation checks them
+
+// kinds of the journal entries
+const (
+	journalOpening           = "opening"         // the balances before the ledger
+	journalRestartRelease    = "restart_release" // the frozen of the games lost on restart
+	journalDeposit           = "deposit"
+	journalDepositReversed   = "deposit_reversed" // the deposit reorged away
+	journalWithdraw          = "withdraw"
+	journalWithdrawFailed    = "withdraw_failed"
+	journalBuyEnergy         = "buy_energy"
+	journalSignupEnergy      = "signup_energy"
+	journalEnergyGiveout     = "energy_giveout"
+	journalChipsGiveout      = "chips_giveout"
+	journalGameEnergy        = "game_energy"
+	journalBetFreeze         = "bet_freeze"
+	journalBetSettle         = "bet_settle"
+	journalBetVoid           = "bet_void"
+	journalPredictionStake   = "prediction_stake"
+	journalPredictionSettle  = "prediction_settle"
+	journalSeasonReward      = "season_reward"
+	journalTournamentFee     = "tournament_fee"
+	journalTournamentRefund  = "tournament_refund"
+	journalTournamentCollect = "tournament_collect"
+	journalTournamentPrize   = "tournament_prize"
+)
+
+const reconcileInterval = time.Hour
+
+var (
+	ledger = types.NewLedger()
+	// serialize the posting and the mirroring to the cache
+	ledgerMu sync.Mutex
+)
+
+func availableOf(uid int) string { return types.UserAccount(types.AccountAvailable, uid) }
+
+func frozenOf(uid int) string { return types.UserAccount(types.AccountFrozen, uid) }
+
+func energyOf(uid int) string { return types.UserAccount(types.AccountEnergy, uid) }
+
+// load the ledger, open it with the cached balances if it is new
+// the frozen of the games lost on restart are released
+func initLedger() {
+	if err := loadLedger(); err != nil {
+		panic("can not load the ledger: " + err.Error())
+	}
+	if ledger.IsEmpty() {
+		openLedger()
+	}
+	releaseFrozen()
+	go reconcileLedger()
+	log.Info("initialize the ledger...")
+}
+
+// the balances before the ledger come from the wallet, the energy from the house
+func openLedger() {
+	for _, u := range users.GetAllUsers() {
+		uid := u.GetUid()
+		ps := types.Transfer(types.AccountWallet, availableOf(uid), types.AssetMBTC, u.GetBalance())
+		ps = append(ps, types.Transfer(types.AccountWallet, frozenOf(uid), types.AssetMBTC, u.GetFreezed())...)
+		ps = append(ps, types.Transfer(types.AccountHouse, energyOf(uid), types.AssetEnergy, u.GetEnergy())...)
+		e, err := newEntry(journalOpening, uid, ps...)
+		if err == types.ErrEmptyEntry {
+			continue
+		}
+		if err == nil {
+			err = ledger.Post(e)
+		}
+		if err == nil {
+			err = storeJournalEntry(e)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("can not open the ledger of %v: %v", u.Nickname, err))
+		}
+	}
+}
+
+// the games, the predictions and the registrations are lost on restart
+// return the frozen of all currencies to the balance, except the frozen of the withdrawals not finished
+func releaseFrozen() {
+	outstanding := outstandingWithdrawals()
+	for _, u := range users.GetAllUsers() {
+		uid := u.GetUid()
+		frozen := map[string]int{
+			types.AssetMBTC:  u.GetFreezed() - outstanding[uid],
+			types.AssetChips: u.GetFreezedChips(),
+		}
+		ps := make([]types.Posting, 0, 2*len(frozen))
+		for currency, amount := range frozen {
+			if amount > 0 {
+				ps = append(ps, types.Transfer(frozenOf(uid), availableOf(uid), currency, amount)...)
+			}
+		}
+		e, err := postEntry(journalRestartRelease, uid, ps...)
+		if err == types.ErrEmptyEntry {
+			continue
+		}
+		if err == nil {
+			err = storeEntry(e)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("can not release the frozen of %v: %v", u.Nickname, err))
+		}
+	}
+}
+
+func newEntry(kind string, ref interface{}, postings ...types.Posting) (*types.JournalEntry, error) {
+	return types.NewJournalEntry(kind, fmt.Sprint(ref), postings...)
+}
+
+// post the entry to the ledger and mirror the movements to the cached users
+func commitEntry(e *types.JournalEntry) error {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+	if err := ledger.Post(e); err != nil {
+		return err
+	}
+	for uid, deltas := range e.UserDeltas() {
+		u := getUserById(uid)
+		if u == nil {
+			log.Critical("can not mirror the %s entry %s to user %d, the user is not exist", e.Kind, e.Ref, uid)
+			continue
+		}
+		upts := make([]types.UpdateInterface, 0, len(deltas))
+		for ub, delta := range deltas {
+			if f, ok := ub.Field(); ok {
+				upts = append(upts, types.NewUpdateInt(f, u.GetUserBalance(ub)+delta))
+			}
+		}
+		if err := u.Update(upts...); err != nil {
+			log.Critical("can not mirror the %s entry %s to %v: %v", e.Kind, e.Ref, u.Nickname, err)
+		}
+	}
+	return nil
+}
+
+// post the entry, the caller stores it
+func postEntry(kind string, ref interface{}, postings ...types.Posting) (*types.JournalEntry, error) {
+	e, err := newEntry(kind, ref, postings...)
+	if err != nil {
+		return nil, err
+	}
+	return e, commitEntry(e)
+}
+
+// post the reversal of the postings posted but not stored
+func undoEntry(kind string, ref interface{}, postings ...types.Posting) {
+	ps := make([]types.Posting, len(postings))
+	for i, p := range postings {
+		ps[i] = types.NewPosting(p.Account, p.Asset, -p.Amount)
+	}
+	if _, err := postEntry(kind, ref, ps...); err != nil {
+		log.Critical("can not undo the %s entry %v: %v", kind, ref, err)
+	}
+}
+
+// post the entry and store it with the balances of the users asynchronously, nothing to post is fine
+func transact(kind string, ref interface{}, postings ...types.Posting) error {
+	e, err := postEntry(kind, ref, postings...)
+	if err == types.ErrEmptyEntry {
+		return nil
+	}
+	if err != nil {
+		log.Warn("can not post the %s entry %v: %v", kind, ref, err)
+		return err
+	}
+	pushFunc(func() {
+		if err := storeEntry(e); err != nil {
+			log.Critical("can not store the %s entry %v: %v", kind, ref, err)
+		}
+	})
+	return nil
+}
+
+// check the stored balances of the users against the sum of the stored postings periodically
+func reconcileLedger() {
+	for {
+		time.Sleep(reconcileInterval)
+		l, us, err := queryStoredBalances()
+		if err != nil {
+			log.Error("can not query the stored balances to reconcile: %v", err)
+			continue
+		}
+		ms := l.Reconcile(us)
+		for _, m := range ms {
+			log.Critical("the stored ledger mismatches, %v", m)
+		}
+		log.Info("reconcile the stored ledger, %d mismatches", len(ms))
+	}
+}
@@ -10,9 +10,17 @@ func init() {
 	initPubServer()
 	initPrivServer()
 	initUsers()
+	initLedger()
+	initFriends()
+	initChat()
+	initLeaderboards()
+	initAchievements()
+	initSeasons()
 	initBitcoin()
 	initQueue()
 	initHall()
+	initMatchmaking()
+	initTournament()
 	initGraceful()
 }
 
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+// modes of the match
+const (
+	modeNormal     = "normal"
+	modeRated      = "rated"
+	modeTournament = "tournament"
+)
+
+// end reasons of the match
+var endReasons = map[int]string{
+	types.GameoverNormal: "normal",
+	types.Gameover1pQuit: "1p_quit",
+	types.Gameover2pQuit: "2p_quit",
+}
+
+const maxMatchesPerPage = 50
+
+var errInvalidPage = fmt.Errorf("页码无效")
+
+// a game played
+type matchRecord struct {
+	Id            int
+	Tid           int
+	Mode          string
+	Bet           int
+	Currency      string
+	Winner, Loser int
+	types.GameStats
+	Server  string
+	Replay  string // reserved for the replay, empty until the game server records replays
+	Created int64
+}
+
+func newMatchRecord(tid int, mode string, bet int, currency string, winner, loser int, stats, server string) *matchRecord {
+	gs, err := types.ParseGameStats(stats)
+	if err != nil {
+		log.Warn("can not parse the stats of table %d: %v", tid, err)
+	}
+	return &matchRecord{
+		Tid:       tid,
+		Mode:      mode,
+		Bet:       bet,
+		Currency:  currency,
+		Winner:    winner,
+		Loser:     loser,
+		GameStats: gs,
+		Server:    server,
+		Created:   time.Now().Unix(),
+	}
+}
+
+// for hprose
+func (mr *matchRecord) Wrap() map[string]interface{} {
+	nickname := func(uid int) string {
+		if u := getUserById(uid); u != nil {
+			return u.Nickname
+		}
+		return ""
+	}
+	return map[string]interface{}{
+		"id":           mr.Id,
+		"table_id":     mr.Tid,
+		"mode":         mr.Mode,
+		"bet":          mr.Bet,
+		"currency":     mr.Currency,
+		"winner":       nickname(mr.Winner),
+		"loser":        nickname(mr.Loser),
+		"winner_ko":    mr.WinnerKo,
+		"loser_ko":     mr.LoserKo,
+		"winner_lines": mr.WinnerLines,
+		"loser_lines":  mr.LoserLines,
+		"duration":     mr.Duration,
+		"end_reason":   endReasons[mr.EndReason],
+		"replay":       mr.Replay,
+		"created":      mr.Created,
+	}
+}
+
+func wrapMatches(mrs []*matchRecord) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0, len(mrs))
+	for _, mr := range mrs {
+		res = append(res, mr.Wrap())
+	}
+	return res
+}
+
+// the mode of the normal table
+func normalMode(t *types.Table) string {
+	if t.IsRated() {
+		return modeRated
+	}
+	return modeNormal
+}
+
+// offset and limit of the page, the page starts from 1
+func pageOf(page, size int) (int, int, error) {
+	if page < 1 {
+		return 0, 0, errInvalidPage
+	}
+	if size <= 0 || size > maxMatchesPerPage {
+		size = maxMatchesPerPage
+	}
+	return (page - 1) * size, size, nil
+}
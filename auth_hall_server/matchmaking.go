@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gogames/go_tetris/utils"
+)
+
+const (
+	// the rating gap accepted when a player just enqueues
+	initialSearchWindow = 50.0
+	// the window widens by this per second of waiting
+	searchWindowGrowth = 10.0
+	maxSearchWindow    = 600.0
+	matchInterval      = time.Second
+	// the ticket is dropped if the client does not poll for it
+	ticketTimeout = 30 * time.Second
+	// estimated wait before any match is made, in seconds
+	defaultEstimatedWait = 30.0
+	// weight of the latest wait in the estimation
+	waitSmoothing   = 0.2
+	matchTableTitle = "匹配对战"
+)
+
+const (
+	matchStatusWaiting = "waiting"
+	matchStatusMatched = "matched"
+)
+
+var (
+	errAlreadyInQueue    = fmt.Errorf("你已经在匹配队列中")
+	errNotInQueue        = fmt.Errorf("你不在匹配队列中")
+	errInvalidStakeRange = fmt.Errorf("赌注范围无效")
+	errNoGameServer      = fmt.Errorf("暂无可用的游戏服务器, 请稍后重试")
+)
+
+// a player waiting for an opponent
+type matchTicket struct {
+	uid            int
+	nickname       string
+	rating         float64
+	currency       string
+	minBet, maxBet int
+	enqueued       time.Time
+	lastPoll       time.Time
+	// set when paired, the table is being created
+	pairing bool
+	// set when matched
+	matched     bool
+	tid         int
+	host, token string
+}
+
+// the rating gap the ticket accepts, it widens over time
+func (mt *matchTicket) window(tNow time.Time) float64 {
+	return math.Min(initialSearchWindow+searchWindowGrowth*tNow.Sub(mt.enqueued).Seconds(), maxSearchWindow)
+}
+
+// the highest bet both tickets accept, false if the currencies differ or the stake ranges do not overlap
+func (mt *matchTicket) bet(o *matchTicket) (int, bool) {
+	if mt.currency != o.currency {
+		return 0, false
+	}
+	bet := mt.maxBet
+	if o.maxBet < bet {
+		bet = o.maxBet
+	}
+	return bet, bet >= mt.minBet && bet >= o.minBet
+}
+
+// for hprose
+func (mt *matchTicket) Wrap() map[string]interface{} {
+	if mt.matched {
+		return map[string]interface{}{
+			"status":   matchStatusMatched,
+			"table_id": mt.tid,
+			"host":     mt.host,
+			"token":    mt.token,
+		}
+	}
+	return map[string]interface{}{
+		"status": matchStatusWaiting,
+		"waited": int(time.Now().Sub(mt.enqueued).Seconds()),
+	}
+}
+
+type matchQueue struct {
+	tickets map[int]*matchTicket
+	avgWait float64 // in seconds
+	mu      sync.Mutex
+}
+
+var matchmaker = &matchQueue{
+	tickets: make(map[int]*matchTicket),
+	avgWait: defaultEstimatedWait,
+}
+
+func initMatchmaking() {
+	go matchmaker.serve()
+	log.Info("initialize the matchmaking queue...")
+}
+
+// enqueue a player, return the estimated wait in seconds
+func (mq *matchQueue) enqueue(uid int, nickname string, rating float64, currency string, minBet, maxBet int) (int, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	if _, ok := mq.tickets[uid]; ok {
+		return -1, errAlreadyInQueue
+	}
+	tNow := time.Now()
+	mt := &matchTicket{
+		uid:      uid,
+		nickname: nickname,
+		rating:   rating,
+		currency: currency,
+		minBet:   minBet,
+		maxBet:   maxBet,
+		enqueued: tNow,
+		lastPoll: tNow,
+	}
+	mq.tickets[uid] = mt
+	return mq.estimateWait(mt), nil
+}
+
+// cancel the ticket, a matched ticket can not be canceled
+func (mq *matchQueue) cancel(uid int) error {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	mt, ok := mq.tickets[uid]
+	if !ok || mt.matched {
+		return errNotInQueue
+	}
+	delete(mq.tickets, uid)
+	return nil
+}
+
+// poll the ticket, the matched ticket is removed once it is polled
+func (mq *matchQueue) poll(uid int) (map[string]interface{}, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	mt, ok := mq.tickets[uid]
+	if !ok {
+		return nil, errNotInQueue
+	}
+	mt.lastPoll = time.Now()
+	res := mt.Wrap()
+	if mt.matched {
+		delete(mq.tickets, uid)
+	} else {
+		res["wait"] = mq.estimateWait(mt)
+	}
+	return res, nil
+}
+
+// check if the user is waiting in the queue
+func (mq *matchQueue) isQueued(uid int) bool {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	mt, ok := mq.tickets[uid]
+	return ok && !mt.matched
+}
+
+// estimated seconds the ticket still has to wait
+func (mq *matchQueue) estimateWait(mt *matchTicket) int {
+	return int(math.Max(mq.avgWait-time.Now().Sub(mt.enqueued).Seconds(), 1))
+}
+
+func (mq *matchQueue) serve() {
+	for {
+		time.Sleep(matchInterval)
+		mq.match()
+	}
+}
+
+// a pair of tickets matched, the table is created out of the lock
+type matchPair struct {
+	a, b *matchTicket
+	bet  int
+}
+
+// pair the waiting tickets
+// the longest waiting ticket is matched first, with the closest rating in both windows
+// the tables are created on the game server without holding the queue
+func (mq *matchQueue) match() {
+	tNow := time.Now()
+	pairs := mq.pair(tNow)
+	for _, p := range pairs {
+		tid, ip, tokens, err := createMatchTable(p.a, p.b, p.bet)
+		if err != nil {
+			log.Warn("can not create table for matched users %s and %s: %v", p.a.nickname, p.b.nickname, err)
+		}
+		mq.settle(p, tid, ip, tokens, err, tNow)
+	}
+}
+
+// pair the waiting tickets, the paired tickets are not paired again until they are settled
+func (mq *matchQueue) pair(tNow time.Time) []matchPair {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	waiting := make([]*matchTicket, 0, len(mq.tickets))
+	for uid, mt := range mq.tickets {
+		if tNow.Sub(mt.lastPoll) > ticketTimeout && !mt.pairing {
+			log.Debug("matchmaking ticket of user %d is not polled for %v, drop it", uid, ticketTimeout)
+			delete(mq.tickets, uid)
+			continue
+		}
+		if !mt.matched && !mt.pairing {
+			waiting = append(waiting, mt)
+		}
+	}
+	sort.Sort(byEnqueued(waiting))
+
+	// the ticket may be dropped during the matching
+	available := func(mt *matchTicket) bool {
+		return !mt.pairing && mq.tickets[mt.uid] == mt
+	}
+	pairs := make([]matchPair, 0)
+	for i, a := range waiting {
+		if !available(a) {
+			continue
+		}
+		var opponent *matchTicket
+		var bet int
+		gap := math.MaxFloat64
+		for _, b := range waiting[i+1:] {
+			if !available(b) {
+				continue
+			}
+			g := math.Abs(a.rating - b.rating)
+			if g > a.window(tNow) || g > b.window(tNow) || g >= gap {
+				continue
+			}
+			if tBet, ok := a.bet(b); ok {
+				opponent, bet, gap = b, tBet, g
+			}
+		}
+		if opponent == nil {
+			continue
+		}
+		funded := true
+		for _, mt := range []*matchTicket{a, opponent} {
+			if u := getUserById(mt.uid); u == nil || u.GetBalanceOf(mt.currency) < bet {
+				// the balance changes after enqueue, drop the ticket
+				log.Debug("the balance of matched user %s is not sufficient for bet %d, drop the ticket", mt.nickname, bet)
+				delete(mq.tickets, mt.uid)
+				funded = false
+			}
+		}
+		if !funded {
+			continue
+		}
+		a.pairing, opponent.pairing = true, true
+		pairs = append(pairs, matchPair{a, opponent, bet})
+	}
+	return pairs
+}
+
+// hand the tickets the table, or put them back to wait if the table is not created
+// the table is deleted if a ticket is canceled during the creation
+func (mq *matchQueue) settle(p matchPair, tid int, ip string, tokens map[int]string, err error, tNow time.Time) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	p.a.pairing, p.b.pairing = false, false
+	if err != nil {
+		return
+	}
+	if mq.tickets[p.a.uid] != p.a || mq.tickets[p.b.uid] != p.b {
+		log.Info("the matched ticket is canceled, delete table %d", tid)
+		deleteMatchTable(ip, tid)
+		return
+	}
+	host := ip + ":" + gameServerSocketPort
+	for _, mt := range []*matchTicket{p.a, p.b} {
+		mt.matched, mt.tid, mt.host, mt.token = true, tid, host, tokens[mt.uid]
+		mq.avgWait = (1-waitSmoothing)*mq.avgWait + waitSmoothing*tNow.Sub(mt.enqueued).Seconds()
+	}
+	log.Info("match %s and %s in table %d, bet %d %s", p.a.nickname, p.b.nickname, tid, p.bet, p.a.currency)
+}
+
+// create a rated table on the best game server, return the game server and the tokens of both players by uid
+// the table is deleted if anything fails after it is created on the game server
+func createMatchTable(a, b *matchTicket, bet int) (int, string, map[int]string, error) {
+	ip := clients.BestServer()
+	if ip == "" {
+		return -1, "", nil, errNoGameServer
+	}
+	id := normalHall.NextTableId()
+	host := ip + ":" + gameServerSocketPort
+	if err := clients.GetStub(ip).Create(id); err != nil {
+		return -1, "", nil, err
+	}
+	if err := normalHall.NewTable(id, matchTableTitle, host, bet); err != nil {
+		deleteMatchTable(ip, id)
+		return -1, "", nil, err
+	}
+	t := normalHall.GetTableById(id)
+	t.SetCurrency(a.currency)
+	t.SetRated(true)
+	tokens := make(map[int]string)
+	for _, mt := range []*matchTicket{a, b} {
+		token, err := utils.GenerateToken(mt.uid, mt.nickname, false, false, id)
+		if err != nil {
+			deleteMatchTable(ip, id)
+			return -1, "", nil, err
+		}
+		tokens[mt.uid] = token
+	}
+	return id, ip, tokens, nil
+}
+
+// delete the table of the match on the game server and in the hall
+func deleteMatchTable(ip string, tid int) {
+	if err := clients.GetStub(ip).Delete(tid); err != nil {
+		log.Warn("can not inform game server %v to delete table %v: %v", ip, tid, err)
+	}
+	normalHall.DelTable(tid)
+}
+
+type byEnqueued []*matchTicket
+
+func (s byEnqueued) Len() int           { return len(s) }
+func (s byEnqueued) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byEnqueued) Less(i, j int) bool { return s[i].enqueued.Before(s[j].enqueued) }
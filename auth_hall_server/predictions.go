@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+const (
+	// seconds of the count down on game server, predictions are closed after it
+	predictionCutoff = 3
+	// status of the prediction
+	predictionPending  = 0
+	predictionSettled  = 1
+	predictionRefunded = 2
+)
+
+// house fee of the prediction pool in percent, it could be set by configuration
+var predictionFee = 5
+
+var (
+	errNotSpectator = fmt.Errorf("只有观战者才能参与预测")
+	errNoPrediction = fmt.Errorf("该桌子没有进行中的预测")
+)
+
+// a prediction pool of the game on a table, the stakes are in the currency of the table
+type predictionPool struct {
+	id       int
+	tid      int
+	currency string
+	*types.PredictionPool
+}
+
+var (
+	predictionPools = make(map[int]*predictionPool) // table id -> pool
+	predictionMu    sync.Mutex
+)
+
+// get the pool of the table, create it if there is none
+func getPredictionPool(tid int, currency string) (*predictionPool, error) {
+	predictionMu.Lock()
+	defer predictionMu.Unlock()
+	if p, ok := predictionPools[tid]; ok {
+		return p, nil
+	}
+	id, err := insertPredictionPool(tid)
+	if err != nil {
+		log.Error("can not create prediction pool for table %d: %v", tid, err)
+		return nil, err
+	}
+	p := &predictionPool{id: id, tid: tid, currency: currency, PredictionPool: types.NewPredictionPool()}
+	predictionPools[tid] = p
+	return p, nil
+}
+
+// remove the pool of the table, nil if there is none
+func popPredictionPool(tid int) *predictionPool {
+	predictionMu.Lock()
+	defer predictionMu.Unlock()
+	p := predictionPools[tid]
+	delete(predictionPools, tid)
+	return p
+}
+
+// the spectator predicts the side to win, the stake is freezed before it is placed in the pool
+func placePrediction(tid, uid, side, stake int) error {
+	t := normalHall.GetTableById(tid)
+	if t == nil {
+		return fmt.Errorf(errTableNotExist, tid)
+	}
+	isOb := false
+	for _, ob := range t.GetObservers() {
+		if ob == uid {
+			isOb = true
+			break
+		}
+	}
+	if !isOb {
+		return errNotSpectator
+	}
+	if t.IsStart() && t.GetElapsed() >= predictionCutoff {
+		return types.ErrPredictionClosed
+	}
+	u := getUserById(uid)
+	if u == nil {
+		return fmt.Errorf(errUserNotExist, uid)
+	}
+	p, err := getPredictionPool(tid, t.GetCurrency())
+	if err != nil {
+		return err
+	}
+	if u.GetBalanceOf(p.currency) < stake {
+		return errBalNotSufficient
+	}
+	// the balance may change after the check, the overdraft fails the freeze
+	if err := transact(journalPredictionStake, tid, types.Transfer(availableOf(uid), frozenOf(uid), p.currency, stake)...); err != nil {
+		if err == types.ErrOverdraft {
+			return errBalNotSufficient
+		}
+		return err
+	}
+	if err := p.Place(uid, side, stake); err != nil {
+		// the pool is closed or the side is invalid, return the stake
+		if uerr := transact(journalPredictionStake, tid, types.Transfer(frozenOf(uid), availableOf(uid), p.currency, stake)...); uerr != nil {
+			log.Critical("can not return the prediction stake %d %s of %v: %v", stake, p.currency, u.Nickname, uerr)
+		}
+		return err
+	}
+	pid := p.id
+	pushFunc(func() { insertPrediction(pid, u, side, stake) })
+	return nil
+}
+
+// settle the pool of the table with the winner of the game
+func settlePredictions(tid, winner int) {
+	p := popPredictionPool(tid)
+	if p == nil {
+		return
+	}
+	t := normalHall.GetTableById(tid)
+	side := types.PredictSide1p
+	if winner != t.Get1pUid() {
+		side = types.PredictSide2p
+	}
+	// no stake is placed between the stakes and the settlement
+	p.Close()
+	stakes := p.Stakes()
+	payouts, fee := p.Settle(side, predictionFee)
+	us := payPredictions(p, stakes, payouts, fee)
+	pid := p.id
+	pushFunc(func() { settlePredictionPool(pid, side, fee, predictionSettled, payouts, us...) })
+	log.Info("settle the prediction pool of table %d, totals %v, fee %d", tid, p.Totals(), fee)
+}
+
+// refund the pool of the table, the game ends abnormally
+func refundPredictions(tid int) {
+	p := popPredictionPool(tid)
+	if p == nil {
+		return
+	}
+	p.Close()
+	stakes := p.Stakes()
+	us := payPredictions(p, stakes, stakes, 0)
+	pid := p.id
+	pushFunc(func() { settlePredictionPool(pid, -1, 0, predictionRefunded, stakes, us...) })
+	log.Info("refund the prediction pool of table %d, totals %v", tid, p.Totals())
+}
+
+// unfreeze the stakes, pay the payouts and charge the fee, return the updated users
+func payPredictions(p *predictionPool, stakes, payouts map[int]int, fee int) []*types.User {
+	tid, currency := p.tid, p.currency
+	us := make([]*types.User, 0, len(stakes))
+	ps := []types.Posting{types.NewPosting(types.AccountFees, currency, fee)}
+	for uid, stake := range stakes {
+		if u := getUserById(uid); u != nil {
+			us = append(us, u)
+		}
+		ps = append(ps, types.NewPosting(frozenOf(uid), currency, -stake),
+			types.NewPosting(availableOf(uid), currency, payouts[uid]))
+	}
+	if err := transact(journalPredictionSettle, tid, ps...); err != nil {
+		log.Critical("can not pay the predictions of table %d, stakes %v, payouts %v, fee %d %s: %v", tid, stakes, payouts, fee, currency, err)
+		return nil
+	}
+	return us
+}
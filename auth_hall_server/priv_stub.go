@@ -44,19 +44,16 @@ func (privStub) Join(tid, uid int, isOb bool) error {
 		return errInsufficientEnergy
 	}
 	// check balance
-	if u.GetBalance() < bet {
+	if u.GetBalanceOf(t.GetCurrency()) < bet {
 		return errBalNotSufficient
 	}
 	if err := normalHall.JoinTable(tid, u, isOb); err != nil {
 		return err
 	}
-	// update energy, balance, freezed
-	if err := u.Update(types.NewUpdateInt(types.UF_Energy, u.GetEnergy()-1),
-		types.NewUpdateInt(types.UF_Balance, u.GetBalance()-bet),
-		types.NewUpdateInt(types.UF_Freezed, u.GetFreezed()+bet)); err != nil {
+	// update energy, the bet is freezed when the series starts
+	if err := transact(journalGameEnergy, tid, types.Transfer(energyOf(uid), types.AccountHouse, types.AssetEnergy, 1)...); err != nil {
 		return err
 	}
-	pushFunc(func() { insertOrUpdateUser(u) })
 	users.SetBusy(uid)
 	return nil
 }
@@ -67,7 +64,11 @@ func (privStub) ObTournament(tid, uid int) error {
 	if u == nil {
 		return fmt.Errorf(errUserNotExist, uid)
 	}
-	if err := tournamentHall.JoinTable(tid, u, true); err != nil {
+	t := director.getByTable(tid)
+	if t == nil {
+		return errTournamentNotExist
+	}
+	if err := t.JoinTable(tid, u, true); err != nil {
 		return err
 	}
 	users.SetBusy(uid)
@@ -75,91 +76,122 @@ func (privStub) ObTournament(tid, uid int) error {
 }
 
 // set normal game result
-func (privStub) SetNormalGameResult(tid, winner, loser int, ctx interface{}) {
+// the bet is settled once the series is over
+func (privStub) SetNormalGameResult(tid, winner, loser int, stats string, ctx interface{}) {
 	t := normalHall.GetTableById(tid)
-	// update winner info
-	func() {
-		w := getUserById(winner)
-		upts := make([]types.UpdateInterface, 0)
-		upts = append(upts, types.NewUpdateInt(types.UF_Balance, w.GetBalance()+t.GetBet()*2))
-		upts = append(upts, types.NewUpdateInt(types.UF_Freezed, w.GetFreezed()-t.GetBet()))
-		upts = append(upts, types.NewUpdateInt(types.UF_Win, w.Win+1))
-		if w.Win > (w.Level * w.Level) {
-			upts = append(upts, types.NewUpdateInt(types.UF_Level, w.Level+1))
-		}
-		if err := w.Update(upts...); err != nil {
-			log.Critical("set normal hall result, can not update winner %v: %v", w.Nickname, err)
-		}
-		pushFunc(func() { insertOrUpdateUser(w) })
-	}()
+	ip := utils.GetIp(ctx)
 
-	// update loser info
-	func() {
-		l := getUserById(loser)
-		upts := make([]types.UpdateInterface, 0)
-		upts = append(upts, types.NewUpdateInt(types.UF_Freezed, l.GetFreezed()-t.GetBet()))
-		upts = append(upts, types.NewUpdateInt(types.UF_Lose, l.Lose+1))
-		if err := l.Update(upts...); err != nil {
-			log.Critical("set normal hall game result, can not update loser %v: %v", l.Nickname, err)
-		}
-		pushFunc(func() { insertOrUpdateUser(l) })
-	}()
+	// the loser may have quit the table already
+	loser = t.GetSeriesOpponent(winner)
+	mr := newMatchRecord(tid, normalMode(t), t.GetBet(), t.GetCurrency(), winner, loser, stats, ip)
+	// the games are rated and checked for achievements once the series is settled
+	// the games of a held result are settled once it is approved
+	addSeriesGame(tid, mr, t.IsRated())
+	over := t.AddScore(winner)
+	if !over && loser != t.Get1pUid() && loser != t.Get2pUid() {
+		_, over = t.Forfeit(loser)
+	}
 
 	// update busy timestamp
 	users.SetBusy(t.GetAllUsers()...)
 
-	if err := clients.GetStub(utils.GetIp(ctx)).SetNormalGameResult(tid, winner, t.GetBet()); err != nil {
-		log.Warn("can not inform game server to set the game result: %v", err)
+	if !over {
+		pushFunc(func() { insertMatch(mr, nil) })
+		settlePredictions(tid, winner)
+		// the game server starts the next game of the series, predictions are open until its count down ends
+		t.Start()
+		if err := clients.GetStub(ip).SetNormalGameResult(tid, winner, 0,
+			t.GetSeriesScore(winner), t.GetSeriesScore(loser), false); err != nil {
+			log.Warn("can not inform game server to set the game result: %v", err)
+		}
+		return
+	}
+
+	// both players have to get ready or agree to a rematch for the next series
+	t.ResetTable()
+	e, us := settleSeries(tid, winner, loser, t.GetBet(), ip)
+	pushFunc(func() { insertMatch(mr, e, us...) })
+	if us == nil {
+		// the result is held for review
+		refundPredictions(tid)
+	} else {
+		settlePredictions(tid, winner)
 	}
 }
 
+// report a suspect who might be cheating
+func (privStub) ReportSuspect(tid, uid int, reason string) {
+	log.Warn("game server reports user %d of table %d: %s", uid, tid, reason)
+	markSuspect(tid, uid, reason)
+	pushFunc(func() { insertSuspect(tid, uid, reason) })
+}
+
+// check the table chat message of the user, return the filtered message
+func (privStub) CheckChat(tid, uid int, content string) (string, error) {
+	return checkChat(tid, uid, content)
+}
+
 // set tournament game result
-func (privStub) SetTournamentResult(tid, winner, loser int) (int, error) {
-	t := tournamentHall.GetTableById(tid)
+// the players wait at the table, the director moves them when the round is over
+func (privStub) SetTournamentResult(tid, winner, loser int, stats string, ctx interface{}) error {
+	tour := director.getByTable(tid)
+	if tour == nil {
+		return errTournamentNotExist
+	}
+	t := tour.GetTableById(tid)
+	if t == nil {
+		return fmt.Errorf(errTableNotExist, tid)
+	}
+	// the loser may have quit the table already
+	loser = t.GetOpponent(winner)
 	// update winner info
-	w := getUserById(winner)
+	w, l := getUserById(winner), getUserById(loser)
 	func() {
-		upts := make([]types.UpdateInterface, 0)
-		upts = append(upts, types.NewUpdateInt(types.UF_Win, w.Win+1))
-		if w.Win > (w.Level * w.Level) {
-			upts = append(upts, types.NewUpdateInt(types.UF_Level, w.Level+1))
-		}
-		if err := w.Update(upts...); err != nil {
+		if err := w.Update(types.NewUpdateInt(types.UF_Win, w.Win+1)); err != nil {
 			log.Critical("tournament hall -> can not update winner %v: %v", w.Nickname, err)
 		}
-		pushFunc(func() { insertOrUpdateUser(w) })
+		recordWin(w)
 	}()
 
 	// update loser info
 	func() {
-		l := getUserById(loser)
 		if err := l.Update(types.NewUpdateInt(types.UF_Lose, l.Lose+1)); err != nil {
 			log.Critical("tournament hall -> can not update loser %v: %v", l.Nickname, err)
 		}
-		pushFunc(func() { insertOrUpdateUser(l) })
 	}()
+	mr := newMatchRecord(tid, modeTournament, 0, types.AssetMBTC, winner, loser, stats, utils.GetIp(ctx))
+	pushFunc(func() { insertMatch(mr, nil, w, l) })
 
-	// update tournament hall
-	tournamentHall.SetWinnerLoser(tid, winner)
-	nid, err := tournamentHall.Allocate(w)
-	if err != nil {
-		log.Critical("tournament hall -> can not allocate user %v to next table: %v", w.Nickname, err)
-		return -1, err
+	// update the bracket, the director pairs the next round or crowns the getters
+	observers := t.GetObservers()
+	if err := director.report(tour, mr); err != nil {
+		log.Critical("tournament hall -> can not report the result of table %d: %v", tid, err)
+		return err
 	}
+	// the result is settled in the bracket
+	if tour.template.Ruleset == rulesetRated {
+		rateGame(winner, loser)
+	}
+	checkAchievements(mr, mr.Server)
 
-	// winner continue to player, update busy timestamp
-	users.SetBusy(winner)
-
-	// loser and observers quit, set free
-	users.SetFree(loser)
-	users.SetFree(t.GetObservers()...)
-	return nid, nil
+	// observers quit, set free
+	// the players are set free when they are out of the tournament
+	users.SetFree(observers...)
+	users.SetBusy(winner, loser)
+	return nil
 }
 
-// TODO:
-// apply for tournament
-func (privStub) Apply(uid int) (int, error) {
-	tid, err := tournamentHall.Apply(getUserById(uid))
+// apply for the tournament, the entry fee is frozen
+func (privStub) Apply(id, uid int) (int, error) {
+	t := director.get(id)
+	if t == nil {
+		return -1, errTournamentNotExist
+	}
+	u := getUserById(uid)
+	if u == nil {
+		return -1, fmt.Errorf(errUserNotExist, uid)
+	}
+	tid, err := director.apply(t, u)
 	if err != nil {
 		return -1, err
 	}
@@ -167,9 +199,22 @@ func (privStub) Apply(uid int) (int, error) {
 	return tid, nil
 }
 
-// allocate for tournament
-func (privStub) Allocate(uid int) (int, error) {
-	tid, err := tournamentHall.Allocate(getUserById(uid))
+// the live bracket of the tournament of the table, for the in-table display
+func (privStub) GetBracket(tid int) (map[string]interface{}, error) {
+	t := director.getByTable(tid)
+	if t == nil {
+		return nil, errTournamentNotExist
+	}
+	return director.bracket(t), nil
+}
+
+// allocate for the tournament
+func (privStub) Allocate(id, uid int) (int, error) {
+	t := director.get(id)
+	if t == nil {
+		return -1, errTournamentNotExist
+	}
+	tid, err := t.Allocate(getUserById(uid))
 	if err != nil {
 		return -1, err
 	}
@@ -178,11 +223,32 @@ func (privStub) Allocate(uid int) (int, error) {
 }
 
 // quit a user
-func (privStub) Quit(tid, uid int, isTournament bool) {
+func (privStub) Quit(tid, uid int, isTournament bool, ctx interface{}) {
 	if isTournament {
-		tournamentHall.GetTableById(tid).Quit(uid)
+		// if the game is started, the game server sets the result
+		if tour := director.getByTable(tid); tour != nil {
+			if t := tour.GetTableById(tid); t != nil && !t.IsStart() {
+				tour.Quit(tid, uid)
+				t.Quit(uid)
+			}
+			director.quit(tour, uid)
+		}
 	} else {
-		normalHall.GetTableById(tid).Quit(uid)
+		t := normalHall.GetTableById(tid)
+		t.Quit(uid)
+		// quit between the games of a series, the opponent wins the series
+		// if the game is started, the game server sets the result
+		if !t.IsStart() {
+			if winner, ok := t.Forfeit(uid); ok {
+				t.ResetTable()
+				// no game is played, the settlement is stored without a match
+				if e, us := settleSeries(tid, winner, uid, t.GetBet(), utils.GetIp(ctx)); us != nil {
+					pushFunc(func() { storeSettlement(e, us...) })
+				}
+				// the next game the spectators predict is never played
+				refundPredictions(tid)
+			}
+		}
 	}
 	users.SetFree(uid)
 }
@@ -197,13 +263,74 @@ func (privStub) SwitchReady(tid, uid int, ctx interface{}) error {
 	t := normalHall.GetTableById(tid)
 	t.SwitchReady(uid)
 	if t.ShouldStart() {
-		if err := clients.GetStub(utils.GetIp(ctx)).Start(tid); err != nil {
-			log.Debug("can not inform game server to start the table %d", tid)
+		// the bet is freezed once for the whole series
+		if !t.IsInSeries() {
+			if err := freezeBet(tid, t.GetBet(), t.GetCurrency(), t.GetPlayers()...); err != nil {
+				t.SwitchReady(uid)
+				return err
+			}
+			t.StartSeries()
 		}
+		startTable(t, utils.GetIp(ctx))
 	}
 	return nil
 }
 
+// rematch actions
+const (
+	rematchOffer   = "offer"
+	rematchAccept  = "accept"
+	rematchDecline = "decline"
+)
+
+var (
+	errTournamentNoRematch  = fmt.Errorf("争霸赛无法再来一局")
+	errInvalidRematchAction = fmt.Errorf("再来一局的操作只能是 %s, %s, %s", rematchOffer, rematchAccept, rematchDecline)
+)
+
+// offer, accept or decline a rematch after the series is over
+// once both players agree, a new series starts with the same bet
+func (privStub) Rematch(tid, uid int, action string, ctx interface{}) error {
+	if isTournament(tid) {
+		return errTournamentNoRematch
+	}
+	t := normalHall.GetTableById(tid)
+	if t == nil {
+		return fmt.Errorf(errTableNotExist, tid)
+	}
+	switch action {
+	case rematchDecline:
+		t.DeclineRematch()
+		return nil
+	case rematchAccept:
+		if !t.IsRematchOffered(uid) {
+			return types.ErrNoRematchOffer
+		}
+	case rematchOffer:
+	default:
+		return errInvalidRematchAction
+	}
+	agreed, err := t.OfferRematch(uid)
+	if err != nil || !agreed {
+		return err
+	}
+	if err := freezeBet(tid, t.GetBet(), t.GetCurrency(), t.GetPlayers()...); err != nil {
+		t.DeclineRematch()
+		return err
+	}
+	t.StartSeries()
+	startTable(t, utils.GetIp(ctx))
+	return nil
+}
+
+// inform the game server to start the table
+func startTable(t *types.Table, ip string) {
+	t.Start()
+	if err := clients.GetStub(ip).Start(t.TId); err != nil {
+		log.Debug("can not inform game server to start the table %d", t.TId)
+	}
+}
+
 func isTournament(tid int) bool {
 	return tid >= 1e5
 }
@@ -2,8 +2,8 @@ package main
 
 import (
 	"fmt"
-	"math"
 	"regexp"
+	"time"
 
 	"github.com/gogames/go_tetris/types"
 	"github.com/gogames/go_tetris/utils"
@@ -19,6 +19,10 @@ const (
 	ratioEnergy2mBTC  = 10
 	maxAvatar         = 1 << 18 // 256KB
 	defaultEnergy     = 10
+	inviteCodeLength  = 8
+	inviteLinkFormat  = "/join?code=%s"
+	// the title of the table and the join link
+	inviteMessageFormat = "邀请你加入私人桌 %s, 加入链接: %s"
 
 	// error const
 	errUserNotExist    = "用户 %v 不存在"
@@ -58,7 +62,8 @@ var (
 	errNegativeBet               = fmt.Errorf("赌注不能为负数")
 	errCantApplyForNilTournament = fmt.Errorf("暂无争霸赛, 无法加入.")
 	errNilTournamentHall         = fmt.Errorf("暂无争霸赛, 无法获得争霸赛桌子信息")
-	errCantMatchOpponent         = fmt.Errorf("无匹配对手, 请稍后重试.")
+	errInvalidInviteCode         = fmt.Errorf("邀请码无效或者桌子已经关闭")
+	errInvalidCurrency           = fmt.Errorf("货币只能是 %s 或 %s", types.AssetMBTC, types.AssetChips)
 )
 
 // send mail, register auth
@@ -159,7 +164,7 @@ func (pubStub) Register(email, password, nickname, authenCode string, ctx interf
 		return errNicknameExist
 	}
 	// generate new bitcoin address for the user
-	addr, err := getNewAddress(nickname)
+	addr, err := btcWallet.NewAddress(nickname)
 	if err != nil {
 		return err
 	}
@@ -173,10 +178,11 @@ func (pubStub) Register(email, password, nickname, authenCode string, ctx interf
 	session.DeleteKey(sessKeyEmail, ctx)
 	// add user in cache
 	u := types.NewUser(users.GetNextId(), email, password, nickname, addr)
-	u.Update(types.NewUpdateInt(types.UF_Energy, defaultEnergy)) // new user get 10 energy
 	users.Add(u)
-	// async insert into database
+	// async insert into database, before the entries moving the balances of the row
 	pushFunc(func() { insertOrUpdateUser(u) })
+	transact(journalSignupEnergy, u.Uid, types.Transfer(types.AccountHouse, energyOf(u.Uid), types.AssetEnergy, defaultEnergy)...) // new user get 10 energy
+	transact(journalChipsGiveout, u.Uid, types.Transfer(types.AccountHouse, availableOf(u.Uid), types.AssetChips, dailyChips)...)
 	return nil
 }
 
@@ -258,9 +264,14 @@ func (pubStub) GetHalls(ctx interface{}) (map[string]interface{}, error) {
 		if u == nil {
 			return nil, fmt.Errorf(errUserNotExist, uid)
 		}
+		tournaments := make([]map[string]interface{}, 0)
+		for _, t := range director.all() {
+			tournaments = append(tournaments, t.Wrap())
+		}
 		return map[string]interface{}{
-			"normal":     normalHall.Wrap(),
-			"tournament": tournamentHall.Wrap(),
+			"rated":      normalHall.WrapRated(true),
+			"unrated":    normalHall.WrapRated(false),
+			"tournament": tournaments,
 		}, nil
 	}
 	return nil, errNotLoggedIn
@@ -285,10 +296,15 @@ func (pubStub) GetTournamentTable(tid int, ctx interface{}) (map[string]interfac
 		if u == nil {
 			return nil, fmt.Errorf(errUserNotExist, uid)
 		}
-		if tournamentHall == nil {
+		tour := director.getByTable(tid)
+		if tour == nil {
 			return nil, errNilTournamentHall
 		}
-		return tournamentHall.GetTableById(tid).WrapTable(), nil
+		t := tour.GetTableById(tid)
+		if t == nil {
+			return nil, fmt.Errorf(errTableNotExist, tid)
+		}
+		return t.WrapTable(), nil
 	}
 	return nil, errNotLoggedIn
 }
@@ -296,6 +312,26 @@ func (pubStub) GetTournamentTable(tid int, ctx interface{}) (map[string]interfac
 // join a normal game, play or observe
 // actually it is just get a token
 func (pubStub) Join(tid int, isOb bool, ctx interface{}) (string, error) {
+	return joinNormalTable(tid, "", isOb, ctx)
+}
+
+// join a private table by the password or the invite code
+func (pubStub) JoinPrivate(tid int, secret string, isOb bool, ctx interface{}) (string, error) {
+	return joinNormalTable(tid, secret, isOb, ctx)
+}
+
+// join a private table by the invite code, the table id is not needed
+func (pubStub) JoinByCode(code string, isOb bool, ctx interface{}) (host, token string, err error) {
+	t := normalHall.GetTableByInviteCode(code)
+	if t == nil {
+		err = errInvalidInviteCode
+		return
+	}
+	token, err = joinNormalTable(t.TId, code, isOb, ctx)
+	return t.GetHost(), token, err
+}
+
+func joinNormalTable(tid int, secret string, isOb bool, ctx interface{}) (string, error) {
 	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
 		u := getUserById(uid)
 		if u == nil {
@@ -305,11 +341,17 @@ func (pubStub) Join(tid int, isOb bool, ctx interface{}) (string, error) {
 		if users.IsBusyUser(uid) {
 			return "", errAlreadyInGame
 		}
+		if matchmaker.isQueued(uid) {
+			return "", errAlreadyInQueue
+		}
 		t := normalHall.GetTableById(tid)
 		if t == nil {
 			return "", fmt.Errorf(errTableNotExist, tid)
 		}
-		if u.GetBalance() < t.GetBet() {
+		if !t.CanEnter(uid, secret) {
+			return "", types.ErrPrivateTable
+		}
+		if u.GetBalanceOf(t.GetCurrency()) < t.GetBet() {
 			return "", errBalNotSufficient
 		}
 		if u.GetEnergy() <= 0 {
@@ -328,11 +370,47 @@ func (pubStub) Join(tid int, isOb bool, ctx interface{}) (string, error) {
 	return "", errNotLoggedIn
 }
 
+// invite a user to the private table
+// return the invite code and the join link for the user
+func (pubStub) Invite(tid int, nickname string, ctx interface{}) (map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		t := normalHall.GetTableById(tid)
+		if t == nil {
+			return nil, fmt.Errorf(errTableNotExist, tid)
+		}
+		invitee := getUserByNickname(nickname)
+		if invitee == nil {
+			return nil, fmt.Errorf(errUserNotExist, nickname)
+		}
+		code, err := t.Invite(uid, invitee.GetUid())
+		if err != nil {
+			return nil, err
+		}
+		// the invitee gets the link in the messages
+		link := fmt.Sprintf(inviteLinkFormat, code)
+		if msg, err := newDirectMessage(uid, invitee.GetUid(), fmt.Sprintf(inviteMessageFormat, t.TTitle, link)); err == nil {
+			pushFunc(func() {
+				if err := insertMessage(msg); err != nil {
+					log.Warn("can not send the invitation of table %d to %v: %v", tid, nickname, err)
+				}
+			})
+		}
+		return map[string]interface{}{
+			"table_id": tid,
+			"nickname": nickname,
+			"code":     code,
+			"link":     link,
+		}, nil
+	}
+	return nil, errNotLoggedIn
+}
+
 // TODO:
 // observe a tournament game
 // actually it is just get a token
 func (pubStub) ObserveTournament(tid int, ctx interface{}) (string, error) {
-	if tournamentHall == nil {
+	tour := director.getByTable(tid)
+	if tour == nil {
 		return "", errNilTournamentHall
 	}
 	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
@@ -344,7 +422,7 @@ func (pubStub) ObserveTournament(tid int, ctx interface{}) (string, error) {
 		if users.IsBusyUser(uid) {
 			return "", errAlreadyInGame
 		}
-		t := tournamentHall.GetTableById(tid)
+		t := tour.GetTableById(tid)
 		if t == nil {
 			return "", fmt.Errorf(errTableNotExist, tid)
 		}
@@ -356,101 +434,146 @@ func (pubStub) ObserveTournament(tid int, ctx interface{}) (string, error) {
 	return "", errNotLoggedIn
 }
 
-// automatically match a normal game for user
-func (pubStub) AutoMatch(ctx interface{}) (host string, token string, err error) {
+// enqueue for matchmaking with the stake range
+// return the estimated wait in seconds
+func (pubStub) EnqueueMatch(minBet, maxBet int, currency string, ctx interface{}) (int, error) {
+	if minBet < 0 || maxBet < minBet {
+		return -1, errInvalidStakeRange
+	}
+	if !types.IsCurrency(currency) {
+		return -1, errInvalidCurrency
+	}
 	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
 		u := getUserById(uid)
 		if u == nil {
-			err = fmt.Errorf(errUserNotExist, uid)
-			return
+			return -1, fmt.Errorf(errUserNotExist, uid)
 		}
 		session.SetSession(sessKeyUserId, uid, ctx)
 		if users.IsBusyUser(uid) {
-			err = errAlreadyInGame
-			return
+			return -1, errAlreadyInGame
 		}
 		if u.GetEnergy() <= 0 {
-			err = errInsufficientEnergy
-			return
+			return -1, errInsufficientEnergy
 		}
-		var table *types.Table = nil
-		var gap float64 = 100
-		for _, t := range normalHall.Tables.Tables {
-			if t.IsFull() {
-				continue
-			}
-			if u.GetBalance() < t.GetBet() {
-				continue
-			}
-			if id := t.Get1pUid(); id != -1 {
-				if tGap := math.Abs(float64(getUserById(id).Level - u.Level)); tGap < gap {
-					gap = tGap
-					table = t
-					if tGap == 0 {
-						break
-					}
-				}
-				continue
-			}
-			if id := t.Get2pUid(); id != -1 {
-				if tGap := math.Abs(float64(getUserById(id).Level - u.Level)); tGap < gap {
-					gap = tGap
-					table = t
-					if tGap == 0 {
-						break
-					}
-				}
-				continue
-			}
+		if u.GetBalanceOf(currency) < minBet {
+			return -1, errBalNotSufficient
 		}
-		if table == nil {
-			err = errCantMatchOpponent
-			return
+		// the bet could not be more than the balance
+		if bal := u.GetBalanceOf(currency); maxBet > bal {
+			maxBet = bal
 		}
-		token, err = utils.GenerateToken(uid, u.Nickname, false, false, table.TId)
-		return table.GetHost(), token, err
+		return matchmaker.enqueue(uid, u.Nickname, u.GetRating().Rating, currency, minBet, maxBet)
 	}
-	err = errNotLoggedIn
-	return
+	return -1, errNotLoggedIn
+}
+
+// cancel matchmaking
+func (pubStub) CancelMatch(ctx interface{}) error {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		return matchmaker.cancel(uid)
+	}
+	return errNotLoggedIn
+}
+
+// poll the matchmaking status
+// once matched, the host and token to join the table are returned
+func (pubStub) PollMatch(ctx interface{}) (map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		return matchmaker.poll(uid)
+	}
+	return nil, errNotLoggedIn
+}
+
+// create a game, the bet in the currency is settled after a best of n series
+// the games of a rated table change the ratings of the players
+func (pubStub) Create(title string, bet int, currency string, bestOf int, rated bool, ctx interface{}) (int, error) {
+	t, err := createNormalTable(title, bet, currency, bestOf, rated, ctx)
+	if err != nil {
+		return -1, err
+	}
+	return t.TId, nil
+}
+
+// create a private game which is hidden from the hall
+// it is joined by the password if it is not empty, the invite code, or an invitation
+func (pubStub) CreatePrivate(title string, bet int, currency string, bestOf int, rated bool, password string, ctx interface{}) (map[string]interface{}, error) {
+	code := utils.RandString(inviteCodeLength)
+	t, err := createNormalTable(title, bet, currency, bestOf, rated, ctx, func(t *types.Table, uid int) {
+		t.SetPrivate(uid, password, code)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"table_id": t.TId,
+		"code":     code,
+		"link":     fmt.Sprintf(inviteLinkFormat, code),
+	}, nil
 }
 
-// create a game
-func (pubStub) Create(title string, bet int, ctx interface{}) (int, error) {
+// create the table of the user, the private one is set up by the function before it is listed
+func createNormalTable(title string, bet int, currency string, bestOf int, rated bool, ctx interface{}, private ...func(*types.Table, int)) (*types.Table, error) {
 	if bet < 0 {
-		return -1, errNegativeBet
+		return nil, errNegativeBet
+	}
+	if !types.IsCurrency(currency) {
+		return nil, errInvalidCurrency
+	}
+	if !types.IsValidBestOf(bestOf) {
+		return nil, types.ErrInvalidBestOf
 	}
 	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
 		u := getUserById(uid)
 		if u == nil {
-			return -1, fmt.Errorf(errUserNotExist, uid)
+			return nil, fmt.Errorf(errUserNotExist, uid)
 		}
 		if users.IsBusyUser(uid) {
-			return -1, errAlreadyInGame
+			return nil, errAlreadyInGame
 		}
-		if u.GetBalance() < bet {
-			return -1, errBalNotSufficient
+		if matchmaker.isQueued(uid) {
+			return nil, errAlreadyInQueue
+		}
+		if u.GetBalanceOf(currency) < bet {
+			return nil, errBalNotSufficient
 		}
 		if u.GetEnergy() <= 0 {
-			return -1, errInsufficientEnergy
+			return nil, errInsufficientEnergy
 		}
 		id := normalHall.NextTableId()
 		ip := clients.BestServer()
 		host := ip + ":" + gameServerSocketPort
 		if err := clients.GetStub(ip).Create(id); err != nil {
-			return -1, err
+			return nil, err
+		}
+		if err := normalHall.NewTableWith(id, title, host, bet, func(t *types.Table) error {
+			t.SetCurrency(currency)
+			t.SetRated(rated)
+			for _, setup := range private {
+				setup(t, uid)
+			}
+			return t.SetBestOf(bestOf)
+		}); err != nil {
+			if derr := clients.GetStub(ip).Delete(id); derr != nil {
+				log.Warn("can not inform game server %v to delete table %v: %v", ip, id, derr)
+			}
+			return nil, err
 		}
-		return id, normalHall.NewTable(id, title, host, bet)
+		return normalHall.GetTableById(id), nil
 	}
-	return -1, errNotLoggedIn
+	return nil, errNotLoggedIn
 }
 
-// TODO:
-// apply for a tournament
-func (pubStub) Apply(ctx interface{}) (host, token string, err error) {
-	if tournamentHall == nil {
+// apply for the tournament
+func (pubStub) Apply(id int, ctx interface{}) (host, token string, err error) {
+	t := director.get(id)
+	if t == nil || t.GetStatus() == types.TournamentStatEnd {
 		err = errCantApplyForNilTournament
 		return
 	}
+	if t.GetStatus() != types.TournamentStatWaiting {
+		err = errTournamentNotOpen
+		return
+	}
 	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
 		u := getUserById(uid)
 		if u == nil {
@@ -462,51 +585,53 @@ func (pubStub) Apply(ctx interface{}) (host, token string, err error) {
 			return
 		}
 		session.SetSession(sessKeyUserId, uid, ctx)
-		token, err = utils.GenerateToken(uid, u.Nickname, true, false, -1)
+		host = t.GetHost()
+		token, err = utils.GenerateToken(uid, u.Nickname, true, false, id)
 		return
 	}
 	err = errNotLoggedIn
 	return
 }
 
-// withdraw
-// amount in mBTC
-func (pubStub) Withdraw(amount int, address string, ctx interface{}) (string, error) {
+// request to withdraw, the amount is frozen until the request is sent
+// amount in mBTC, the requests of the same key are the same request
+func (pubStub) Withdraw(amount int, address, key string, ctx interface{}) (map[string]interface{}, error) {
 	// check if amount >= minWithdraw
 	if amount < minWithdraw {
-		return "", errExceedMinWithdraw
-	}
-	// check btc address
-	if isValid, err := validateAddress(address); err != nil {
-		return "", err
-	} else if !isValid {
-		return "", errInvalidBtcAddr
+		return nil, errExceedMinWithdraw
 	}
 	// check if user logged in
 	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
 	if !ok {
-		return "", errNotLoggedIn
+		return nil, errNotLoggedIn
 	}
 
 	u := getUserById(uid)
 	if u == nil {
-		return "", fmt.Errorf(errUserNotExist, uid)
+		return nil, fmt.Errorf(errUserNotExist, uid)
 	}
-	// check balance
-	if u.GetBalance() < amount {
-		return "", errBalNotSufficient
+	w, err := requestWithdrawal(u, amount, address, key)
+	if err != nil {
+		return nil, err
 	}
-	// send coin
-	txid, err := sendBitcoin(address, amount)
+	return w.Wrap(), nil
+}
+
+// the withdrawals of the user, the latest first
+func (pubStub) GetWithdrawals(page int, ctx interface{}) ([]map[string]interface{}, error) {
+	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
+	if !ok {
+		return nil, errNotLoggedIn
+	}
+	offset, limit, err := pageOf(page, maxMatchesPerPage)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	// update user in the cache
-	if err := u.Update(types.NewUpdateInt(types.UF_Balance, u.GetBalance()-amount)); err != nil {
-		return "", err
+	ws, err := queryWithdrawalsOf(uid, offset, limit)
+	if err != nil {
+		return nil, err
 	}
-	pushFunc(func() { insertWithdraw(txid, u.Nickname, address, amount) })
-	return txid, nil
+	return wrapWithdrawals(ws), nil
 }
 
 // buy energy
@@ -530,11 +655,643 @@ func (pubStub) BuyEnergy(amountOfmBTC int, ctx interface{}) error {
 	if u.GetBalance() < amountOfmBTC {
 		return errBalNotSufficient
 	}
-	// update user in the cache
-	if err := u.Update(types.NewUpdateInt(types.UF_Balance, u.GetBalance()-amountOfmBTC),
-		types.NewUpdateInt(types.UF_Energy, u.GetEnergy()+amountOfmBTC*ratioEnergy2mBTC)); err != nil {
+	// the mBTC goes to the house, the house issues the energy
+	ps := types.Transfer(availableOf(uid), types.AccountHouse, types.AssetMBTC, amountOfmBTC)
+	ps = append(ps, types.Transfer(types.AccountHouse, energyOf(uid), types.AssetEnergy, amountOfmBTC*ratioEnergy2mBTC)...)
+	e, err := postEntry(journalBuyEnergy, uid, ps...)
+	if err != nil {
 		return err
 	}
-	pushFunc(func() { buyEnergy(u.Uid, amountOfmBTC) })
+	pushFunc(func() { buyEnergy(u.Uid, amountOfmBTC, e) })
 	return nil
 }
+
+// get the game results which are held for review, admin only
+func (pubStub) GetHeldResults(ctx interface{}) ([]map[string]interface{}, error) {
+	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
+	if !ok {
+		return nil, errNotLoggedIn
+	}
+	if !isAdmin(uid) {
+		return nil, errNotAdmin
+	}
+	return getHeldResults(), nil
+}
+
+// approve or void a held game result, admin only
+func (pubStub) ReviewHeldResult(id int, approve bool, ctx interface{}) error {
+	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
+	if !ok {
+		return errNotLoggedIn
+	}
+	if !isAdmin(uid) {
+		return errNotAdmin
+	}
+	log.Info("admin %d reviews held result %d, approve: %v", uid, id, approve)
+	return reviewHeldResult(id, approve)
+}
+
+// get the withdrawals waiting for review, the oldest first, admin only
+func (pubStub) GetWithdrawalReviews(page int, ctx interface{}) ([]map[string]interface{}, error) {
+	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
+	if !ok {
+		return nil, errNotLoggedIn
+	}
+	if !isAdmin(uid) {
+		return nil, errNotAdmin
+	}
+	offset, limit, err := pageOf(page, maxMatchesPerPage)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := queryWithdrawalsByStatus(withdrawReview, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return wrapWithdrawals(ws), nil
+}
+
+// approve the withdrawal to be sent, or reject it and release the frozen, admin only
+func (pubStub) ReviewWithdrawal(id int, approve bool, ctx interface{}) error {
+	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
+	if !ok {
+		return errNotLoggedIn
+	}
+	if !isAdmin(uid) {
+		return errNotAdmin
+	}
+	log.Info("admin %d reviews withdrawal %d, approve: %v", uid, id, approve)
+	return reviewWithdrawal(id, approve)
+}
+
+// get the uid of the user by nickname
+func getUidByNickname(nickname string) (int, error) {
+	u := getUserByNickname(nickname)
+	if u == nil {
+		return -1, fmt.Errorf(errUserNotExist, nickname)
+	}
+	return u.GetUid(), nil
+}
+
+// apply the relation change to the user by nickname
+func changeRelation(nickname string, ctx interface{}, change func(uid, other int) error) error {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		other, err := getUidByNickname(nickname)
+		if err != nil {
+			return err
+		}
+		if err := change(uid, other); err != nil {
+			return err
+		}
+		persistRelation(uid, other)
+		return nil
+	}
+	return errNotLoggedIn
+}
+
+// send a friend request
+func (pubStub) RequestFriend(nickname string, ctx interface{}) error {
+	return changeRelation(nickname, ctx, friends.Request)
+}
+
+// accept the friend request
+func (pubStub) AcceptFriend(nickname string, ctx interface{}) error {
+	return changeRelation(nickname, ctx, friends.Accept)
+}
+
+// decline the friend request
+func (pubStub) DeclineFriend(nickname string, ctx interface{}) error {
+	return changeRelation(nickname, ctx, friends.Decline)
+}
+
+// remove the friend
+func (pubStub) RemoveFriend(nickname string, ctx interface{}) error {
+	return changeRelation(nickname, ctx, friends.Remove)
+}
+
+// block the user, he can not send requests or messages any more
+func (pubStub) BlockUser(nickname string, ctx interface{}) error {
+	return changeRelation(nickname, ctx, friends.Block)
+}
+
+// unblock the user
+func (pubStub) UnblockUser(nickname string, ctx interface{}) error {
+	return changeRelation(nickname, ctx, friends.Unblock)
+}
+
+// get the friends with the presence
+func (pubStub) GetFriends(ctx interface{}) ([]map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		res := make([]map[string]interface{}, 0)
+		for _, fid := range friends.GetRelated(uid, types.FriendAccepted) {
+			if f := wrapFriend(fid); f != nil {
+				res = append(res, f)
+			}
+		}
+		return res, nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// get the pending friend requests and the blocked users
+func (pubStub) GetFriendRequests(ctx interface{}) (map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		nicknames := func(uids []int) []string {
+			res := make([]string, 0, len(uids))
+			for _, id := range uids {
+				if u := getUserById(id); u != nil {
+					res = append(res, u.Nickname)
+				}
+			}
+			return res
+		}
+		return map[string]interface{}{
+			"received": nicknames(friends.GetRequests(uid)),
+			"sent":     nicknames(friends.GetRelated(uid, types.FriendRequested)),
+			"blocked":  nicknames(friends.GetRelated(uid, types.FriendBlocked)),
+		}, nil
+	}
+	return nil, errNotLoggedIn
+}
+
+var errFriendNotInGame = fmt.Errorf("好友不在游戏中")
+
+// spectate the game the friend is playing or observing
+func (pubStub) SpectateFriend(nickname string, ctx interface{}) (host, token string, err error) {
+	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
+	if !ok {
+		err = errNotLoggedIn
+		return
+	}
+	fid, err := getUidByNickname(nickname)
+	if err != nil {
+		return
+	}
+	if !friends.IsFriend(uid, fid) {
+		err = types.ErrNotFriend
+		return
+	}
+	tid, ok := getPresence(fid)["table_id"].(int)
+	if !ok {
+		err = errFriendNotInGame
+		return
+	}
+	var t *types.Table
+	if isTournament(tid) {
+		if tour := director.getByTable(tid); tour != nil {
+			t = tour.GetTableById(tid)
+		}
+		token, err = pubStub{}.ObserveTournament(tid, ctx)
+	} else {
+		t = normalHall.GetTableById(tid)
+		token, err = joinNormalTable(tid, "", true, ctx)
+	}
+	if err != nil {
+		return
+	}
+	if t == nil {
+		err = errFriendNotInGame
+		return
+	}
+	return t.GetHost(), token, nil
+}
+
+// send a direct message to the friend, it is kept until the friend reads it
+func (pubStub) SendMessage(nickname, content string, ctx interface{}) error {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		fid, err := getUidByNickname(nickname)
+		if err != nil {
+			return err
+		}
+		if r, ok := friends.GetRelation(fid, uid); ok && r == types.FriendBlocked {
+			return types.ErrBlocked
+		}
+		if !friends.IsFriend(uid, fid) {
+			return types.ErrNotFriend
+		}
+		msg, err := newDirectMessage(uid, fid, content)
+		if err != nil {
+			return err
+		}
+		return insertMessage(msg)
+	}
+	return errNotLoggedIn
+}
+
+// get the unread messages, they are marked read
+func (pubStub) GetUnreadMessages(ctx interface{}) ([]map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		msgs, err := queryUnreadMessages(uid)
+		if err != nil {
+			log.Error("can not query unread messages of user %d: %v", uid, err)
+			return nil, err
+		}
+		return wrapMessages(msgs), nil
+	}
+	return nil, errNotLoggedIn
+}
+
+const maxConversationLength = 100
+
+// get the latest messages with the user
+func (pubStub) GetConversation(nickname string, limit int, ctx interface{}) ([]map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		fid, err := getUidByNickname(nickname)
+		if err != nil {
+			return nil, err
+		}
+		if limit <= 0 || limit > maxConversationLength {
+			limit = maxConversationLength
+		}
+		msgs, err := queryConversation(uid, fid, limit)
+		if err != nil {
+			log.Error("can not query conversation between %d and %d: %v", uid, fid, err)
+			return nil, err
+		}
+		return wrapMessages(msgs), nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// send a message to the lobby
+func (pubStub) SendLobbyMessage(content string, ctx interface{}) error {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		u := getUserById(uid)
+		if u == nil {
+			return errNotLoggedIn
+		}
+		filtered, err := checkChat(channelLobby, uid, content)
+		if err != nil {
+			return err
+		}
+		lobbyChat.post(uid, u.Nickname, filtered, false)
+		return nil
+	}
+	return errNotLoggedIn
+}
+
+// get the lobby messages after the id, 0 for all the recent messages
+func (pubStub) GetLobbyMessages(afterId int, ctx interface{}) ([]map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		if _, banned := lobbyChat.getSanction(uid, sanctionBan); banned {
+			return nil, errChatBanned
+		}
+		return lobbyChat.since(afterId), nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// apply the moderation to the user by nickname
+func moderate(nickname string, ctx interface{}, action func(by, uid int) error) error {
+	if by, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		if !isModerator(by) {
+			return errNotModerator
+		}
+		uid, err := getUidByNickname(nickname)
+		if err != nil {
+			return err
+		}
+		return action(by, uid)
+	}
+	return errNotLoggedIn
+}
+
+// mute the user for minutes, forever if minutes is not positive
+func (pubStub) MuteUser(nickname string, minutes int, reason string, ctx interface{}) error {
+	return moderate(nickname, ctx, func(by, uid int) error {
+		sanctionUser(by, uid, sanctionMute, minutes, reason)
+		if minutes > 0 {
+			lobbySysText(fmt.Sprintf("%s 被禁言 %d 分钟: %s", nickname, minutes, reason))
+		} else {
+			lobbySysText(fmt.Sprintf("%s 被永久禁言: %s", nickname, reason))
+		}
+		log.Info("user %d mutes %s for %d minutes: %s", by, nickname, minutes, reason)
+		return nil
+	})
+}
+
+func (pubStub) UnmuteUser(nickname string, ctx interface{}) error {
+	return moderate(nickname, ctx, func(by, uid int) error {
+		if err := liftSanction(uid, sanctionMute); err != nil {
+			return err
+		}
+		lobbySysText(fmt.Sprintf("%s 被解除禁言", nickname))
+		log.Info("user %d unmutes %s", by, nickname)
+		return nil
+	})
+}
+
+// ban the user from the chat
+func (pubStub) BanUser(nickname, reason string, ctx interface{}) error {
+	return moderate(nickname, ctx, func(by, uid int) error {
+		sanctionUser(by, uid, sanctionBan, 0, reason)
+		lobbySysText(fmt.Sprintf("%s 被禁止使用聊天: %s", nickname, reason))
+		log.Info("user %d bans %s from the chat: %s", by, nickname, reason)
+		return nil
+	})
+}
+
+func (pubStub) UnbanUser(nickname string, ctx interface{}) error {
+	return moderate(nickname, ctx, func(by, uid int) error {
+		if err := liftSanction(uid, sanctionBan); err != nil {
+			return err
+		}
+		lobbySysText(fmt.Sprintf("%s 被解除聊天禁令", nickname))
+		log.Info("user %d unbans %s from the chat", by, nickname)
+		return nil
+	})
+}
+
+// get a page of the leaderboard, the page starts from 1
+func (pubStub) GetLeaderboard(board, window string, page int, ctx interface{}) (map[string]interface{}, error) {
+	if _, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		bp, err := leaderboards.get(board, window)
+		if err != nil {
+			return nil, err
+		}
+		if page < 1 {
+			page = 1
+		}
+		offset := (page - 1) * leaderboardPageSize
+		entries := make([]map[string]interface{}, 0, leaderboardPageSize)
+		for i, e := range bp.lb.Page(offset, leaderboardPageSize) {
+			entries = append(entries, wrapRankEntry(offset+i+1, e.Uid, e.Score))
+		}
+		return map[string]interface{}{
+			"board":   board,
+			"window":  window,
+			"period":  bp.period,
+			"page":    page,
+			"total":   bp.lb.Len(),
+			"entries": entries,
+		}, nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// get my rank on the leaderboard
+func (pubStub) GetMyRank(board, window string, ctx interface{}) (map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		bp, err := leaderboards.get(board, window)
+		if err != nil {
+			return nil, err
+		}
+		rank, score, ok := bp.lb.Rank(uid)
+		if !ok {
+			return nil, errNotOnTheBoard
+		}
+		return wrapRankEntry(rank, uid, score), nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// get the match history of the user, empty nickname for myself
+func (pubStub) GetMatchHistory(nickname string, page int, ctx interface{}) ([]map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		if nickname != "" {
+			var err error
+			if uid, err = getUidByNickname(nickname); err != nil {
+				return nil, err
+			}
+		}
+		offset, limit, err := pageOf(page, maxMatchesPerPage)
+		if err != nil {
+			return nil, err
+		}
+		mrs, err := queryMatches(uid, offset, limit)
+		if err != nil {
+			log.Error("can not query matches of user %d: %v", uid, err)
+			return nil, err
+		}
+		return wrapMatches(mrs), nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// get the head-to-head record with the user
+func (pubStub) GetHeadToHead(nickname string, page int, ctx interface{}) (map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		oid, err := getUidByNickname(nickname)
+		if err != nil {
+			return nil, err
+		}
+		offset, limit, err := pageOf(page, maxMatchesPerPage)
+		if err != nil {
+			return nil, err
+		}
+		wins, err := countWinsAgainst(uid, oid)
+		if err != nil {
+			log.Error("can not count wins of user %d against %d: %v", uid, oid, err)
+			return nil, err
+		}
+		losses, err := countWinsAgainst(oid, uid)
+		if err != nil {
+			log.Error("can not count wins of user %d against %d: %v", oid, uid, err)
+			return nil, err
+		}
+		mrs, err := queryHeadToHead(uid, oid, offset, limit)
+		if err != nil {
+			log.Error("can not query matches between %d and %d: %v", uid, oid, err)
+			return nil, err
+		}
+		return map[string]interface{}{
+			"opponent": nickname,
+			"wins":     wins,
+			"losses":   losses,
+			"matches":  wrapMatches(mrs),
+		}, nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// get the achievements of the user, empty nickname for myself
+func (pubStub) GetAchievements(nickname string, ctx interface{}) ([]map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		if nickname != "" {
+			var err error
+			if uid, err = getUidByNickname(nickname); err != nil {
+				return nil, err
+			}
+		}
+		return wrapAchievements(uid), nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// get the current season
+func (pubStub) GetSeason(ctx interface{}) (map[string]interface{}, error) {
+	if _, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		s := getCurrentSeason()
+		if s == nil {
+			return nil, errNoSeason
+		}
+		return s.Wrap(), nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// get my status in the current season
+func (pubStub) GetSeasonStatus(ctx interface{}) (map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		s := getCurrentSeason()
+		if s == nil {
+			return nil, errNoSeason
+		}
+		games := s.getGames(uid)
+		placement := placementMatches - games
+		if placement < 0 {
+			placement = 0
+		}
+		res := map[string]interface{}{
+			"season":    s.Id,
+			"games":     games,
+			"placement": placement,
+			"rank":      -1,
+		}
+		if rank, _, ok := s.board.Rank(uid); ok {
+			res["rank"] = rank
+			res["reward"] = rewardOfRank(rank).Wrap()
+		}
+		return res, nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// predict the side to win the game on the table, 0 for 1p and 1 for 2p
+// only the spectators could predict, before the count down ends
+func (pubStub) Predict(tid, side, stake int, ctx interface{}) error {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		return placePrediction(tid, uid, side, stake)
+	}
+	return errNotLoggedIn
+}
+
+// get the prediction pool of the table
+func (pubStub) GetPredictionPool(tid int, ctx interface{}) (map[string]interface{}, error) {
+	if uid, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		predictionMu.Lock()
+		p, ok := predictionPools[tid]
+		predictionMu.Unlock()
+		if !ok {
+			return nil, errNoPrediction
+		}
+		totals := p.Totals()
+		return map[string]interface{}{
+			"table_id": tid,
+			"total_1p": totals[types.PredictSide1p],
+			"total_2p": totals[types.PredictSide2p],
+			"fee":      predictionFee,
+			"stake":    p.Stakes()[uid],
+		}, nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// create a tournament template, admin only
+// the recurrence is like "daily 20:00 +8" or "weekly sat 20:00 +8", the payout table is like "50,30,10,10"
+// the format is single, double, swiss or groups
+func (pubStub) CreateTournamentTemplate(name string, size, minPlayers int, feeKind string, fee, prize int, split, ruleset, format, recurrence string, registerMinutes int, ctx interface{}) (int, error) {
+	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
+	if !ok {
+		return -1, errNotLoggedIn
+	}
+	if !isAdmin(uid) {
+		return -1, errNotAdmin
+	}
+	tt, err := newTournamentTemplate(name, size, minPlayers, feeKind, fee, prize, split, ruleset, format, recurrence, registerMinutes)
+	if err != nil {
+		return -1, err
+	}
+	if err := addTemplate(tt); err != nil {
+		log.Error("can not insert the tournament template: %v", err)
+		return -1, err
+	}
+	log.Info("admin %d creates tournament template %d: %v", uid, tt.Id, tt.Wrap())
+	return tt.Id, nil
+}
+
+// enable or disable a tournament template, admin only
+func (pubStub) EnableTournamentTemplate(id int, enabled bool, ctx interface{}) error {
+	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
+	if !ok {
+		return errNotLoggedIn
+	}
+	if !isAdmin(uid) {
+		return errNotAdmin
+	}
+	log.Info("admin %d sets tournament template %d enabled: %v", uid, id, enabled)
+	return enableTemplate(id, enabled)
+}
+
+// get all tournament templates, admin only
+func (pubStub) GetTournamentTemplates(ctx interface{}) ([]map[string]interface{}, error) {
+	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
+	if !ok {
+		return nil, errNotLoggedIn
+	}
+	if !isAdmin(uid) {
+		return nil, errNotAdmin
+	}
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	res := make([]map[string]interface{}, 0, len(templates))
+	for _, tt := range templates {
+		res = append(res, tt.Wrap())
+	}
+	return res, nil
+}
+
+// get the tournaments scheduled in the coming days
+func (pubStub) GetUpcomingTournaments(ctx interface{}) ([]map[string]interface{}, error) {
+	if _, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		return upcomingTournaments(time.Now()), nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// get the bracket of the tournament, live if it is running, archived if it is finished
+func (pubStub) GetTournamentBracket(id int, ctx interface{}) (map[string]interface{}, error) {
+	if _, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		return getTournamentBracket(id)
+	}
+	return nil, errNotLoggedIn
+}
+
+// get the finished tournaments, the latest first
+func (pubStub) GetTournamentArchive(page int, ctx interface{}) ([]map[string]interface{}, error) {
+	if _, ok := session.GetSession(sessKeyUserId, ctx).(int); ok {
+		offset, limit, err := pageOf(page, maxTournamentsPerPage)
+		if err != nil {
+			return nil, err
+		}
+		tss, err := queryTournamentArchive(offset, limit)
+		if err != nil {
+			log.Error("can not query the tournament archive: %v", err)
+			return nil, err
+		}
+		res := make([]map[string]interface{}, len(tss))
+		for i, ts := range tss {
+			res[i] = ts.Wrap()
+		}
+		return res, nil
+	}
+	return nil, errNotLoggedIn
+}
+
+// get the deposits not credited yet
+func (pubStub) GetPendingDeposits(ctx interface{}) ([]map[string]interface{}, error) {
+	uid, ok := session.GetSession(sessKeyUserId, ctx).(int)
+	if !ok {
+		return nil, errNotLoggedIn
+	}
+	u := getUserById(uid)
+	if u == nil {
+		return nil, fmt.Errorf(errUserNotExist, uid)
+	}
+	ds := deposits.Pending(u.Nickname)
+	res := make([]map[string]interface{}, len(ds))
+	for i, d := range ds {
+		res[i] = wrapDeposit(d)
+	}
+	return res, nil
+}
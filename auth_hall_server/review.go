@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+// the players reported by game servers
+// results of the series they play are held for review
+var (
+	suspects  = make(map[suspectKey]map[int]string) // series of the table -> uid -> reason
+	suspectMu sync.Mutex
+
+	heldResults = make(map[int]*heldResult)
+	heldMu      sync.RWMutex
+)
+
+// status of held result
+const (
+	heldPending = iota
+	heldApproved
+	heldVoided
+)
+
+var (
+	errHeldResultNotExist = fmt.Errorf("找不到该待审核的比赛结果")
+	errNotAdmin           = fmt.Errorf("只有管理员才能进行该操作")
+)
+
+// game result which is held for review
+type heldResult struct {
+	Id, Tid, Winner, Loser, Bet int
+	Currency                    string
+	Reasons                     map[int]string
+	Created                     int64
+	// the games of the series, rated and checked for achievements if the result is approved
+	// the held results are voided on restart, so they are not stored
+	games []seriesGame
+}
+
+// for hprose
+func (hr *heldResult) Wrap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":       hr.Id,
+		"table_id": hr.Tid,
+		"winner":   hr.Winner,
+		"loser":    hr.Loser,
+		"bet":      hr.Bet,
+		"currency": hr.Currency,
+		"reasons":  hr.Reasons,
+		"created":  hr.Created,
+	}
+}
+
+// the suspects are marked in the series played at the table
+// the series of a tournament table is the tournament
+type suspectKey struct {
+	tid, series int
+}
+
+func seriesOf(tid int) int {
+	if isTournament(tid) {
+		return types.TournamentIdOf(tid)
+	}
+	if t := normalHall.GetTableById(tid); t != nil {
+		return t.GetSeriesId()
+	}
+	return 0
+}
+
+// mark the user in the current series of the table as suspect
+func markSuspect(tid, uid int, reason string) {
+	suspectMu.Lock()
+	defer suspectMu.Unlock()
+	key := suspectKey{tid, seriesOf(tid)}
+	if suspects[key] == nil {
+		suspects[key] = make(map[int]string)
+	}
+	suspects[key][uid] = reason
+}
+
+// get the suspects of the series and clear them, the next series is a new start
+func popSuspects(tid, series int) map[int]string {
+	suspectMu.Lock()
+	defer suspectMu.Unlock()
+	key := suspectKey{tid, series}
+	res := suspects[key]
+	delete(suspects, key)
+	return res
+}
+
+// clear the suspects of the table, the table is released
+func clearSuspects(tid int) {
+	suspectMu.Lock()
+	defer suspectMu.Unlock()
+	for key := range suspects {
+		if key.tid == tid {
+			delete(suspects, key)
+		}
+	}
+}
+
+// clear the suspects of the tables of the tournament, the tournament is over
+func clearTournamentSuspects(id int) {
+	suspectMu.Lock()
+	defer suspectMu.Unlock()
+	for key := range suspects {
+		if isTournament(key.tid) && key.series == id {
+			delete(suspects, key)
+		}
+	}
+}
+
+// hold the result
+func holdResult(tid, winner, loser, bet int, currency string, reasons map[int]string, games []seriesGame) error {
+	hr := &heldResult{
+		Tid:      tid,
+		Winner:   winner,
+		Loser:    loser,
+		Bet:      bet,
+		Currency: currency,
+		Reasons:  reasons,
+		Created:  time.Now().Unix(),
+		games:    games,
+	}
+	id, err := insertHeldResult(hr)
+	if err != nil {
+		return err
+	}
+	hr.Id = id
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	heldResults[id] = hr
+	return nil
+}
+
+// get all pending results
+func getHeldResults() []map[string]interface{} {
+	heldMu.RLock()
+	defer heldMu.RUnlock()
+	res := make([]map[string]interface{}, 0, len(heldResults))
+	for _, hr := range heldResults {
+		res = append(res, hr.Wrap())
+	}
+	return res
+}
+
+// approve or void the held result
+func reviewHeldResult(id int, approve bool) error {
+	heldMu.Lock()
+	hr, ok := heldResults[id]
+	delete(heldResults, id)
+	heldMu.Unlock()
+	if !ok {
+		return errHeldResultNotExist
+	}
+	// the status, the entry and the users are stored in one transaction
+	if approve {
+		e, us := settleNormalGame(hr.Tid, hr.Winner, hr.Loser, hr.Bet, hr.Currency)
+		settleGames(hr.games)
+		return updateHeldResult(id, heldApproved, e, us...)
+	}
+	e := voidNormalGame(hr.Tid, hr.Bet, hr.Currency, hr.Winner, hr.Loser)
+	return updateHeldResult(id, heldVoided, e)
+}
+
+// check if the user is an administrator
+func isAdmin(uid int) bool {
+	return admins[uid]
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+const (
+	// rated games a player plays before being ranked in the season
+	placementMatches = 5
+	// the rating keeps this part of the distance to the default at the rollover
+	seasonCarryover = 0.5
+	// the deviation after the rollover, the placement matches move the rating fast
+	seasonResetDev      = 200.0
+	seasonCheckInterval = time.Minute
+)
+
+// length of a season in days, it could be set by configuration
+var seasonDays = 90
+
+var errNoSeason = fmt.Errorf("当前没有进行中的赛季")
+
+// rewards by the final rank of the season, the first matching one is paid
+// the ranked players out of the tiers get the participation reward
+type seasonReward struct {
+	MaxRank int
+	Balance int // in mBTC
+	Energy  int
+}
+
+var seasonRewards = []seasonReward{
+	{MaxRank: 1, Balance: 100, Energy: 100},
+	{MaxRank: 10, Balance: 20, Energy: 50},
+	{MaxRank: 100, Energy: 30},
+}
+
+var participationReward = seasonReward{Energy: 10}
+
+func rewardOfRank(rank int) seasonReward {
+	for _, r := range seasonRewards {
+		if rank <= r.MaxRank {
+			return r
+		}
+	}
+	return participationReward
+}
+
+// for hprose
+func (sr seasonReward) Wrap() map[string]interface{} {
+	return map[string]interface{}{
+		"max_rank": sr.MaxRank,
+		"balance":  sr.Balance,
+		"energy":   sr.Energy,
+	}
+}
+
+type season struct {
+	Id         int
+	Name       string
+	Start, End int64
+	// rated games of the players in the season
+	games map[int]int
+	// only the players who finish the placement matches are ranked
+	board *types.Leaderboard
+	// the season is ended in database, the rewards are paid
+	ended bool
+	mu    sync.Mutex
+}
+
+func newSeason(id int, name string, start, end int64) *season {
+	return &season{
+		Id:    id,
+		Name:  name,
+		Start: start,
+		End:   end,
+		games: make(map[int]int),
+		board: types.NewLeaderboard(),
+	}
+}
+
+// for hprose
+func (s *season) Wrap() map[string]interface{} {
+	rewards := make([]map[string]interface{}, 0, len(seasonRewards)+1)
+	for _, r := range seasonRewards {
+		rewards = append(rewards, r.Wrap())
+	}
+	rewards = append(rewards, participationReward.Wrap())
+	return map[string]interface{}{
+		"id":        s.Id,
+		"name":      s.Name,
+		"start":     s.Start,
+		"end":       s.End,
+		"remaining": s.End - time.Now().Unix(),
+		"placement": placementMatches,
+		"rewards":   rewards,
+	}
+}
+
+// load the number of games of the player from database
+func (s *season) load(uid, games int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.games[uid] = games
+}
+
+// the player plays a rated game, return number of games in the season
+func (s *season) addGame(uid int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.games[uid]++
+	return s.games[uid]
+}
+
+func (s *season) getGames(uid int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.games[uid]
+}
+
+// rank the player if the placement matches are finished
+func (s *season) rank(uid int, rating float64) {
+	if s.getGames(uid) >= placementMatches {
+		s.board.Set(uid, rating)
+	}
+}
+
+var (
+	currentSeason *season
+	seasonMu      sync.RWMutex
+)
+
+func getCurrentSeason() *season {
+	seasonMu.RLock()
+	defer seasonMu.RUnlock()
+	return currentSeason
+}
+
+// load the current season, start the first one if there is none
+func initSeasons() {
+	s, err := queryCurrentSeason()
+	if err != nil {
+		log.Error("can not query the current season: %v", err)
+	}
+	if s == nil {
+		if s, err = startSeason(time.Now()); err != nil {
+			panic("can not start the season: " + err.Error())
+		}
+	}
+	for uid, games := range querySeasonGames(s.Id) {
+		s.load(uid, games)
+		if u := getUserById(uid); u != nil {
+			s.rank(uid, u.GetRating().Rating)
+		}
+	}
+	currentSeason = s
+	log.Info("initialize the season %s...", s.Name)
+	go seasonScheduler()
+}
+
+// start a new season from the time
+func startSeason(start time.Time) (*season, error) {
+	end := start.AddDate(0, 0, seasonDays)
+	name := fmt.Sprintf("%s ~ %s", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	id, err := insertSeason(name, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return newSeason(id, name, start.Unix(), end.Unix()), nil
+}
+
+// roll over the season when it ends
+func seasonScheduler() {
+	for {
+		time.Sleep(seasonCheckInterval)
+		if s := getCurrentSeason(); time.Now().Unix() >= s.End {
+			rolloverSeason(s)
+		}
+	}
+}
+
+// end the season, pay the rewards, soft reset the ratings and start the next season
+// the season is ended first, the rewards and the reset are done once, only the start is retried
+func rolloverSeason(s *season) {
+	if !s.ended {
+		log.Info("season %s ends, roll over", s.Name)
+		if err := endSeason(s.Id); err != nil {
+			log.Error("can not end the season %d, retry later: %v", s.Id, err)
+			return
+		}
+		s.ended = true
+		payRewards(s)
+		resetRatings()
+	}
+
+	next, err := startSeason(time.Unix(s.End, 0))
+	if err != nil {
+		log.Critical("can not start the next season, retry later: %v", err)
+		return
+	}
+	seasonMu.Lock()
+	currentSeason = next
+	seasonMu.Unlock()
+	lobbySysText(fmt.Sprintf("赛季 %s 已结束, 新赛季 %s 开始", s.Name, next.Name))
+	log.Info("season %s starts", next.Name)
+}
+
+// pay the rewards by the final rank of the season
+func payRewards(s *season) {
+	rewarded := make([]*types.User, 0)
+	for i, e := range s.board.Page(0, s.board.Len()) {
+		rank := i + 1
+		u := getUserById(e.Uid)
+		if u == nil {
+			continue
+		}
+		r := rewardOfRank(rank)
+		ps := types.Transfer(types.AccountHouse, availableOf(e.Uid), types.AssetMBTC, r.Balance)
+		ps = append(ps, types.Transfer(types.AccountHouse, energyOf(e.Uid), types.AssetEnergy, r.Energy)...)
+		if err := transact(journalSeasonReward, s.Id, ps...); err != nil {
+			log.Critical("can not pay the season reward to %v: %v", u.Nickname, err)
+			continue
+		}
+		rewarded = append(rewarded, u)
+		if err := updateSeasonPlayer(s.Id, e.Uid, rank, e.Score); err != nil {
+			log.Error("can not update the final rank of %v in season %d: %v", u.Nickname, s.Id, err)
+		}
+	}
+	insertOrUpdateUser(rewarded...)
+	log.Info("season %s, %d players rewarded", s.Name, len(rewarded))
+}
+
+// soft reset the ratings for the next season
+func resetRatings() {
+	reset := users.SoftResetRatings(seasonCarryover, seasonResetDev)
+	updateRatings(reset...)
+	for _, u := range reset {
+		leaderboards.set(boardRating, u.GetUid(), u.GetRating().Rating)
+	}
+	log.Info("%d ratings reset", len(reset))
+}
+
+// the player plays a rated game in the current season
+func recordSeasonGame(u *types.User) {
+	s := getCurrentSeason()
+	if s == nil {
+		return
+	}
+	uid, sid := u.GetUid(), s.Id
+	games := s.addGame(uid)
+	s.rank(uid, u.GetRating().Rating)
+	pushFunc(func() { updateSeasonGames(sid, uid, games) })
+}
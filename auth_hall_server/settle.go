@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+// freeze the bet of the players in the currency of the table when a series starts
+// the bets of both players are posted in one entry, stored with their balances in one transaction
+func freezeBet(tid, bet int, currency string, uids ...int) error {
+	ps := make([]types.Posting, 0, 2*len(uids))
+	for _, uid := range uids {
+		u := getUserById(uid)
+		if u == nil {
+			return fmt.Errorf(errUserNotExist, uid)
+		}
+		if u.GetBalanceOf(currency) < bet {
+			return errBalNotSufficient
+		}
+		ps = append(ps, types.Transfer(availableOf(uid), frozenOf(uid), currency, bet)...)
+	}
+	// the bets of both players are frozen, or neither
+	// the balance may change after the check, the overdraft fails the whole entry
+	err := transact(journalBetFreeze, tid, ps...)
+	if err == types.ErrOverdraft {
+		return errBalNotSufficient
+	}
+	return err
+}
+
+// settle the bet when the series is over
+// the result of the table with suspects is held for review
+// return the settle entry and the users of the result, they are stored with the match in one transaction
+// the users are nil if the result is held
+func settleSeries(tid, winner, loser, bet int, ip string) (*types.JournalEntry, []*types.User) {
+	t := normalHall.GetTableById(tid)
+	ws, ls := t.GetSeriesScore(winner), t.GetSeriesScore(loser)
+	currency := t.GetCurrency()
+	series := t.GetSeriesId()
+	games := popSeriesGames(tid, series)
+	if reasons := popSuspects(tid, series); len(reasons) > 0 {
+		if err := holdResult(tid, winner, loser, bet, currency, reasons, games); err != nil {
+			log.Critical("can not hold the result of table %d, settle it: %v", tid, err)
+		} else {
+			if err := clients.GetStub(ip).HoldGameResult(tid, winner); err != nil {
+				log.Warn("can not inform game server to hold the game result: %v", err)
+			}
+			return nil, nil
+		}
+	}
+
+	e, us := settleNormalGame(tid, winner, loser, bet, currency)
+	settleGames(games)
+
+	if err := clients.GetStub(ip).SetNormalGameResult(tid, winner, bet, ws, ls, true); err != nil {
+		log.Warn("can not inform game server to set the game result: %v", err)
+	}
+	return e, us
+}
+
+// settle the bet of a normal game, update win & lose
+// return the posted entry, nil if nothing is posted, and the updated users, the caller stores them together
+func settleNormalGame(tid, winner, loser, bet int, currency string) (*types.JournalEntry, []*types.User) {
+	w, l := getUserById(winner), getUserById(loser)
+
+	// the winner takes both bets
+	e, err := postEntry(journalBetSettle, tid,
+		types.NewPosting(frozenOf(winner), currency, -bet),
+		types.NewPosting(frozenOf(loser), currency, -bet),
+		types.NewPosting(availableOf(winner), currency, bet*2))
+	if err != nil {
+		if err != types.ErrEmptyEntry {
+			log.Critical("set normal hall result, can not settle the bet %d %s of table %d: %v", bet, currency, tid, err)
+		}
+		e = nil
+	}
+	recordWinnings(currency, winner, loser, bet)
+
+	// update winner info
+	func() {
+		upts := make([]types.UpdateInterface, 0)
+		upts = append(upts, types.NewUpdateInt(types.UF_Win, w.Win+1))
+		// level is only a badge for display, matching is by the rating
+		if w.Win > (w.Level * w.Level) {
+			upts = append(upts, types.NewUpdateInt(types.UF_Level, w.Level+1))
+		}
+		if err := w.Update(upts...); err != nil {
+			log.Critical("set normal hall result, can not update winner %v: %v", w.Nickname, err)
+		}
+		recordWin(w)
+	}()
+
+	// update loser info
+	func() {
+		if err := l.Update(types.NewUpdateInt(types.UF_Lose, l.Lose+1)); err != nil {
+			log.Critical("set normal hall game result, can not update loser %v: %v", l.Nickname, err)
+		}
+	}()
+	return e, []*types.User{w, l}
+}
+
+// void the result of a normal game, the bet is returned to both players
+// return the posted entry, nil if nothing is posted, the caller stores it
+func voidNormalGame(tid, bet int, currency string, uids ...int) *types.JournalEntry {
+	ps := make([]types.Posting, 0, 2*len(uids))
+	for _, uid := range uids {
+		if getUserById(uid) != nil {
+			ps = append(ps, types.Transfer(frozenOf(uid), availableOf(uid), currency, bet)...)
+		}
+	}
+	e, err := postEntry(journalBetVoid, tid, ps...)
+	if err != nil {
+		if err != types.ErrEmptyEntry {
+			log.Critical("void normal game, can not return the bet %d %s of table %d to %v: %v", bet, currency, tid, uids, err)
+		}
+		return nil
+	}
+	return e
+}
+
+// a game of a series, rated and checked for achievements once the series is settled
+type seriesGame struct {
+	mr    *matchRecord
+	rated bool
+}
+
+var (
+	seriesGames   = make(map[suspectKey][]seriesGame) // series of the table -> games
+	seriesGamesMu sync.Mutex
+)
+
+// add the game to the current series of the table
+func addSeriesGame(tid int, mr *matchRecord, rated bool) {
+	seriesGamesMu.Lock()
+	defer seriesGamesMu.Unlock()
+	key := suspectKey{tid, seriesOf(tid)}
+	seriesGames[key] = append(seriesGames[key], seriesGame{mr, rated})
+}
+
+// get the games of the series and clear them
+func popSeriesGames(tid, series int) []seriesGame {
+	seriesGamesMu.Lock()
+	defer seriesGamesMu.Unlock()
+	key := suspectKey{tid, series}
+	res := seriesGames[key]
+	delete(seriesGames, key)
+	return res
+}
+
+// clear the games of the table, the table is released
+func clearSeriesGames(tid int) {
+	seriesGamesMu.Lock()
+	defer seriesGamesMu.Unlock()
+	for key := range seriesGames {
+		if key.tid == tid {
+			delete(seriesGames, key)
+		}
+	}
+}
+
+// rate the rated games and check the achievements of the settled series
+func settleGames(games []seriesGame) {
+	for _, g := range games {
+		if g.rated {
+			rateGame(g.mr.Winner, g.mr.Loser)
+		}
+		checkAchievements(g.mr, g.mr.Server)
+	}
+}
+
+// update the ratings of the players after a rated game
+func rateGame(winner, loser int) {
+	w, l := getUserById(winner), getUserById(loser)
+	if w == nil || l == nil {
+		log.Warn("can not rate the game, winner %d or loser %d does not exist", winner, loser)
+		return
+	}
+	wr, lr := w.GetRating().Rating, l.GetRating().Rating
+	types.RateGame(w, l)
+	recordRating(w, wr)
+	recordRating(l, lr)
+	recordSeasonGame(w)
+	recordSeasonGame(l)
+	pushFunc(func() { updateRatings(w, l) })
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+// the currency of the entry fee
+const (
+	feeEnergy = "energy"
+	feeMBTC   = "mBTC"
+)
+
+// the ruleset of the tournament games
+const (
+	rulesetRated  = "rated"
+	rulesetCasual = "casual"
+)
+
+const (
+	maxTemplateSize = 1 << 7
+	// upcoming tournaments are listed in the days
+	upcomingDays = 7
+)
+
+var (
+	errTemplateNotExist   = fmt.Errorf("争霸赛模板不存在")
+	errInvalidTemplateSz  = fmt.Errorf("参赛人数只能是 2 到 %d 之间", maxTemplateSize)
+	errInvalidFeeKind     = fmt.Errorf("报名费只能是 %s 或者 %s", feeEnergy, feeMBTC)
+	errInvalidFee         = fmt.Errorf("报名费和奖金不能小于 0")
+	errInvalidSplit       = fmt.Errorf("奖金分配格式错误, 例如: 50,30,10,10, 名次不能多于参赛人数, 总和不能超过 100")
+	errInvalidMinPlayers  = fmt.Errorf("最少开赛人数只能是 2 到参赛人数之间")
+	errInvalidRuleset     = fmt.Errorf("规则只能是 %s 或者 %s", rulesetRated, rulesetCasual)
+	errInvalidRegisterMin = fmt.Errorf("报名时间必须大于 0 分钟")
+)
+
+// an admin defined tournament, instantiated by the recurrence
+type tournamentTemplate struct {
+	Id              int
+	Name            string
+	Size            int
+	FeeKind         string
+	Fee             int
+	Prize           int   // in mBTC, contributed by the house, the mBTC fees are added to the pool
+	Split           []int // the payout table, percent of the pool by place, gold first
+	Ruleset         string
+	Format          string // bracket format, the field needs not be a power of 2
+	Recurrence      types.Recurrence
+	RegisterMinutes int // registration opens before the start
+	MinPlayers      int // the tournament is cancelled if less players applied at the start
+	Enabled         bool
+}
+
+// parse the payout table like "70,30" or "50,30,10,10"
+func parseSplit(s string) ([]int, error) {
+	res := make([]int, 0)
+	total := 0
+	for _, v := range strings.Split(s, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || p < 0 {
+			return nil, errInvalidSplit
+		}
+		total += p
+		res = append(res, p)
+	}
+	if total > 100 {
+		return nil, errInvalidSplit
+	}
+	return res, nil
+}
+
+func formatSplit(split []int) string {
+	res := make([]string, len(split))
+	for i, p := range split {
+		res[i] = strconv.Itoa(p)
+	}
+	return strings.Join(res, ",")
+}
+
+func newTournamentTemplate(name string, size, minPlayers int, feeKind string, fee, prize int, split, ruleset, format, recurrence string, registerMinutes int) (*tournamentTemplate, error) {
+	if size < 2 || size > maxTemplateSize {
+		return nil, errInvalidTemplateSz
+	}
+	if minPlayers < 2 || minPlayers > size {
+		return nil, errInvalidMinPlayers
+	}
+	if feeKind != feeEnergy && feeKind != feeMBTC {
+		return nil, errInvalidFeeKind
+	}
+	if fee < 0 || prize < 0 {
+		return nil, errInvalidFee
+	}
+	sp, err := parseSplit(split)
+	if err != nil {
+		return nil, err
+	}
+	if len(sp) > size {
+		return nil, errInvalidSplit
+	}
+	if ruleset != rulesetRated && ruleset != rulesetCasual {
+		return nil, errInvalidRuleset
+	}
+	if !types.IsValidFormat(format) {
+		return nil, types.ErrInvalidFormat
+	}
+	r, err := types.ParseRecurrence(recurrence)
+	if err != nil {
+		return nil, err
+	}
+	if registerMinutes <= 0 {
+		return nil, errInvalidRegisterMin
+	}
+	return &tournamentTemplate{
+		Name:            name,
+		Size:            size,
+		FeeKind:         feeKind,
+		Fee:             fee,
+		Prize:           prize,
+		Split:           sp,
+		Ruleset:         ruleset,
+		Format:          format,
+		Recurrence:      r,
+		RegisterMinutes: registerMinutes,
+		MinPlayers:      minPlayers,
+		Enabled:         true,
+	}, nil
+}
+
+// the prizes of the places by the payout table, in mBTC
+func (tt *tournamentTemplate) prizes(pool int) []int {
+	res := make([]int, len(tt.Split))
+	for i, p := range tt.Split {
+		res[i] = pool * p / 100
+	}
+	return res
+}
+
+// the awards of the gold and silver getters, in mBTC
+func (tt *tournamentTemplate) awards(pool int) (gold, silver int) {
+	prizes := tt.prizes(pool)
+	if len(prizes) > 0 {
+		gold = prizes[0]
+	}
+	if len(prizes) > 1 {
+		silver = prizes[1]
+	}
+	return
+}
+
+// for hprose
+func (tt *tournamentTemplate) Wrap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":               tt.Id,
+		"name":             tt.Name,
+		"size":             tt.Size,
+		"fee_kind":         tt.FeeKind,
+		"fee":              tt.Fee,
+		"prize":            tt.Prize,
+		"split":            tt.Split,
+		"ruleset":          tt.Ruleset,
+		"format":           tt.Format,
+		"recurrence":       tt.Recurrence.String(),
+		"register_minutes": tt.RegisterMinutes,
+		"min_players":      tt.MinPlayers,
+		"enabled":          tt.Enabled,
+	}
+}
+
+var (
+	templates   = make(map[int]*tournamentTemplate)
+	templatesMu sync.RWMutex
+)
+
+func initTemplates() {
+	for _, tt := range queryTemplates() {
+		templates[tt.Id] = tt
+	}
+	log.Info("initialize %d tournament templates...", len(templates))
+}
+
+func getTemplate(id int) *tournamentTemplate {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	return templates[id]
+}
+
+// the enabled templates
+func enabledTemplates() []*tournamentTemplate {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	res := make([]*tournamentTemplate, 0, len(templates))
+	for _, tt := range templates {
+		if tt.Enabled {
+			res = append(res, tt)
+		}
+	}
+	return res
+}
+
+func addTemplate(tt *tournamentTemplate) error {
+	id, err := insertTemplate(tt)
+	if err != nil {
+		return err
+	}
+	tt.Id = id
+	templatesMu.Lock()
+	templates[id] = tt
+	templatesMu.Unlock()
+	return nil
+}
+
+// enable or disable the template, the running tournaments are not affected
+func enableTemplate(id int, enabled bool) error {
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	tt, ok := templates[id]
+	if !ok {
+		return errTemplateNotExist
+	}
+	if err := updateTemplateEnabled(id, enabled); err != nil {
+		return err
+	}
+	tt.Enabled = enabled
+	return nil
+}
+
+// a tournament to be instantiated from the template
+type upcomingTournament struct {
+	tt    *tournamentTemplate
+	start time.Time
+}
+
+// for hprose
+func (ut upcomingTournament) Wrap() map[string]interface{} {
+	// the guaranteed awards contributed by the house
+	gold, silver := ut.tt.awards(ut.tt.Prize)
+	return map[string]interface{}{
+		"template":       ut.tt.Id,
+		"name":           ut.tt.Name,
+		"size":           ut.tt.Size,
+		"min_players":    ut.tt.MinPlayers,
+		"fee_kind":       ut.tt.FeeKind,
+		"fee":            ut.tt.Fee,
+		"award_gold":     gold,
+		"award_silver":   silver,
+		"split":          ut.tt.Split,
+		"ruleset":        ut.tt.Ruleset,
+		"format":         ut.tt.Format,
+		"register_start": ut.start.Add(-time.Duration(ut.tt.RegisterMinutes) * time.Minute).Unix(),
+		"start":          ut.start.Unix(),
+	}
+}
+
+type byStart []upcomingTournament
+
+func (bs byStart) Len() int           { return len(bs) }
+func (bs byStart) Less(i, j int) bool { return bs[i].start.Before(bs[j].start) }
+func (bs byStart) Swap(i, j int)      { bs[i], bs[j] = bs[j], bs[i] }
+
+// the upcoming tournaments of the enabled templates, sorted by the start
+func upcomingTournaments(tNow time.Time) []map[string]interface{} {
+	until := tNow.AddDate(0, 0, upcomingDays)
+	upcoming := make([]upcomingTournament, 0)
+	for _, tt := range enabledTemplates() {
+		for start := tt.Recurrence.Next(tNow); start.Before(until); start = tt.Recurrence.Next(start) {
+			upcoming = append(upcoming, upcomingTournament{tt: tt, start: start})
+		}
+	}
+	sort.Sort(byStart(upcoming))
+	res := make([]map[string]interface{}, 0, len(upcoming))
+	for _, ut := range upcoming {
+		res = append(res, ut.Wrap())
+	}
+	return res
+}
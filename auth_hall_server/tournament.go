@@ -0,0 +1,543 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+const (
+	directorInterval = time.Second
+	// count down before each round starts, the players get ready in the tables
+	tournamentPending = 30 * time.Second
+	// the games of the round not reported in time are forfeited
+	tournamentRoundTimeout = 15 * time.Minute
+)
+
+// status of the tournament in database
+const (
+	tournamentRunning = iota
+	tournamentFinished
+	tournamentCancelled
+)
+
+var (
+	errTournamentNotExist = fmt.Errorf("争霸赛不存在或者已经结束")
+	errTournamentNotOpen  = fmt.Errorf("争霸赛报名已截止, 请参加下期的争霸赛~")
+	errTournamentNotFull  = fmt.Errorf("报名人数不足")
+)
+
+// a tournament instantiated from the template
+type tournament struct {
+	*types.TournamentHall
+	template *tournamentTemplate
+	ip       string    // the game server
+	start    time.Time // registration closes and the first round starts
+	roundAt  time.Time // the pending round starts
+	roundEnd time.Time // the games of the round in progress time out
+	bracket  types.Bracket
+	matches  map[int]int          // table id -> match id of the games in progress
+	seats    map[int]int          // uid -> table id of the player waiting on the game server
+	results  map[int]*matchResult // match id -> result of the table
+	payouts  map[int]int          // uid -> prize paid at the end
+	// entry fees and the prize pool
+	fees      map[int]int // uid -> entry fee frozen or paid
+	pool      int         // mBTC fees and the house contribution
+	collected bool        // the fees are paid into the pool
+	feesMu    sync.Mutex
+}
+
+// for hprose
+func (t *tournament) Wrap() map[string]interface{} {
+	res := t.TournamentHall.Wrap()
+	res["name"] = t.template.Name
+	res["template"] = t.template.Id
+	res["fee_kind"] = t.template.FeeKind
+	res["fee"] = t.template.Fee
+	res["ruleset"] = t.template.Ruleset
+	res["format"] = t.template.Format
+	res["start"] = t.start.Unix()
+	pool := t.prizePool()
+	prizes := t.template.prizes(pool)
+	gold, silver := t.template.awards(pool)
+	res["awardGold"] = fmt.Sprintf("%d mBTC", gold)
+	res["awardSilver"] = fmt.Sprintf("%d mBTC", silver)
+	res["prize_pool"] = pool
+	res["prizes"] = prizes
+	return res
+}
+
+// the prize pool, the applicants' fees are counted before the start
+func (t *tournament) prizePool() int {
+	t.feesMu.Lock()
+	defer t.feesMu.Unlock()
+	return t.pool
+}
+
+// the director runs the tournaments of the templates by the schedule
+// registration -> pending -> round 1 -> pending -> round 2 ... -> awards
+// the bracket engine of the template pairs the rounds
+// the calls to the game server are queued under the lock and made once it is released
+type tournamentDirector struct {
+	nextStart map[int]time.Time   // template id -> start of the next tournament, only the director touches it
+	running   map[int]*tournament // tournament id -> tournament
+	calls     []func()            // the queued calls to the game server
+	mu        sync.Mutex
+}
+
+var director = &tournamentDirector{
+	nextStart: make(map[int]time.Time),
+	running:   make(map[int]*tournament),
+}
+
+func initTournament() {
+	initTemplates()
+	// the tournaments interrupted by the restart are cancelled, the fees are refunded
+	if err := refundInterruptedEntries(); err != nil {
+		log.Error("can not refund the entries of the interrupted tournaments: %v", err)
+	}
+	if err := cancelRunningTournaments(); err != nil {
+		log.Error("can not cancel the interrupted tournaments: %v", err)
+	}
+	go director.serve()
+	log.Info("initialize the tournament director...")
+}
+
+func (td *tournamentDirector) serve() {
+	for {
+		time.Sleep(directorInterval)
+		td.tick(time.Now())
+	}
+}
+
+// queue the call to the game server
+// the director lock should be held
+func (td *tournamentDirector) later(f func()) {
+	td.calls = append(td.calls, f)
+}
+
+// release the lock and make the queued calls in order
+func (td *tournamentDirector) unlock() {
+	calls := td.calls
+	td.calls = nil
+	td.mu.Unlock()
+	for _, f := range calls {
+		f()
+	}
+}
+
+// get the tournament by id
+func (td *tournamentDirector) get(id int) *tournament {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	return td.running[id]
+}
+
+// get the tournament of the table
+func (td *tournamentDirector) getByTable(tid int) *tournament {
+	return td.get(types.TournamentIdOf(tid))
+}
+
+// all running tournaments
+func (td *tournamentDirector) all() []*tournament {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	res := make([]*tournament, 0, len(td.running))
+	for _, t := range td.running {
+		res = append(res, t)
+	}
+	return res
+}
+
+func (td *tournamentDirector) tick(tNow time.Time) {
+	// open the registration of the templates, the tournament is stored out of the lock
+	for _, tt := range enabledTemplates() {
+		start, ok := td.nextStart[tt.Id]
+		if !ok {
+			start = tt.Recurrence.Next(tNow)
+			td.nextStart[tt.Id] = start
+		}
+		if tNow.Before(start.Add(-time.Duration(tt.RegisterMinutes) * time.Minute)) {
+			continue
+		}
+		t, err := td.open(tt, start)
+		if err != nil {
+			log.Warn("can not open the tournament of template %d, retry later: %v", tt.Id, err)
+			continue
+		}
+		td.mu.Lock()
+		td.running[t.GetId()] = t
+		td.mu.Unlock()
+		td.nextStart[tt.Id] = tt.Recurrence.Next(start)
+	}
+
+	td.mu.Lock()
+	defer td.unlock()
+	for id, t := range td.running {
+		td.run(t, tNow)
+		if t.GetStatus() == types.TournamentStatEnd {
+			delete(td.running, id)
+		}
+	}
+}
+
+// move the tournament forward
+func (td *tournamentDirector) run(t *tournament, tNow time.Time) {
+	switch t.GetStatus() {
+	case types.TournamentStatWaiting:
+		if tNow.Before(t.start) {
+			return
+		}
+		if err := td.seed(t); err != nil {
+			td.cancel(t)
+			return
+		}
+		td.pend(t, tNow)
+	case types.TournamentStatPending:
+		if !tNow.Before(t.roundAt) {
+			td.startRound(t, tNow)
+		}
+	case types.TournamentStatInGame:
+		// wait for the games of the round, the games not reported in time are forfeited
+		if len(t.matches) > 0 {
+			if tNow.Before(t.roundEnd) {
+				return
+			}
+			td.timeout(t)
+		}
+		td.eliminate(t)
+		if t.bracket.IsOver() {
+			td.crown(t)
+			return
+		}
+		td.pend(t, tNow)
+	}
+}
+
+// open the registration of a new tournament from the template
+func (td *tournamentDirector) open(tt *tournamentTemplate, start time.Time) (*tournament, error) {
+	ip := clients.BestServer()
+	if ip == "" {
+		return nil, errNoGameServer
+	}
+	id, err := insertTournament(tt.Id, tt.Name, start.Unix())
+	if err != nil {
+		return nil, err
+	}
+	gold, silver := tt.awards(tt.Prize)
+	th := types.NewTournamentHall(tt.Size, gold, silver, ip+":"+gameServerSocketPort)
+	th.SetId(id)
+	t := &tournament{
+		TournamentHall: th,
+		template:       tt,
+		ip:             ip,
+		start:          start,
+		matches:        make(map[int]int),
+		seats:          make(map[int]int),
+		results:        make(map[int]*matchResult),
+		fees:           make(map[int]int),
+		pool:           tt.Prize,
+	}
+	lobbySysText(fmt.Sprintf("争霸赛 %s 开始报名, 共 %d 个名额, 报名费 %d %s, 冠军保底奖励 %d mBTC, 亚军保底奖励 %d mBTC, 比赛将于 %s 开始",
+		tt.Name, tt.Size, tt.Fee, tt.FeeKind, gold, silver, start.Format("01-02 15:04")))
+	log.Info("open the tournament %d of template %d on game server %s, starts at %v", id, tt.Id, ip, start)
+	return t, nil
+}
+
+// close the registration and seed the players into the bracket
+// the players wait at the registration tables of the game server
+func (td *tournamentDirector) seed(t *tournament) error {
+	entrants := make([]types.Entrant, 0)
+	seats := make(map[int]int)
+	for _, tb := range t.GetAllTables() {
+		for _, uid := range tb.GetPlayers() {
+			if uid <= 0 {
+				continue
+			}
+			rating := types.DefaultRating
+			if u := getUserById(uid); u != nil {
+				rating = u.GetRating().Rating
+			}
+			entrants = append(entrants, types.Entrant{Uid: uid, Rating: rating})
+			seats[uid] = tb.TId
+		}
+	}
+	if len(entrants) < t.template.MinPlayers {
+		return errTournamentNotFull
+	}
+	b, err := types.NewBracket(t.template.Format, entrants)
+	if err != nil {
+		return err
+	}
+	for _, tb := range t.GetAllTables() {
+		t.DelTable(tb.TId)
+	}
+	t.bracket, t.seats = b, seats
+	t.collectFees(seats)
+	log.Info("the tournament %d starts with %d players, format %s, prize pool %d mBTC", t.GetId(), len(entrants), b.Format(), t.prizePool())
+	return nil
+}
+
+// not enough candidates at the start, cancel the tournament and refund the fees
+func (td *tournamentDirector) cancel(t *tournament) {
+	t.refundFees()
+	for _, tb := range t.GetAllTables() {
+		ip, tid := t.ip, tb.TId
+		td.later(func() {
+			if err := clients.GetStub(ip).Delete(tid); err != nil {
+				log.Warn("can not inform game server %v to delete table %v: %v", ip, tid, err)
+			}
+		})
+		users.SetFree(tb.GetAllUsers()...)
+	}
+	td.finish(t, tournamentCancelled)
+	lobbySysText(fmt.Sprintf("报名人数不足, 争霸赛 %s 取消", t.template.Name))
+	log.Info("the tournament %d is cancelled, not enough candidates", t.GetId())
+}
+
+// end the tournament
+func (td *tournamentDirector) finish(t *tournament, status int) {
+	t.SetStatEnd()
+	id := t.GetId()
+	clearTournamentSuspects(id)
+	pushFunc(func() { endTournament(id, status) })
+}
+
+// count down before the tables of the next round start
+func (td *tournamentDirector) pend(t *tournament, tNow time.Time) {
+	t.SetStatPending()
+	t.roundAt = tNow.Add(tournamentPending)
+	ip, text := t.ip, fmt.Sprintf("%s 将于 %d 秒后开始", t.GetStat(), int(tournamentPending.Seconds()))
+	notified := make(map[int]bool)
+	for _, tid := range t.seats {
+		if notified[tid] {
+			continue
+		}
+		notified[tid] = true
+		tid := tid
+		td.later(func() {
+			if err := clients.GetStub(ip).Notify(tid, text); err != nil {
+				log.Debug("can not notify table %d of the round: %v", tid, err)
+			}
+		})
+	}
+}
+
+// pair the round and start the tables
+func (td *tournamentDirector) startRound(t *tournament, tNow time.Time) {
+	t.SetStatInGame()
+	t.roundEnd = tNow.Add(tournamentRoundTimeout)
+	pairings := t.bracket.NextRound()
+	if len(pairings) == 0 {
+		log.Critical("the bracket of tournament %d pairs nothing before it is over", t.GetId())
+		td.crown(t)
+		return
+	}
+	for i, p := range pairings {
+		_, seated1 := t.seats[p.P1]
+		_, seated2 := t.seats[p.P2]
+		switch {
+		case p.IsBye():
+			td.notify(t, p.P1, "本轮轮空, 直接获胜")
+		case !seated1 || !seated2:
+			td.forfeit(t, p, seated2 && !seated1, "对手未到场, 直接获胜")
+		default:
+			td.startMatch(t, i+1, p)
+		}
+	}
+	td.pushBracket(t)
+}
+
+// move the players of the match to a new table and start it
+func (td *tournamentDirector) startMatch(t *tournament, n int, p types.Pairing) {
+	nid, err := t.NewMatchTable(n, getUserById(p.P1), getUserById(p.P2))
+	if err != nil {
+		log.Critical("can not create table %d for match %d of tournament %d: %v", nid, p.Id, t.GetId(), err)
+		t.DelTable(nid)
+		td.forfeit(t, p, false, "对手未到场, 直接获胜")
+		return
+	}
+	ip := t.ip
+	for _, uid := range []int{p.P1, p.P2} {
+		uid, from := uid, t.seats[uid]
+		td.later(func() {
+			if err := clients.GetStub(ip).Advance(from, uid, nid); err != nil {
+				log.Warn("can not inform game server to move user %d to table %d: %v", uid, nid, err)
+			}
+		})
+		t.seats[uid] = nid
+	}
+	t.matches[nid] = p.Id
+	t.results[p.Id] = &matchResult{tid: nid}
+	tb := t.GetTableById(nid)
+	td.later(func() { startTable(tb, ip) })
+}
+
+// the games of the round are not reported in time
+// the player still at the table wins, the player 1 wins if both are there or both left
+func (td *tournamentDirector) timeout(t *tournament) {
+	pairings := make(map[int]types.Pairing)
+	for _, p := range t.bracket.Matches() {
+		pairings[p.Id] = p
+	}
+	for tid, id := range t.matches {
+		p := pairings[id]
+		log.Warn("match %d of tournament %d at table %d is not reported in time", id, t.GetId(), tid)
+		delete(t.matches, tid)
+		td.forfeit(t, p, t.seats[p.P1] != tid && t.seats[p.P2] == tid, "本局超时, 判定获胜")
+	}
+}
+
+// the player who left forfeits the match
+// the player 1 wins if both left
+func (td *tournamentDirector) forfeit(t *tournament, p types.Pairing, is2pWin bool, text string) {
+	winner := p.P1
+	if is2pWin {
+		winner = p.P2
+	}
+	if err := t.bracket.Report(p.Id, winner); err != nil {
+		log.Critical("can not report the forfeit of match %d of tournament %d: %v", p.Id, t.GetId(), err)
+		return
+	}
+	t.results[p.Id] = &matchResult{forfeit: true}
+	td.notify(t, winner, text)
+	log.Info("user %d wins match %d of tournament %d by forfeit", winner, p.Id, t.GetId())
+}
+
+// send the system message to the table of the player
+func (td *tournamentDirector) notify(t *tournament, uid int, text string) {
+	tid, ok := t.seats[uid]
+	if !ok {
+		return
+	}
+	ip, text := t.ip, fmt.Sprintf("%s: %s", getUserById(uid).GetNickname(), text)
+	td.later(func() {
+		if err := clients.GetStub(ip).Notify(tid, text); err != nil {
+			log.Debug("can not notify table %d: %v", tid, err)
+		}
+	})
+}
+
+// report the result of the game, the players wait at the table for the next round
+func (td *tournamentDirector) report(t *tournament, mr *matchRecord) error {
+	td.mu.Lock()
+	defer td.unlock()
+	id, ok := t.matches[mr.Tid]
+	if !ok {
+		return fmt.Errorf(errTableNotExist, mr.Tid)
+	}
+	if r := t.results[id]; r != nil {
+		r.score1, r.score2, r.replay = mr.WinnerKo, mr.LoserKo, mr.Replay
+		if tb := t.GetTableById(mr.Tid); tb != nil && tb.Get1pUid() != mr.Winner {
+			r.score1, r.score2 = r.score2, r.score1
+		}
+	}
+	delete(t.matches, mr.Tid)
+	t.SetWinnerLoser(mr.Tid, mr.Winner)
+	if err := t.bracket.Report(id, mr.Winner); err != nil {
+		return err
+	}
+	td.pushBracket(t)
+	return nil
+}
+
+// apply for the tournament, the entry fee is frozen
+func (td *tournamentDirector) apply(t *tournament, u *types.User) (int, error) {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	if t.GetStatus() != types.TournamentStatWaiting {
+		return -1, errTournamentNotOpen
+	}
+	if t.hasApplied(u.GetUid()) {
+		return -1, errAlreadyApplied
+	}
+	if err := t.freezeFee(u); err != nil {
+		return -1, err
+	}
+	tid, err := t.Apply(u)
+	if err != nil {
+		t.refundFee(u.GetUid())
+		return -1, err
+	}
+	return tid, nil
+}
+
+// the player leaves the game server
+// the fee is refunded before the start, the player forfeits the next match after
+func (td *tournamentDirector) quit(t *tournament, uid int) {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	if t.GetStatus() == types.TournamentStatWaiting {
+		t.refundFee(uid)
+	}
+	delete(t.seats, uid)
+}
+
+// the players out of the bracket leave the game server
+func (td *tournamentDirector) eliminate(t *tournament) {
+	ranks := make(map[int]int)
+	for _, s := range t.bracket.Standings() {
+		ranks[s.Uid] = s.Rank
+	}
+	for uid, tid := range t.seats {
+		if t.bracket.IsAlive(uid) {
+			continue
+		}
+		td.eliminateLater(t.ip, tid, uid, fmt.Sprintf("你已被淘汰, 排名第 %d, 再接再厉!", ranks[uid]))
+		delete(t.seats, uid)
+		users.SetFree(uid)
+	}
+}
+
+// pay the prizes by the standings and end the tournament
+func (td *tournamentDirector) crown(t *tournament) {
+	standings := t.bracket.Standings()
+	gold, silver := getUserById(standings[0].Uid), getUserById(standings[1].Uid)
+	t.SetGold(gold.GetNickname())
+	t.SetSilver(silver.GetNickname())
+	td.finish(t, tournamentFinished)
+	recordTitle(gold.GetUid())
+
+	payouts := t.payPrizes(standings)
+	t.payouts = payouts
+	id, goldUid, silverUid := t.GetId(), gold.GetUid(), silver.GetUid()
+	pushFunc(func() { updateTournamentGetters(id, goldUid, silverUid) })
+	td.archive(t)
+	td.pushBracket(t)
+
+	// the players left leave the game server with the final standings
+	for _, s := range standings {
+		tid, ok := t.seats[s.Uid]
+		if !ok {
+			continue
+		}
+		text := fmt.Sprintf("争霸赛结束, 你的排名是第 %d", s.Rank)
+		switch s.Uid {
+		case goldUid:
+			text = "恭喜你获得冠军!"
+		case silverUid:
+			text = "恭喜你获得亚军!"
+		}
+		if payout := payouts[s.Uid]; payout > 0 {
+			text += fmt.Sprintf(" 奖金 %d mBTC", payout)
+		}
+		td.eliminateLater(t.ip, tid, s.Uid, text)
+		delete(t.seats, s.Uid)
+		users.SetFree(s.Uid)
+	}
+
+	lobbySysText(fmt.Sprintf("争霸赛 %s 结束, 冠军 %s 获得 %d mBTC, 亚军 %s 获得 %d mBTC",
+		t.template.Name, gold.GetNickname(), payouts[goldUid], silver.GetNickname(), payouts[silverUid]))
+	log.Info("the tournament %d ends, gold %v, silver %v, payouts %v", id, gold.GetNickname(), silver.GetNickname(), payouts)
+}
+
+// inform the game server to move the player out of the tournament once the lock is released
+func (td *tournamentDirector) eliminateLater(ip string, tid, uid int, text string) {
+	td.later(func() {
+		if err := clients.GetStub(ip).Eliminate(tid, uid, text); err != nil {
+			log.Debug("can not inform game server to eliminate user %d: %v", uid, err)
+		}
+	})
+}
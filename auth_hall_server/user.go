@@ -14,24 +14,89 @@ func initUsers() {
 	users.Add(us...)
 
 	log.Info("initialize users in the cache...")
-	go energyGiveout()
+	go giveout()
+	go ratingDecay()
+}
+
+// length of a rating period
+const (
+	ratingPeriod        = 7 * 24 * time.Hour
+	scheduleRatingDecay = "rating_decay"
+)
+
+// the rating deviation of users who do not play rated games in a rating period grows
+// the last decay is stored, the period goes on across restarts
+func ratingDecay() {
+	for {
+		lastRun, err := querySchedule(scheduleRatingDecay)
+		if err != nil {
+			log.Error("can not query the last rating decay: %v", err)
+			time.Sleep(time.Minute)
+			continue
+		}
+		tNow := time.Now()
+		// the first period starts now
+		if lastRun == 0 {
+			lastRun = tNow.Unix()
+			if err := updateSchedule(scheduleRatingDecay, lastRun); err != nil {
+				log.Error("can not store the start of the rating period: %v", err)
+			}
+		}
+		if d := time.Unix(lastRun, 0).Add(ratingPeriod).Sub(tNow); d > 0 {
+			time.Sleep(d)
+			continue
+		}
+		since := int(tNow.Add(-ratingPeriod).Unix())
+		if decayed := users.DecayRatings(since); len(decayed) > 0 {
+			updateRatings(decayed...)
+			log.Info("decay the ratings of %d inactive users", len(decayed))
+		}
+		if err := updateSchedule(scheduleRatingDecay, tNow.Unix()); err != nil {
+			log.Error("can not store the last rating decay: %v", err)
+		}
+	}
 }
 
 var nextGiveoutTime time.Time
 
-// give out energy to all users every 00:00:00 on tiemzone utc +8
-func energyGiveout() {
+// the play chips of the day, the users with less are topped up to it
+var dailyChips = 1000
+
+// give out energy and chips to all users every 00:00:00 on tiemzone utc +8
+func giveout() {
 	setNextGiveoutTime()
 	for {
 		if time.Now().Sub(nextGiveoutTime).Seconds() >= 0 {
 			setNextGiveoutTime()
-			users.EnergyGiveout()
-			insertOrUpdateUser(users.GetAllUsers()...)
+			energyGiveoutAll()
+			chipsGiveoutAll()
 		}
 		time.Sleep(time.Minute)
 	}
 }
 
+// the users without energy get the default energy from the house
+func energyGiveoutAll() {
+	for _, u := range users.GetAllUsers() {
+		if energy := u.GetEnergy(); energy <= 0 {
+			if err := transact(journalEnergyGiveout, u.Uid, types.Transfer(types.AccountHouse, energyOf(u.Uid), types.AssetEnergy, defaultEnergy-energy)...); err != nil {
+				log.Error("can not give out the energy to %v: %v", u.Nickname, err)
+			}
+		}
+	}
+}
+
+// the users with less chips than the daily chips are topped up by the house
+func chipsGiveoutAll() {
+	for _, u := range users.GetAllUsers() {
+		if chips := u.GetChips(); chips < dailyChips {
+			if err := transact(journalChipsGiveout, u.Uid, types.Transfer(types.AccountHouse, availableOf(u.Uid), types.AssetChips, dailyChips-chips)...); err != nil {
+				log.Error("can not give out the chips to %v: %v", u.Nickname, err)
+			}
+		}
+	}
+}
+
 func setNextGiveoutTime() {
 	tN := time.Now()
 	nextGiveoutTime = time.Date(tN.Year(), tN.Month(), tN.Day()+1, 0, 0, 0, 0, time.Local)
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogames/go_tetris/types"
+	"github.com/gogames/go_tetris/utils"
+	"github.com/gogames/go_tetris/wallet"
+)
+
+// the withdrawals are requests, the amount is frozen on request
+// the large ones wait for the review of an admin, the others are sent in batches by the worker
+// the batch is sent with its idempotency key, a batch interrupted by restart is sent again with the same key
+// the batch of which the send is unknown is sent again by the worker with the same key
+
+// status of the withdrawal, and of the batch in sending, sent and failed
+const (
+	withdrawPending = iota // waiting for the batch
+	withdrawReview         // waiting for the review of an admin
+	withdrawSending        // in a batch being sent
+	withdrawSent
+	withdrawFailed   // the send is rejected, the frozen is released
+	withdrawRejected // rejected by an admin, the frozen is released
+)
+
+const (
+	withdrawBatchInterval = time.Minute
+	withdrawDay           = 24 * time.Hour
+	withdrawKeyLength     = 16
+
+	errExceedUserDailyWithdraw = "超过每日提现额度 %dmBTC, 今日还可以提现 %dmBTC"
+)
+
+var (
+	// limits in mBTC, 0 means no limit
+	withdrawUserDailyLimit = 1000
+	withdrawDailyLimit     = 10000
+	// the withdrawals of the amount or more need the review of an admin
+	withdrawReviewThreshold = 500
+	withdrawBatchSize       = 50
+
+	// serialize the requests, the reviews and the batches
+	withdrawMu sync.Mutex
+
+	errExceedDailyWithdraw   = fmt.Errorf("今日提现总额已满, 请明天再试")
+	errWithdrawalNotExist    = fmt.Errorf("提现申请不存在")
+	errWithdrawalNotInReview = fmt.Errorf("该提现申请不在审核中")
+)
+
+type withdrawal struct {
+	Id, Uid, Amount, Status, Batch int
+	Nickname, Address, Key, TxId   string
+	Created, Updated               int64
+}
+
+// for hprose
+func (w *withdrawal) Wrap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":       w.Id,
+		"uid":      w.Uid,
+		"nickname": w.Nickname,
+		"address":  w.Address,
+		"amount":   w.Amount,
+		"key":      w.Key,
+		"status":   withdrawStatusName(w.Status),
+		"txid":     w.TxId,
+		"created":  w.Created,
+		"updated":  w.Updated,
+	}
+}
+
+func withdrawStatusName(status int) string {
+	switch status {
+	case withdrawPending:
+		return "pending"
+	case withdrawReview:
+		return "review"
+	case withdrawSending:
+		return "sending"
+	case withdrawSent:
+		return "sent"
+	case withdrawFailed:
+		return "failed"
+	case withdrawRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+func wrapWithdrawals(ws []*withdrawal) []map[string]interface{} {
+	res := make([]map[string]interface{}, len(ws))
+	for i, w := range ws {
+		res[i] = w.Wrap()
+	}
+	return res
+}
+
+// the idempotency key of the send of the batch
+func withdrawBatchKey(batch int) string { return fmt.Sprintf("withdraw-batch-%d", batch) }
+
+// send the batches interrupted by restart, then start the worker
+// the worker sends them again if the wallet is not reachable
+func initWithdrawals() {
+	if err := resendWithdrawalBatches(); err != nil {
+		log.Error("can not send the withdrawal batches interrupted by restart: %v", err)
+	}
+	go sendWithdrawals()
+}
+
+func sendWithdrawals() {
+	for {
+		time.Sleep(withdrawBatchInterval)
+		if err := resendWithdrawalBatches(); err != nil {
+			log.Error("can not send the withdrawal batches in sending again: %v", err)
+			continue
+		}
+		if err := sendNextWithdrawalBatch(); err != nil {
+			log.Error("can not send the withdrawal batch: %v", err)
+		}
+	}
+}
+
+// send the batches in sending again with the same key, the send of them is unknown
+func resendWithdrawalBatches() error {
+	batches, err := queryWithdrawalBatches(withdrawSending)
+	if err != nil {
+		return err
+	}
+	for _, batch := range batches {
+		ws, err := queryWithdrawalsOfBatch(batch)
+		if err != nil {
+			return fmt.Errorf("can not query the withdrawals of batch %d: %v", batch, err)
+		}
+		log.Info("send the withdrawal batch %d in sending again", batch)
+		if err := sendWithdrawalBatch(batch, ws); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// request the withdrawal, the request of the same key returns the first
+func requestWithdrawal(u *types.User, amount int, address, key string) (*withdrawal, error) {
+	// the invalid address would fail the whole batch
+	if isValid, err := btcWallet.ValidateAddress(address); err != nil {
+		return nil, err
+	} else if !isValid {
+		return nil, errInvalidBtcAddr
+	}
+	withdrawMu.Lock()
+	defer withdrawMu.Unlock()
+	uid := u.GetUid()
+	if key == "" {
+		key = utils.RandString(withdrawKeyLength)
+	} else if w, err := queryWithdrawalByKey(uid, key); err != nil || w != nil {
+		return w, err
+	}
+	// the limits of the day
+	since := time.Now().Add(-withdrawDay).Unix()
+	userSum, totalSum, err := queryWithdrawnSince(uid, since)
+	if err != nil {
+		return nil, err
+	}
+	if withdrawUserDailyLimit > 0 && userSum+amount > withdrawUserDailyLimit {
+		left := withdrawUserDailyLimit - userSum
+		if left < 0 {
+			left = 0
+		}
+		return nil, fmt.Errorf(errExceedUserDailyWithdraw, withdrawUserDailyLimit, left)
+	}
+	if withdrawDailyLimit > 0 && totalSum+amount > withdrawDailyLimit {
+		return nil, errExceedDailyWithdraw
+	}
+	if u.GetBalance() < amount {
+		return nil, errBalNotSufficient
+	}
+	w := &withdrawal{
+		Uid:      uid,
+		Nickname: u.Nickname,
+		Address:  address,
+		Amount:   amount,
+		Key:      key,
+		Status:   withdrawPending,
+		Created:  time.Now().Unix(),
+	}
+	if withdrawReviewThreshold > 0 && amount >= withdrawReviewThreshold {
+		w.Status = withdrawReview
+	}
+	w.Updated = w.Created
+	ps := types.Transfer(availableOf(uid), frozenOf(uid), types.AssetMBTC, amount)
+	e, err := postEntry(journalWithdraw, key, ps...)
+	if err == types.ErrOverdraft {
+		return nil, errBalNotSufficient
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := insertWithdrawal(w, e); err != nil {
+		// undo the posting
+		if _, uerr := postEntry(journalWithdrawFailed, key, types.Transfer(frozenOf(uid), availableOf(uid), types.AssetMBTC, amount)...); uerr != nil {
+			log.Critical("can not undo the freeze of the withdrawal %s of %v: %v", key, u.Nickname, uerr)
+		}
+		return nil, err
+	}
+	log.Info("%v requests to withdraw %d mBTC to %s, status %s", u.Nickname, amount, address, withdrawStatusName(w.Status))
+	return w, nil
+}
+
+// approve the withdrawal to the batch, or reject it and release the frozen
+func reviewWithdrawal(id int, approve bool) error {
+	withdrawMu.Lock()
+	defer withdrawMu.Unlock()
+	w, err := queryWithdrawal(id)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		return errWithdrawalNotExist
+	}
+	if w.Status != withdrawReview {
+		return errWithdrawalNotInReview
+	}
+	if approve {
+		return updateWithdrawalStatus(id, withdrawPending)
+	}
+	// the entry is posted first, undone if the withdrawal can not be stored
+	ps := types.Transfer(frozenOf(w.Uid), availableOf(w.Uid), types.AssetMBTC, w.Amount)
+	e, err := postEntry(journalWithdrawFailed, id, ps...)
+	if err != nil {
+		return err
+	}
+	if err := releaseWithdrawals(withdrawRejected, 0, []*withdrawal{w}, e); err != nil {
+		undoEntry(journalWithdrawFailed, id, ps...)
+		return err
+	}
+	return nil
+}
+
+// put the pending withdrawals to a new batch and send it
+func sendNextWithdrawalBatch() error {
+	withdrawMu.Lock()
+	ws, err := queryWithdrawalsByStatus(withdrawPending, 0, withdrawBatchSize)
+	if err != nil || len(ws) == 0 {
+		withdrawMu.Unlock()
+		return err
+	}
+	batch, err := insertWithdrawalBatch(ws)
+	withdrawMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return sendWithdrawalBatch(batch, ws)
+}
+
+// send the batch, the sent moves the frozen to the wallet, the rejected releases it
+// the batch is left in sending if the send is unknown, return the error
+func sendWithdrawalBatch(batch int, ws []*withdrawal) error {
+	outputs := make(map[string]int)
+	for _, w := range ws {
+		outputs[w.Address] += w.Amount
+	}
+	txid, err := btcWallet.SendMany(withdrawBatchKey(batch), outputs)
+	if err != nil && !wallet.IsRejected(err) {
+		return fmt.Errorf("the send of the withdrawal batch %d is unknown, send it again later: %v", batch, err)
+	}
+	kind, to := journalWithdraw, types.AccountWallet
+	if err != nil {
+		log.Error("the withdrawal batch %d is rejected, release the frozen: %v", batch, err)
+		kind = journalWithdrawFailed
+	}
+	ps := make([]types.Posting, 0, 2*len(ws))
+	for _, w := range ws {
+		if err != nil {
+			to = availableOf(w.Uid)
+		}
+		ps = append(ps, types.Transfer(frozenOf(w.Uid), to, types.AssetMBTC, w.Amount)...)
+	}
+	// the entry is posted first, undone if the withdrawals can not be stored
+	// the batch is left in sending, the same key gets the same send again
+	e, eerr := postEntry(kind, batch, ps...)
+	if eerr != nil {
+		return fmt.Errorf("can not post the withdrawal batch %d, txid %s: %v", batch, txid, eerr)
+	}
+	if err != nil {
+		eerr = releaseWithdrawals(withdrawFailed, batch, ws, e)
+	} else {
+		eerr = completeWithdrawals(batch, txid, ws, e)
+	}
+	if eerr != nil {
+		undoEntry(kind, batch, ps...)
+		return fmt.Errorf("can not store the withdrawal batch %d, txid %s, finish it later: %v", batch, txid, eerr)
+	}
+	if err == nil {
+		log.Info("send the withdrawal batch %d of %d withdrawals, txid %s", batch, len(ws), txid)
+	}
+	return nil
+}
+
+// the amounts frozen by the withdrawals not finished, by uid
+func outstandingWithdrawals() map[int]int {
+	res, err := queryOutstandingWithdrawals()
+	if err != nil {
+		panic("can not query the outstanding withdrawals: " + err.Error())
+	}
+	return res
+}
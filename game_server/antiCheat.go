@@ -0,0 +1,212 @@
+/*
+	server side validation of the operations
+	rate limit, sequence numbers, and detection of inhuman input
+*/
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxOpsPerSecond = 20
+	defaultMaxApm          = 400
+	// number of intervals between the distinct key presses used to check the regularity of the input
+	// the repeated moves of a held key and the soft drops are not counted, the auto-repeat is regular
+	regularityWindow = 40
+	// coefficient of variation of the intervals, human beings can not be that regular
+	minIntervalVariation = 0.05
+	// report the player after these number of dropped operations
+	reportAfterViolations = 10
+)
+
+// violations
+const (
+	violationRate     = "rate"
+	violationSeq      = "sequence"
+	violationApm      = "apm"
+	violationRegular  = "regularity"
+	auditOpViolation  = "table %d, user %s(%d), violation %s: %s"
+	auditReportedUser = "table %d, user %s(%d) is reported to auth server: %s"
+)
+
+var (
+	errTooManyOps   = fmt.Errorf("操作过于频繁, 该操作已被忽略")
+	errInvalidOpSeq = fmt.Errorf("操作序号无效, 该操作已被忽略")
+)
+
+// input guard of a connection
+type inputGuard struct {
+	uid, tid   int
+	nickname   string
+	lastSeq    int
+	tokens     float64
+	lastRefill time.Time
+	ops        []time.Time // operations in the last minute
+	lastOp     string
+	presses    []time.Time // the last distinct key presses
+	violations int
+	reported   bool
+	mu         sync.Mutex
+}
+
+func newInputGuard(uid, tid int, nickname string) *inputGuard {
+	return &inputGuard{
+		uid:        uid,
+		tid:        tid,
+		nickname:   nickname,
+		tokens:     float64(maxOpsPerSecond),
+		lastRefill: time.Now(),
+		ops:        make([]time.Time, 0),
+	}
+}
+
+// a new game, the statistics start over
+// the sequence number keeps increasing in the whole connection
+func (ig *inputGuard) newGame(tid int) {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	ig.tid = tid
+	ig.ops = ig.ops[:0]
+	ig.lastOp = ""
+	ig.presses = ig.presses[:0]
+	ig.violations = 0
+	ig.reported = false
+}
+
+// check the operation
+// return an error if the operation should be ignored
+func (ig *inputGuard) check(seq int, op string) error {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	tNow := time.Now()
+
+	// sequence number should be strictly increasing
+	// the older clients send no sequence number, it is 0
+	if seq != 0 && seq <= ig.lastSeq {
+		ig.violate(violationSeq, fmt.Sprintf("seq %d after %d", seq, ig.lastSeq))
+		return errInvalidOpSeq
+	}
+
+	// token bucket
+	ig.tokens += tNow.Sub(ig.lastRefill).Seconds() * float64(maxOpsPerSecond)
+	if ig.tokens > float64(maxOpsPerSecond) {
+		ig.tokens = float64(maxOpsPerSecond)
+	}
+	ig.lastRefill = tNow
+	if ig.tokens < 1 {
+		ig.violate(violationRate, fmt.Sprintf("more than %d operations per second", maxOpsPerSecond))
+		return errTooManyOps
+	}
+	ig.tokens--
+	if seq != 0 {
+		ig.lastSeq = seq
+	}
+
+	// operations in the last minute
+	ig.ops = append(ig.ops, tNow)
+	for len(ig.ops) > 0 && tNow.Sub(ig.ops[0]) > time.Minute {
+		ig.ops = ig.ops[1:]
+	}
+	if len(ig.ops) > maxApm {
+		ig.suspect(violationApm, fmt.Sprintf("%d operations in the last minute, ceiling is %d", len(ig.ops), maxApm))
+	}
+	if op == ig.lastOp || op == opDown {
+		return nil
+	}
+	ig.lastOp = op
+	ig.presses = append(ig.presses, tNow)
+	if len(ig.presses) > regularityWindow+1 {
+		ig.presses = ig.presses[1:]
+	}
+	if cv, ok := ig.intervalVariation(); ok && cv < minIntervalVariation {
+		ig.suspect(violationRegular, fmt.Sprintf("coefficient of variation of the last %d intervals is %.4f", regularityWindow, cv))
+	}
+	return nil
+}
+
+// coefficient of variation of the intervals between the last distinct key presses
+func (ig *inputGuard) intervalVariation() (float64, bool) {
+	n := len(ig.presses)
+	if n <= regularityWindow {
+		return 0, false
+	}
+	intervals := make([]float64, regularityWindow)
+	var mean float64
+	for i := 0; i < regularityWindow; i++ {
+		intervals[i] = ig.presses[n-regularityWindow+i].Sub(ig.presses[n-regularityWindow+i-1]).Seconds()
+		mean += intervals[i]
+	}
+	mean /= regularityWindow
+	if mean <= 0 {
+		return 0, true
+	}
+	var variance float64
+	for _, v := range intervals {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= regularityWindow
+	return math.Sqrt(variance) / mean, true
+}
+
+// the operation is dropped, report the player if it happens too often
+func (ig *inputGuard) violate(kind, detail string) {
+	auditLog.Warn(auditOpViolation, ig.tid, ig.nickname, ig.uid, kind, detail)
+	ig.violations++
+	if ig.violations >= reportAfterViolations {
+		ig.report(fmt.Sprintf("%d operations dropped, last violation %s: %s", ig.violations, kind, detail))
+	}
+}
+
+// the pattern is impossible for a human being, report it directly
+func (ig *inputGuard) suspect(kind, detail string) {
+	auditLog.Warn(auditOpViolation, ig.tid, ig.nickname, ig.uid, kind, detail)
+	ig.report(kind + ": " + detail)
+}
+
+// report the player to the auth server, only once a game
+// the report is sent asynchronously, the result of the game waits for the reports of the table
+func (ig *inputGuard) report(reason string) {
+	if ig.reported {
+		return
+	}
+	ig.reported = true
+	auditLog.Critical(auditReportedUser, ig.tid, ig.nickname, ig.uid, reason)
+	sendReport(ig.tid, ig.uid, reason)
+}
+
+var (
+	reports   = make(map[int]*sync.WaitGroup) // table id -> reports being sent
+	reportsMu sync.Mutex
+)
+
+func sendReport(tid, uid int, reason string) {
+	reportsMu.Lock()
+	wg, ok := reports[tid]
+	if !ok {
+		wg = new(sync.WaitGroup)
+		reports[tid] = wg
+	}
+	wg.Add(1)
+	reportsMu.Unlock()
+	go func() {
+		defer wg.Done()
+		if err := authServerStub.ReportSuspect(tid, uid, reason); err != nil {
+			log.Warn("can not report suspect %d of table %d to auth server: %v", uid, tid, err)
+		}
+	}()
+}
+
+// wait for the reports of the table sent, so they come before the result of the game
+func waitReports(tid int) {
+	reportsMu.Lock()
+	wg := reports[tid]
+	delete(reports, tid)
+	reportsMu.Unlock()
+	if wg != nil {
+		wg.Wait()
+	}
+}
@@ -16,10 +16,14 @@ type authServer struct {
 	ObTournament        func(tid, uid int) error
 	SwitchReady         func(tid, uid int) error
 	Quit                func(tid, uid int, isTournament bool) error
-	SetNormalGameResult func(tid, winner, loser int) error
-	SetTournamentResult func(tid, winner, loser int) error
-	Apply               func(uid int) (int, error)
-	Allocate            func(uid int) (int, error)
+	SetNormalGameResult func(tid, winner, loser int, stats string) error
+	SetTournamentResult func(tid, winner, loser int, stats string) error
+	Apply               func(id, uid int) (int, error)
+	Allocate            func(id, uid int) (int, error)
+	GetBracket          func(tid int) (map[string]interface{}, error)
+	ReportSuspect       func(tid, uid int, reason string) error
+	Rematch             func(tid, uid int, action string) error
+	CheckChat           func(tid, uid int, content string) (string, error)
 }
 
 type authFilter struct{}
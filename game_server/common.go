@@ -8,7 +8,11 @@ import (
 	"github.com/gogames/go_tetris/utils"
 )
 
-var errNilConn = fmt.Errorf("the connection is nil")
+var (
+	errNilConn       = fmt.Errorf("the connection is nil")
+	errConnClosed    = fmt.Errorf("the connection is closed")
+	errSendQueueFull = fmt.Errorf("the send queue is full")
+)
 
 // receive data
 func recv(conn *net.TCPConn) (d requestData, err error) {
@@ -25,22 +29,48 @@ func recv(conn *net.TCPConn) (d requestData, err error) {
 }
 
 // send data
+// the data is queued, the writer goroutine of the connection sends it
 func send(conn *net.TCPConn, desc string, data interface{}) error {
 	if conn == nil {
 		return errNilConn
 	}
-	err := utils.SendDataOverTcp(conn, newResponse(desc, data).toJson())
+	q := getSendQueue(conn)
+	if q == nil {
+		return errConnClosed
+	}
+	err := q.push(frameKind(desc, data), newResponse(desc, data).toJson())
 	if err != nil {
 		log.Debug("can not send response data ->\ndesc: %v, data: %v, error: %v", desc, data, err)
 	}
 	return err
 }
 
+// send data which is already marshaled
+func sendRaw(conn *net.TCPConn, kind string, b []byte) error {
+	if conn == nil {
+		return errNilConn
+	}
+	q := getSendQueue(conn)
+	if q == nil {
+		return errConnClosed
+	}
+	err := q.push(kind, b)
+	if err != nil {
+		log.Debug("can not send raw response data: %v", err)
+	}
+	return err
+}
+
 // close a connection
+// the queued data is sent before closing
 func closeConn(conns ...*net.TCPConn) {
 	for _, conn := range conns {
-		if err := conn.Close(); err != nil {
-			log.Debug("can not close the connection: %v", err)
+		if conn == nil {
+			continue
+		}
+		// the queue is gone, the connection is closed already
+		if q := getSendQueue(conn); q != nil {
+			q.closeAfterFlush()
 		}
 	}
 }
@@ -69,10 +99,12 @@ func (r responseData) toJson() []byte {
 
 // request from client to game server
 type requestData struct {
-	Cmd  string `json:"cmd"`
-	Data string `json:"data"`
+	Cmd   string `json:"cmd"`
+	Data  string `json:"data"`
+	Seq   int    `json:"seq"`   // sequence number of the operation, strictly increasing, 0 if the client sends none
+	Frame int    `json:"frame"` // the gravity tick the client saw when the operation is made
 }
 
 func (d requestData) String() string {
-	return fmt.Sprintf("\nrequest command: %s, data: %s", d.Cmd, d.Data)
+	return fmt.Sprintf("\nrequest command: %s, data: %s, seq: %d, frame: %d", d.Cmd, d.Data, d.Seq, d.Frame)
 }
@@ -14,11 +14,19 @@ var (
 
 	tokenEncryptKey    string
 	logPath            string
+	auditLogPath       string
 	authServerIp       string
 	authServerRpcPort  string
 	gameServerRpcPort  string
 	gameServerSockPort string
 	maxConn            int
+	spectatorDelay     int // in seconds
+	sendQueueSize      int
+	sendQueuePolicy    string
+	sendTimeout        int // in seconds
+	maxOpsPerSecond    int
+	maxApm             int
+	lagCompensation    int // in ms
 	privKey            []byte
 )
 
@@ -44,6 +52,39 @@ func initConf() {
 		os.Exit(1)
 	}
 
+	// delay of the stream to the observers, no delay if not set
+	if spectatorDelay, err = conf.Int("spectatorDelay"); err != nil {
+		spectatorDelay = 0
+	}
+
+	// outbound queue of every connection
+	if sendQueueSize, err = conf.Int("sendQueueSize"); err != nil || sendQueueSize <= 0 {
+		sendQueueSize = defaultSendQueueSize
+	}
+	if sendTimeout, err = conf.Int("sendTimeout"); err != nil || sendTimeout <= 0 {
+		sendTimeout = defaultSendTimeout
+	}
+	switch sendQueuePolicy = conf.String("sendQueuePolicy"); sendQueuePolicy {
+	case policyDrop, policyCoalesce, policyDisconnect:
+	default:
+		sendQueuePolicy = defaultSendQueuePolicy
+	}
+
+	// anti cheat
+	if auditLogPath = conf.String("auditLog"); auditLogPath == "" {
+		auditLogPath = logPath + ".audit"
+	}
+	if maxOpsPerSecond, err = conf.Int("maxOpsPerSecond"); err != nil || maxOpsPerSecond <= 0 {
+		maxOpsPerSecond = defaultMaxOpsPerSecond
+	}
+	if maxApm, err = conf.Int("maxApm"); err != nil || maxApm <= 0 {
+		maxApm = defaultMaxApm
+	}
+	// max lag of the operations to be compensated
+	if lagCompensation, err = conf.Int("lagCompensation"); err != nil || lagCompensation < 0 {
+		lagCompensation = defaultLagCompensation
+	}
+
 	utils.CheckEmptyConf(tokenEncryptKey, logPath, authServerIp,
 		authServerRpcPort, gameServerRpcPort, gameServerSockPort, maxConn)
 
@@ -0,0 +1,104 @@
+/*
+	round trip time of the connections
+	the server pings the client, the client echoes the timestamp back
+*/
+package main
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	pingInterval = 2 * time.Second
+	// weight of the new sample in the estimation
+	rttSmoothing = 0.2
+	// default max lag compensation in ms
+	defaultLagCompensation = 300
+)
+
+type latency struct {
+	rtt     float64 // in ms
+	sampled bool
+	mu      sync.Mutex
+}
+
+// update the estimation by the timestamp echoed by the client
+func (l *latency) pong(data string) {
+	sent, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		return
+	}
+	sample := float64(time.Now().UnixNano()/1e6 - sent)
+	if sample < 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.sampled {
+		l.rtt, l.sampled = sample, true
+		return
+	}
+	l.rtt = (1-rttSmoothing)*l.rtt + rttSmoothing*sample
+}
+
+// round trip time in ms
+func (l *latency) get() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.rtt)
+}
+
+// max number of gravity ticks an operation of the connection could be late
+// bounded by the configured window, a client can not claim more lag than it has
+func (l *latency) window(interval int) int {
+	lag := l.get() / 2
+	if lag > lagCompensation {
+		lag = lagCompensation
+	}
+	return lag/interval + 1
+}
+
+// ping the client until quit
+func (l *latency) ping(conn *net.TCPConn, quit chan bool) {
+	for {
+		select {
+		case <-time.After(pingInterval):
+			send(conn, descPing, strconv.FormatInt(time.Now().UnixNano()/1e6, 10))
+		case <-quit:
+			return
+		}
+	}
+}
+
+// latency of all users, indexed by uid
+var (
+	latencies = make(map[int]*latency)
+	latMu     sync.RWMutex
+)
+
+func newLatency(uid int) *latency {
+	latMu.Lock()
+	defer latMu.Unlock()
+	l := new(latency)
+	latencies[uid] = l
+	return l
+}
+
+func releaseLatency(uid int) {
+	latMu.Lock()
+	defer latMu.Unlock()
+	delete(latencies, uid)
+}
+
+// round trip time of the user in ms, -1 if unknown
+func getLatency(uid int) int {
+	latMu.RLock()
+	defer latMu.RUnlock()
+	if l, ok := latencies[uid]; ok {
+		return l.get()
+	}
+	return -1
+}
@@ -9,11 +9,17 @@ import (
 
 var log = logs.NewLogger(10000)
 
+// audit trail of the operations which violate the rules
+var auditLog = logs.NewLogger(10000)
+
 func initLogger() {
 	if err := log.SetLogger("file", fmt.Sprintf(`{"filename":"%s"}`, logPath)); err != nil {
 		os.Exit(1)
 	}
 	log.EnableFuncCallDepth(true)
 	log.SetLogFuncCallDepth(2)
+	if err := auditLog.SetLogger("file", fmt.Sprintf(`{"filename":"%s"}`, auditLogPath)); err != nil {
+		os.Exit(1)
+	}
 	log.Info("initialize logger...")
 }
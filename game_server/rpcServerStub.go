@@ -5,6 +5,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/gogames/go_tetris/tetris"
 	"github.com/gogames/go_tetris/timer"
@@ -18,23 +19,40 @@ func (stub) Deactivate() {
 	go deactivateServer(false)
 }
 
+// statistics of the send queues, indexed by the remote address of the connection
+func (stub) QueueStats() map[string]map[string]interface{} {
+	res := make(map[string]map[string]interface{})
+	for addr, st := range sendQueueStats() {
+		res[addr] = st.Wrap()
+	}
+	return res
+}
+
 // count down after a game start
 func countDown(table *types.Table) {
+	sp := getSpectator(table.TId)
 	t := timer.NewTimer(1000)
 	for i := 3; i > 0; i-- {
-		sendAll(descStart, i, table.GetAllConns()...)
+		sendAll(descStart, i, playerConns(table)...)
+		sp.broadcast(descStart, i)
 		t.Wait()
 	}
 	t.Stop()
-	sendAll(descStart, 0, table.GetAllConns()...)
+	sendAll(descStart, 0, playerConns(table)...)
+	sp.broadcast(descStart, 0)
 }
 
 // auth server inform game server to start a table
 func (stub) Start(tid int) {
 	go func() {
 		table := tables.GetTableById(tid)
+		getSpectator(tid).newGame()
 		countDown(table)
 		table.StartGame()
+		table.Start()
+		for _, g := range []*tetris.Game{table.GetGame1p(), table.GetGame2p()} {
+			g.SetCompensation(lagCompensation/g.GetInterval() + 1)
+		}
 		go table.UpdateTimer()
 		serveGame(tid)
 	}()
@@ -56,99 +74,199 @@ func (stub) Delete(tid int) error {
 	}
 	sendAll(descError, "桌子长时间不开始游戏, 或者由于其他原因, 桌子已经被取消.", t.GetAllConns()...)
 	closeConn(t.GetAllConns()...)
-	tables.DelTable(tid)
+	deleteTable(tid)
 	return nil
 }
 
 // auth server inform game server the game result
-func (stub) SetNormalGameResult(tid, winnerUid, bet int) {
+// the bet is settled only when the series is over, otherwise the next game starts
+func (stub) SetNormalGameResult(tid, winnerUid, bet, winnerScore, loserScore int, seriesOver bool) {
 	construct := func(win bool, bet int) (str string) {
 		if win {
-			str = "你很厉害哦!!"
+			str = fmt.Sprintf("你赢得本局游戏, 比分 %d:%d.", winnerScore, loserScore)
+			if !seriesOver {
+				return str
+			}
+			str += " 你赢得了系列赛, 你很厉害哦!!"
 			if bet > 0 {
-				str += fmt.Sprintf(" 本局游戏你赢得了 %d mBTC", bet)
+				str += fmt.Sprintf(" 你赢得了 %d mBTC", bet)
 			}
 			return str
 		}
+		str = fmt.Sprintf("你输掉本局游戏, 比分 %d:%d.", loserScore, winnerScore)
+		if !seriesOver {
+			return str
+		}
+		str += " 你输掉了系列赛!"
 		if bet > 0 {
-			str = fmt.Sprintf("本局游戏你输掉了 %d mBTC! ", bet)
+			str += fmt.Sprintf(" 你输掉了 %d mBTC! ", bet)
 		}
 		str += "再接再厉!"
 		return str
 	}
+	result := func(player string) string {
+		if seriesOver {
+			return fmt.Sprintf("%s 以 %d:%d 赢得系列赛", player, winnerScore, loserScore)
+		}
+		return fmt.Sprintf("%s 赢得本局游戏, 比分 %d:%d", player, winnerScore, loserScore)
+	}
 	table := tables.GetTableById(tid)
 	switch winnerUid {
 	case table.Get1pUid():
 		send(table.Get1pConn(), descGameWin, construct(true, bet))
 		send(table.Get2pConn(), descGameLose, construct(false, bet))
-		sendAll(descGameResult, "1P 赢得本局游戏", table.GetObConns()...)
+		getSpectator(tid).broadcast(descGameResult, result("1P"))
 	case table.Get2pUid():
 		send(table.Get2pConn(), descGameWin, construct(true, bet))
 		send(table.Get1pConn(), descGameLose, construct(false, bet))
-		sendAll(descGameResult, "2P 赢得本局游戏", table.GetObConns()...)
+		getSpectator(tid).broadcast(descGameResult, result("2P"))
 	default:
 		log.Debug("the winner uid is neither 1p nor 2p, who is it: %v", winnerUid)
 	}
 	table.ResetTable()
 	refreshTable(tid, false)
+	if !seriesOver {
+		stub{}.Start(tid)
+	}
 }
 
-// TODO: not confirmed yet
-func (stub) SetTournamentResult(tid, winnerUid int, isFinalRound bool) {
-	construct := func(win, isFinalRound bool) (str string) {
-		if win {
-			if isFinalRound {
-				str = "恭喜你获得冠军!"
-			} else {
-				str = "恭喜你获得进入下一轮游戏的资格!"
-			}
-		} else if isFinalRound {
-			str = "恭喜你获得亚军!"
-		} else {
-			str = "不要气馁, 再接再厉!"
-		}
+// auth server inform game server the result is held for review
+// someone in the table is suspected of cheating
+func (stub) HoldGameResult(tid, winnerUid int) {
+	const text = "本局游戏有异常操作, 结果正在审核中, 审核完成后结算."
+	table := tables.GetTableById(tid)
+	switch winnerUid {
+	case table.Get1pUid():
+		send(table.Get1pConn(), descGameWin, text)
+		send(table.Get2pConn(), descGameLose, text)
+	case table.Get2pUid():
+		send(table.Get2pConn(), descGameWin, text)
+		send(table.Get1pConn(), descGameLose, text)
+	default:
+		log.Debug("the winner uid is neither 1p nor 2p, who is it: %v", winnerUid)
+	}
+	getSpectator(tid).broadcast(descGameResult, text)
+	table.ResetTable()
+	refreshTable(tid, false)
+}
+
+// notify the player of the achievements unlocked
+func (stub) UnlockAchievements(tid, uid int, achievements []map[string]interface{}) {
+	table := tables.GetTableById(tid)
+	if table == nil {
 		return
 	}
+	switch uid {
+	case table.Get1pUid():
+		send(table.Get1pConn(), descAchievement, achievements)
+	case table.Get2pUid():
+		send(table.Get2pConn(), descAchievement, achievements)
+	}
+}
+
+// the result of the tournament game
+// the players wait at the table, the auth server moves them when the round is over
+func setTournamentResult(tid, winnerUid int) {
 	table := tables.GetTableById(tid)
 	switch winnerUid {
 	case table.Get1pUid():
-		send(table.Get1pConn(), descGameWin, construct(true, isFinalRound))
-		send(table.Get2pConn(), descGameLose, construct(false, isFinalRound))
-		closeConn(table.Get2pConn())
-		sendAll(descGameResult, "1P 赢得本局游戏", table.GetObConns()...)
+		send(table.Get1pConn(), descGameWin, "恭喜你赢得本局比赛, 请等待本轮其他比赛结束")
+		send(table.Get2pConn(), descGameLose, "本局比赛失利, 请等待本轮其他比赛结束")
+		getSpectator(tid).broadcast(descGameResult, "1P 赢得本局游戏")
 	case table.Get2pUid():
-		send(table.Get2pConn(), descGameWin, construct(true, isFinalRound))
-		send(table.Get1pConn(), descGameLose, construct(false, isFinalRound))
-		closeConn(table.Get1pConn())
-		sendAll(descGameResult, "2P 赢得本局游戏", table.GetObConns()...)
+		send(table.Get2pConn(), descGameWin, "恭喜你赢得本局比赛, 请等待本轮其他比赛结束")
+		send(table.Get1pConn(), descGameLose, "本局比赛失利, 请等待本轮其他比赛结束")
+		getSpectator(tid).broadcast(descGameResult, "2P 赢得本局游戏")
 	default:
 	}
 	table.ResetTable()
-	table.QuitAllObs()
+	refreshTable(tid, true)
+}
+
+// auth server moves the player to the table of the next match
+func (stub) Advance(tid, uid, nid int) {
+	table := tables.GetTableById(tid)
+	if table == nil {
+		log.Debug("can not advance user %d, the table %d is not exist", uid, tid)
+		return
+	}
+	advancePlayer(table, uid, nid)
+	if isTableEmpty(table) {
+		closeTournamentTable(table)
+	}
+}
+
+// auth server informs the player is out of the tournament, or the tournament is over
+func (stub) Eliminate(tid, uid int, text string) {
+	table := tables.GetTableById(tid)
+	if table == nil {
+		log.Debug("can not eliminate user %d, the table %d is not exist", uid, tid)
+		return
+	}
+	u := table.GetPlayer(uid)
+	if u == nil {
+		return
+	}
+	send(u.GetConn(), descSysMsg, text)
+	releaseSeat(uid)
+	table.Quit(uid)
+	closeConn(u.GetConn())
+	if isTableEmpty(table) {
+		closeTournamentTable(table)
+	} else {
+		refreshTable(tid, true)
+	}
+}
+
+// auth server sends the system message to the table
+func (stub) Notify(tid int, text string) {
+	if table := tables.GetTableById(tid); table != nil {
+		sendAll(descSysMsg, text, table.GetAllConns()...)
+	}
+}
+
+// auth server pushes the live bracket to the table
+func (stub) Bracket(tid int, bracket map[string]interface{}) {
+	if table := tables.GetTableById(tid); table != nil {
+		sendAll(descBracket, bracket, table.GetAllConns()...)
+	}
 }
 
 // game server serve the game
 func serveGame(tid int) {
 	table := tables.GetTableById(tid)
+	sp := getSpectator(tid)
+	latencyTicker := time.NewTicker(pingInterval)
+	defer latencyTicker.Stop()
 	for {
 		select {
 
+		// latency of the players
+		case <-latencyTicker.C:
+			lat := map[string]int{
+				desc1p: getLatency(table.Get1pUid()),
+				desc2p: getLatency(table.Get2pUid()),
+			}
+			sendAll(descLatency, lat, playerConns(table)...)
+			sp.broadcast(descLatency, lat)
+
 		// table timer
 		case remain := <-table.RemainedSecondsChan:
-			sendAll(descTimer, remain, table.GetAllConns()...)
+			sendAll(descTimer, remain, playerConns(table)...)
+			sp.broadcast(descTimer, remain)
 
 		// game over
 		case gameover := <-table.GameoverChan:
 			switch gameover {
 			case types.GameoverNormal:
 				// normal game over
-				gameOver(tid)
+				gameOver(tid, gameover)
 			case types.Gameover1pQuit:
 				// 1p quit, game over, 2p winner
-				gameOver(tid, false)
+				gameOver(tid, gameover, false)
 			case types.Gameover2pQuit:
 				// 2p quit, game over, 1p winner
-				gameOver(tid, true)
+				gameOver(tid, gameover, true)
 			}
 			return
 
@@ -161,17 +279,18 @@ func serveGame(tid int) {
 			// clear, combo, attack only sends to the player and obs
 			case tetris.DescClear, tetris.DescCombo, tetris.DescAttack:
 				sendAll(desc1p, msg, table.Get1pConn())
-				sendAll(desc1p, msg, table.GetObConns()...)
+				sp.broadcastGame(desc1p, msg, msg.Description)
 			// the others send to all
 			default:
-				sendAll(desc1p, msg, table.GetAllConns()...)
+				sendAll(desc1p, msg, playerConns(table)...)
+				sp.broadcastGame(desc1p, msg, msg.Description)
 			}
 
 		case beingKo := <-table.GetGame1p().BeingKOChan:
 			if beingKo {
 				table.GetGame2p().KoOpponent()
 				sendAll(desc1p, tetris.NewMessage(tetris.DescBeingKo, table.GetGame2p().GetKo()), table.Get1pConn())
-				sendAll(desc1p, tetris.NewMessage(tetris.DescBeingKo, table.GetGame2p().GetKo()), table.GetObConns()...)
+				sp.broadcastGame(desc1p, tetris.NewMessage(tetris.DescBeingKo, table.GetGame2p().GetKo()), tetris.DescBeingKo)
 				if table.GetGame2p().GetKo() >= 5 {
 					table.GetGame1p().GameoverChan <- true
 				}
@@ -184,7 +303,7 @@ func serveGame(tid int) {
 		// 1p game over, 2p win
 		case gameover := <-table.GetGame1p().GameoverChan:
 			if gameover {
-				gameOver(tid, false)
+				gameOver(tid, types.GameoverNormal, false)
 				return
 			}
 
@@ -196,9 +315,10 @@ func serveGame(tid int) {
 				sendAll(desc2p, msg, table.Get2pConn())
 			case tetris.DescClear, tetris.DescCombo, tetris.DescAttack:
 				sendAll(desc2p, msg, table.Get2pConn())
-				sendAll(desc2p, msg, table.GetObConns()...)
+				sp.broadcastGame(desc2p, msg, msg.Description)
 			default:
-				sendAll(desc2p, msg, table.GetAllConns()...)
+				sendAll(desc2p, msg, playerConns(table)...)
+				sp.broadcastGame(desc2p, msg, msg.Description)
 			}
 
 		case attack := <-table.GetGame2p().AttackChan:
@@ -207,7 +327,7 @@ func serveGame(tid int) {
 		// 2p game over, 1p win
 		case gameover := <-table.GetGame2p().GameoverChan:
 			if gameover {
-				gameOver(tid, true)
+				gameOver(tid, types.GameoverNormal, true)
 				return
 			}
 
@@ -215,7 +335,7 @@ func serveGame(tid int) {
 			if beingKo {
 				table.GetGame1p().KoOpponent()
 				sendAll(desc2p, tetris.NewMessage(tetris.DescBeingKo, table.GetGame1p().GetKo()), table.Get2pConn())
-				sendAll(desc2p, tetris.NewMessage(tetris.DescBeingKo, table.GetGame1p().GetKo()), table.GetObConns()...)
+				sp.broadcastGame(desc2p, tetris.NewMessage(tetris.DescBeingKo, table.GetGame1p().GetKo()), tetris.DescBeingKo)
 				if table.GetGame1p().GetKo() >= 5 {
 					table.GetGame2p().GameoverChan <- true
 				}
@@ -226,8 +346,9 @@ func serveGame(tid int) {
 
 // stop the game
 // inform the auth server that the game is over
-func gameOver(tid int, is1pWin ...bool) {
+func gameOver(tid, reason int, is1pWin ...bool) {
 	table := tables.GetTableById(tid)
+	elapsed := table.GetElapsed()
 	table.StopGame()
 	var is1pWinner = false
 	var winner, loser int
@@ -250,17 +371,41 @@ func gameOver(tid int, is1pWin ...bool) {
 	}
 
 	// inform the auth server
+	gw, gl := table.GetGame1p(), table.GetGame2p()
 	if is1pWinner {
 		winner, loser = table.Get1pUid(), table.Get2pUid()
 	} else {
 		winner, loser = table.Get2pUid(), table.Get1pUid()
+		gw, gl = gl, gw
 	}
+	stats := types.GameStats{
+		WinnerKo:    gw.GetKo(),
+		LoserKo:     gl.GetKo(),
+		WinnerLines: gw.GetScore(),
+		LoserLines:  gl.GetScore(),
+		Duration:    elapsed,
+		EndReason:   reason,
+		WinnerEvents: types.MatchEvents{
+			MaxCombo:      gw.GetMaxCombo(),
+			PerfectClears: gw.GetPerfectClears(),
+			BombHits:      gw.GetBombHits(),
+		},
+		LoserEvents: types.MatchEvents{
+			MaxCombo:      gl.GetMaxCombo(),
+			PerfectClears: gl.GetPerfectClears(),
+			BombHits:      gl.GetBombHits(),
+		},
+	}.String()
 
+	// the reports of the players come before the result
+	waitReports(tid)
 	// 1e5 magic number
 	if tid >= 1e5 {
-		err = authServerStub.SetTournamentResult(tid, winner, loser)
+		if err = authServerStub.SetTournamentResult(tid, winner, loser, stats); err == nil {
+			setTournamentResult(tid, winner)
+		}
 	} else {
-		err = authServerStub.SetNormalGameResult(tid, winner, loser)
+		err = authServerStub.SetNormalGameResult(tid, winner, loser, stats)
 	}
 	if err != nil {
 		log.Warn("can not set game result for table %d: %v", tid, err)
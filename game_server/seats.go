@@ -0,0 +1,73 @@
+/*
+	seats of the tournament players
+	the player is moved to the table of the next match, the connection follows the seat
+*/
+package main
+
+import (
+	"sync"
+
+	"github.com/gogames/go_tetris/types"
+)
+
+type seat struct {
+	tid  int
+	is1p bool
+}
+
+var (
+	seats   = make(map[int]seat) // uid -> seat
+	seatsMu sync.RWMutex
+)
+
+func setSeat(uid, tid int, is1p bool) {
+	seatsMu.Lock()
+	defer seatsMu.Unlock()
+	seats[uid] = seat{tid: tid, is1p: is1p}
+}
+
+func getSeat(uid int) (seat, bool) {
+	seatsMu.RLock()
+	defer seatsMu.RUnlock()
+	s, ok := seats[uid]
+	return s, ok
+}
+
+func releaseSeat(uid int) {
+	seatsMu.Lock()
+	defer seatsMu.Unlock()
+	delete(seats, uid)
+}
+
+// move the player to the table of the next match
+func advancePlayer(table *types.Table, uid, nid int) {
+	u := table.GetPlayer(uid)
+	if u == nil {
+		log.Debug("user %d is not a player of table %d, can not advance", uid, table.TId)
+		return
+	}
+	// the opponent may have created the table already
+	if !tables.IsTableExist(nid) {
+		tables.NewTable(nid, "", "", 0)
+	}
+	if err := tables.JoinTable(nid, u, false); err != nil {
+		log.Critical("can not move user %d to table %d: %v", uid, nid, err)
+		return
+	}
+	setSeat(uid, nid, tables.GetTableById(nid).Is1p(uid))
+	table.Quit(uid)
+	refreshTable(nid, true)
+}
+
+// all players left the tournament table
+func isTableEmpty(table *types.Table) bool {
+	return table.Get1pUid() < 0 && table.Get2pUid() < 0
+}
+
+// the tournament table is over, close the connections left and delete it
+func closeTournamentTable(table *types.Table) {
+	closeConn(table.GetAllConns()...)
+	table.ResetTable()
+	table.QuitAllObs()
+	deleteTable(table.TId)
+}
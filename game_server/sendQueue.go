@@ -0,0 +1,299 @@
+/*
+	outbound queue of every connection
+	the game loop only pushes datagrams into the queue,
+	a writer goroutine per connection does the actual writing
+*/
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gogames/go_tetris/tetris"
+	"github.com/gogames/go_tetris/utils"
+)
+
+// what to do if the queue of a connection is full
+const (
+	policyDrop       = "drop"       // drop the intermediate zone frames
+	policyCoalesce   = "coalesce"   // replace the queued frame of the same kind with the new one
+	policyDisconnect = "disconnect" // the connection is too slow, kick it
+)
+
+const (
+	defaultSendQueueSize   = 1 << 8
+	defaultSendTimeout     = 5 // in seconds
+	defaultSendQueuePolicy = policyCoalesce
+)
+
+// the frames could be dropped or coalesced when the queue is full
+// a newer one of the same kind always makes it useless
+var droppableDescs = map[string]bool{
+	descTimer:        true,
+	descLatency:      true,
+	tetris.DescZone:  true,
+	tetris.DescFrame: true,
+}
+
+// kind of the datagram, frames of the same kind could be coalesced
+func frameKind(desc string, data interface{}) string {
+	if m, ok := data.(interface {
+		GetDescription() string
+	}); ok {
+		return desc + "/" + m.GetDescription()
+	}
+	return desc
+}
+
+// check if the frame is droppable by its kind
+func isDroppable(kind string) bool {
+	if droppableDescs[kind] {
+		return true
+	}
+	for _, p := range []string{desc1p, desc2p} {
+		if len(kind) > len(p) && kind[:len(p)+1] == p+"/" && droppableDescs[kind[len(p)+1:]] {
+			return true
+		}
+	}
+	return false
+}
+
+type outFrame struct {
+	kind    string
+	payload []byte
+}
+
+// statistics of a queue
+type queueStat struct {
+	Depth, MaxDepth                      int
+	Sent, Dropped, Coalesced, Overflowed int64
+}
+
+type sendQueue struct {
+	conn    *net.TCPConn
+	frames  []outFrame
+	size    int
+	policy  string
+	timeout time.Duration
+	wake    chan bool
+	done    chan bool // closed when the queue is closing or closed
+	closing bool
+	closed  bool
+	stat    queueStat
+	mu      sync.Mutex
+}
+
+func newSendQueue(conn *net.TCPConn) *sendQueue {
+	q := &sendQueue{
+		conn:    conn,
+		frames:  make([]outFrame, 0, sendQueueSize),
+		size:    sendQueueSize,
+		policy:  sendQueuePolicy,
+		timeout: time.Duration(sendTimeout) * time.Second,
+		wake:    make(chan bool, 1),
+		done:    make(chan bool),
+	}
+	go q.serve()
+	return q
+}
+
+// push a frame into the queue, never blocks
+func (q *sendQueue) push(kind string, payload []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closing || q.closed {
+		return errConnClosed
+	}
+	if len(q.frames) >= q.size {
+		q.stat.Overflowed++
+		if !q.overflow(kind, payload) {
+			log.Info("the send queue of %v is full, disconnect it", q.conn.RemoteAddr())
+			q.closed = true
+			q.conn.Close()
+			q.stop()
+			return errSendQueueFull
+		}
+	} else {
+		q.frames = append(q.frames, outFrame{kind: kind, payload: payload})
+	}
+	if l := len(q.frames); l > q.stat.MaxDepth {
+		q.stat.MaxDepth = l
+	}
+	q.signal()
+	return nil
+}
+
+// handle the overflow according to the policy
+// return false if the connection should be closed
+func (q *sendQueue) overflow(kind string, payload []byte) bool {
+	switch q.policy {
+	case policyCoalesce:
+		if isDroppable(kind) {
+			for i := len(q.frames) - 1; i >= 0; i-- {
+				if q.frames[i].kind == kind {
+					q.frames[i].payload = payload
+					q.stat.Coalesced++
+					return true
+				}
+			}
+		}
+		fallthrough
+	case policyDrop:
+		if isDroppable(kind) {
+			q.stat.Dropped++
+			return true
+		}
+		// make room by dropping the oldest droppable frame
+		for i, f := range q.frames {
+			if isDroppable(f.kind) {
+				q.frames = append(q.frames[:i], q.frames[i+1:]...)
+				q.frames = append(q.frames, outFrame{kind: kind, payload: payload})
+				q.stat.Dropped++
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.wake <- true:
+	default:
+	}
+}
+
+// wake the writer for good, it exits once the queue is flushed
+// the caller holds the lock
+func (q *sendQueue) stop() {
+	select {
+	case <-q.done:
+	default:
+		close(q.done)
+	}
+}
+
+// pop the first frame, return false if there is nothing to send and the queue should stop
+func (q *sendQueue) pop() (f outFrame, ok, stop bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return f, false, true
+	}
+	if len(q.frames) == 0 {
+		return f, false, q.closing
+	}
+	f = q.frames[0]
+	q.frames = q.frames[1:]
+	return f, true, false
+}
+
+// writer goroutine
+func (q *sendQueue) serve() {
+	defer releaseSendQueue(q.conn)
+	for {
+		f, ok, stop := q.pop()
+		if stop {
+			q.close()
+			return
+		}
+		if !ok {
+			select {
+			case <-q.wake:
+			case <-q.done:
+			}
+			continue
+		}
+		q.conn.SetWriteDeadline(time.Now().Add(q.timeout))
+		if err := utils.SendDataOverTcp(q.conn, f.payload); err != nil {
+			log.Debug("can not write to %v, close the connection: %v", q.conn.RemoteAddr(), err)
+			q.mu.Lock()
+			q.closed = true
+			q.stop()
+			q.mu.Unlock()
+			q.close()
+			return
+		}
+		q.mu.Lock()
+		q.stat.Sent++
+		q.mu.Unlock()
+	}
+}
+
+// close the connection after the queue is flushed
+func (q *sendQueue) closeAfterFlush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closing = true
+	q.stop()
+}
+
+func (q *sendQueue) close() {
+	if err := q.conn.Close(); err != nil {
+		log.Debug("can not close the connection: %v", err)
+	}
+}
+
+func (q *sendQueue) getStat() queueStat {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st := q.stat
+	st.Depth = len(q.frames)
+	return st
+}
+
+// queues of all connections
+var (
+	sendQueues = make(map[*net.TCPConn]*sendQueue)
+	sqMu       sync.Mutex
+)
+
+// create the queue of the accepted connection
+func openSendQueue(conn *net.TCPConn) {
+	sqMu.Lock()
+	defer sqMu.Unlock()
+	if _, ok := sendQueues[conn]; !ok {
+		sendQueues[conn] = newSendQueue(conn)
+	}
+}
+
+// get the queue of the connection, nil if the connection is closed
+func getSendQueue(conn *net.TCPConn) *sendQueue {
+	sqMu.Lock()
+	defer sqMu.Unlock()
+	return sendQueues[conn]
+}
+
+func releaseSendQueue(conn *net.TCPConn) {
+	sqMu.Lock()
+	defer sqMu.Unlock()
+	delete(sendQueues, conn)
+}
+
+// for hprose
+func (st queueStat) Wrap() map[string]interface{} {
+	return map[string]interface{}{
+		"depth":      st.Depth,
+		"max_depth":  st.MaxDepth,
+		"sent":       st.Sent,
+		"dropped":    st.Dropped,
+		"coalesced":  st.Coalesced,
+		"overflowed": st.Overflowed,
+	}
+}
+
+// statistics of all queues, indexed by remote address
+func sendQueueStats() map[string]queueStat {
+	sqMu.Lock()
+	qs := make([]*sendQueue, 0, len(sendQueues))
+	for _, q := range sendQueues {
+		qs = append(qs, q)
+	}
+	sqMu.Unlock()
+	res := make(map[string]queueStat)
+	for _, q := range qs {
+		res[q.conn.RemoteAddr().String()] = q.getStat()
+	}
+	return res
+}
@@ -61,6 +61,7 @@ func deactivateServer(positive bool) {
 				}
 				closeConn(t.GetAllConns()...)
 				tables.ReleaseExpireTable(tid)
+				releaseSpectator(tid)
 			}
 		}
 		time.Sleep(5 * time.Second)
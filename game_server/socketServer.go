@@ -36,6 +36,7 @@ func initSocketServer() {
 				log.Warn("do not accept tcp connection: %v", err)
 				continue
 			}
+			openSendQueue(conn)
 			if !isServerActive() {
 				log.Info("the game server is closing, do not accept new connections...")
 				closeConn(conn)
@@ -62,6 +63,8 @@ const (
 	cmdOperate = "operate"
 	cmdReady   = "switchState"
 	cmdQuit    = "quit"
+	cmdPong    = "pong"
+	cmdRematch = "rematch"
 )
 
 // response description
@@ -78,6 +81,12 @@ const (
 	descGameWin                    = "win"
 	descGameLose                   = "lose"
 	descGameResult                 = "result"
+	descSnapshot                   = "snapshot"
+	descPing                       = "ping"
+	descLatency                    = "latency"
+	descRematch                    = "rematch"
+	descAchievement                = "achievement"
+	descBracket                    = "bracket"
 )
 
 func serveTcpConn(conn *net.TCPConn) {
@@ -107,14 +116,14 @@ func serveTcpConn(conn *net.TCPConn) {
 	u.SetConn(conn)
 	switch {
 	case isApply:
-		// apply for tournament
-		tid, err := authServerStub.Apply(uid)
-		if err != nil {
+		// apply for tournament, the tid of the token is the tournament id
+		if tid, err = authServerStub.Apply(tid, uid); err != nil {
 			log.Warn("can not apply for tournament, auth server error: %v", err)
 			send(conn, descError, fmt.Sprintf("报名失败, 错误: %v", err))
 			closeConn(conn)
 			return
 		}
+		isTournament = true
 		if !tables.IsTableExist(tid) {
 			tables.NewTable(tid, "", "", 0)
 		}
@@ -126,6 +135,7 @@ func serveTcpConn(conn *net.TCPConn) {
 			return
 		}
 		refreshTable(tid, true)
+		sendBracket(conn, tid)
 		sendAll(descSysMsg, fmt.Sprintf("参赛者 %s 加入", nickname), tables.GetTableById(tid).GetAllConns()...)
 	case isOb:
 		// inform the auth server that some one is going to observe a game
@@ -135,13 +145,22 @@ func serveTcpConn(conn *net.TCPConn) {
 			closeConn(conn)
 			return
 		}
+		// late comers get the current boards, then the frames after them
+		sp := getSpectator(tid)
+		if err := sp.join(uid, conn); err != nil {
+			log.Debug("can not send snapshot of table %d to observer %s: %v", tid, nickname, err)
+		}
 		if err := tables.JoinTable(tid, u, true); err != nil {
+			sp.leave(uid)
 			log.Critical("can not ob a game, game server error: %v", err)
 			send(conn, descError, fmt.Sprintf("无法观战, 错误: %v", err))
 			closeConn(conn)
 			return
 		}
 		refreshTable(tid, isTournament)
+		if isTournament {
+			sendBracket(conn, tid)
+		}
 		sendAll(descSysMsg, fmt.Sprintf("用户 %s 进入观战", nickname), tables.GetTableById(tid).GetAllConns()...)
 	default:
 		// normal hall
@@ -164,11 +183,32 @@ func serveTcpConn(conn *net.TCPConn) {
 }
 
 func handleConn(conn *net.TCPConn, uid, tid int, nickname string, isOb, is1p, isTournament bool) {
+	guard := newInputGuard(uid, tid, nickname)
+	var lastGame *tetris.Game
+	// measure the round trip time of the players
+	lat := new(latency)
+	if !isOb {
+		lat = newLatency(uid)
+		quitPing := make(chan bool)
+		go lat.ping(conn, quitPing)
+		defer func() {
+			close(quitPing)
+			releaseLatency(uid)
+		}()
+	}
+	if isTournament && !isOb {
+		defer releaseSeat(uid)
+	}
 forLoop:
 	for {
 		table := tables.GetTableById(tid)
 		// receive data from client
 		data, err := recv(conn)
+		// the winner of the tournament is moved to the table of the next round
+		if s, ok := getSeat(uid); ok && isTournament && !isOb && s.tid != tid {
+			tid, is1p = s.tid, s.is1p
+			table = tables.GetTableById(tid)
+		}
 		if err != nil {
 			log.Debug("can not receive request from table %d, user %s: %v", tid, nickname, err)
 			quit(tid, uid, nickname, is1p, isTournament)
@@ -176,9 +216,19 @@ forLoop:
 			refreshTable(tid, isTournament)
 			return
 		}
+		if table == nil {
+			// the table is deleted, the connection is closing
+			continue forLoop
+		}
 		switch data.Cmd {
 		case cmdChat:
-			msg := fmt.Sprintf("%s: %s", nickname, data.Data)
+			// rate limit, mute and word filter are checked by the auth server
+			content, err := authServerStub.CheckChat(tid, uid, data.Data)
+			if err != nil {
+				send(conn, descError, err.Error())
+				continue forLoop
+			}
+			msg := fmt.Sprintf("%s: %s", nickname, content)
 			sendAll(descChatMsg, msg, table.GetObConns()...)
 			if !isOb {
 				send(table.Get1pConn(), descChatMsg, msg)
@@ -200,6 +250,23 @@ forLoop:
 				continue forLoop
 			}
 			refreshTable(tid, isTournament)
+		case cmdRematch:
+			// offer, accept or decline a rematch after the series
+			if isOb {
+				send(conn, descError, "观战者无法发起再来一局")
+				continue forLoop
+			}
+			if err := authServerStub.Rematch(tid, uid, data.Data); err != nil {
+				send(conn, descError, err.Error())
+				continue forLoop
+			}
+			opponent := table.Get2pConn()
+			if !is1p {
+				opponent = table.Get1pConn()
+			}
+			send(opponent, descRematch, map[string]string{"action": data.Data, "nickname": nickname})
+		case cmdPong:
+			lat.pong(data.Data)
 		case cmdQuit:
 			// quit a game
 			quit(tid, uid, nickname, is1p, isTournament)
@@ -216,17 +283,30 @@ forLoop:
 			} else {
 				g = table.GetGame2p()
 			}
+			if g != lastGame {
+				lastGame = g
+				guard.newGame(tid)
+			}
+			if err := guard.check(data.Seq, data.Data); err != nil {
+				send(conn, descError, err.Error())
+				continue forLoop
+			}
+			// late operations are applied at the frame the player saw
+			window := 0
+			if data.Frame > 0 {
+				window = lat.window(g.GetInterval())
+			}
 			switch data.Data {
 			case opDown:
 				g.MoveDown()
 			case opDrop:
 				g.DropDown()
 			case opLeft:
-				g.MoveLeft()
+				g.MoveLeftAt(data.Frame, window)
 			case opRight:
-				g.MoveRight()
+				g.MoveRightAt(data.Frame, window)
 			case opRotate:
-				g.Rotate()
+				g.RotateAt(data.Frame, window)
 			case opHold:
 				g.Hold()
 			default:
@@ -241,7 +321,17 @@ forLoop:
 
 // quit a game
 func quit(tid, uid int, nickname string, is1p, isTournament bool) {
-	table := tables.GetTableById(tid)
+	// the tournament table may be deleted after the result
+	if table := tables.GetTableById(tid); table != nil {
+		quitTable(table, uid, is1p)
+	}
+	if err := authServerStub.Quit(tid, uid, isTournament); err != nil {
+		log.Warn("hprose error, can not quit user %s from table %d: %v", nickname, tid, err)
+	}
+}
+
+func quitTable(table *types.Table, uid int, is1p bool) {
+	leaveSpectator(table.TId, uid)
 	table.Quit(uid)
 	if table.IsStart() {
 		if is1p {
@@ -250,9 +340,6 @@ func quit(tid, uid int, nickname string, is1p, isTournament bool) {
 			table.GameoverChan <- types.Gameover2pQuit
 		}
 	}
-	if err := authServerStub.Quit(tid, uid, isTournament); err != nil {
-		log.Warn("hprose error, can not quit user %s from table %d: %v", nickname, tid, err)
-	}
 }
 
 // send to all
@@ -262,9 +349,24 @@ func sendAll(desc string, val interface{}, conns ...*net.TCPConn) {
 	}
 }
 
+// connections of 1p and 2p
+func playerConns(table *types.Table) []*net.TCPConn {
+	conns := make([]*net.TCPConn, 0, 2)
+	if c := table.Get1pConn(); c != nil {
+		conns = append(conns, c)
+	}
+	if c := table.Get2pConn(); c != nil {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
 // inform the client side to refresh the table information
 func refreshTable(tid int, isTournament bool) {
 	table := tables.GetTableById(tid)
+	if table == nil {
+		return
+	}
 	if isTournament {
 		sendAll(descRefreshTournamentTableInfo, tid, table.GetAllConns()...)
 	} else {
@@ -272,6 +374,16 @@ func refreshTable(tid int, isTournament bool) {
 	}
 }
 
+// send the live bracket of the tournament to the late comer
+func sendBracket(conn *net.TCPConn, tid int) {
+	bracket, err := authServerStub.GetBracket(tid)
+	if err != nil {
+		log.Debug("can not get the bracket of table %d: %v", tid, err)
+		return
+	}
+	send(conn, descBracket, bracket)
+}
+
 // inform the auth server, some one is going to ob a game
 func obGame(tid, uid int, isTournament bool) error {
 	if isTournament {
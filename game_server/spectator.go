@@ -0,0 +1,265 @@
+/*
+	spectator broadcaster
+	every table has one, it delays the stream to the observers
+	and keeps a snapshot of both boards for the late comers
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gogames/go_tetris/tetris"
+)
+
+const (
+	spectatorBuffer = 1 << 12
+	// the dropped frames are logged once in the number
+	dropLogEvery = 100
+)
+
+var errNilSpectator = fmt.Errorf("the table of the spectator is not exist")
+
+// messages describing the board state, the latest one of each is kept in the snapshot
+var snapshotDescs = map[string]bool{
+	tetris.DescZone:        true,
+	tetris.DescNextPiece:   true,
+	tetris.DescHoldedPiece: true,
+	tetris.DescLines:       true,
+	tetris.DescBeingKo:     true,
+}
+
+// a frame waiting to be sent to the observers
+type frame struct {
+	at      time.Time
+	player  string // desc1p, desc2p or empty
+	key     string // description of the tetris message
+	payload []byte
+	state   json.RawMessage
+	reset   bool // a new game, clear the snapshot
+}
+
+// kind of the frame for the send queue
+func (f frame) kind() string {
+	if f.player != "" {
+		return f.player + "/" + f.key
+	}
+	return f.key
+}
+
+// the observers are registered here, the snapshot and the frames are sent under the same lock
+// so an observer gets every frame after its snapshot, and none before
+type spectator struct {
+	tid      int
+	delay    time.Duration
+	frames   chan frame
+	quit     chan bool
+	mu       sync.Mutex
+	snapshot map[string]map[string]json.RawMessage
+	timer    json.RawMessage
+	obs      map[int]*net.TCPConn // uid -> connection
+	dropped  int
+}
+
+func newSpectator(tid int, delay time.Duration) *spectator {
+	sp := &spectator{
+		tid:    tid,
+		delay:  delay,
+		frames: make(chan frame, spectatorBuffer),
+		quit:   make(chan bool),
+		obs:    make(map[int]*net.TCPConn),
+	}
+	sp.reset()
+	go sp.serve()
+	return sp
+}
+
+// push a frame into the delay queue
+// never blocks, the game loop should not wait for observers
+// nothing is pushed if the table is deleted
+func (sp *spectator) push(f frame) {
+	if sp == nil {
+		return
+	}
+	f.at = time.Now()
+	select {
+	case sp.frames <- f:
+	default:
+		sp.mu.Lock()
+		sp.dropped++
+		dropped := sp.dropped
+		sp.mu.Unlock()
+		if dropped%dropLogEvery == 1 {
+			log.Warn("spectator queue of table %d is full, %d frames dropped, the latest %s %s", sp.tid, dropped, f.player, f.key)
+		}
+	}
+}
+
+// broadcast a message of 1p or 2p to the observers
+func (sp *spectator) broadcastGame(player string, msg interface{}, key string) {
+	state, err := json.Marshal(msg)
+	if err != nil {
+		log.Debug("can not marshal message for spectators: %v", err)
+		return
+	}
+	sp.push(frame{player: player, key: key, payload: newResponse(player, state).toJson(), state: state})
+}
+
+// broadcast any other response to the observers
+func (sp *spectator) broadcast(desc string, data interface{}) {
+	state, err := json.Marshal(data)
+	if err != nil {
+		log.Debug("can not marshal data for spectators: %v", err)
+		return
+	}
+	sp.push(frame{key: desc, payload: newResponse(desc, state).toJson(), state: state})
+}
+
+func (sp *spectator) serve() {
+	for {
+		select {
+		case f := <-sp.frames:
+			if d := f.at.Add(sp.delay).Sub(time.Now()); d > 0 {
+				time.Sleep(d)
+			}
+			// the table deleted by any path, nobody is watching
+			if !tables.IsTableExist(sp.tid) {
+				releaseSpectator(sp.tid)
+				return
+			}
+			if f.reset {
+				sp.reset()
+				continue
+			}
+			sp.deliver(f)
+		case <-sp.quit:
+			return
+		}
+	}
+}
+
+// keep the latest state of the boards and send the frame to the observers
+func (sp *spectator) deliver(f frame) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	switch {
+	case f.player == "" && f.key == descTimer:
+		sp.timer = f.state
+	case f.player != "" && snapshotDescs[f.key]:
+		sp.snapshot[f.player][f.key] = f.state
+	}
+	for uid, c := range sp.obs {
+		if err := sendRaw(c, f.kind(), f.payload); err != nil {
+			log.Debug("can not send frame to observer %d of table %d: %v", uid, sp.tid, err)
+		}
+	}
+}
+
+// a new game is going to start
+// the snapshot is cleared after the frames of the last game are sent
+func (sp *spectator) newGame() {
+	sp.push(frame{reset: true})
+}
+
+// clear the snapshot
+func (sp *spectator) reset() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.snapshot = map[string]map[string]json.RawMessage{
+		desc1p: make(map[string]json.RawMessage),
+		desc2p: make(map[string]json.RawMessage),
+	}
+	sp.timer = nil
+}
+
+// send the full snapshot of both boards to a new observer and register it
+// the datagram is limited in size, so replay the states one by one
+func (sp *spectator) join(uid int, conn *net.TCPConn) error {
+	if sp == nil {
+		return errNilSpectator
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.obs[uid] = conn
+	if err := send(conn, descSnapshot, true); err != nil {
+		return err
+	}
+	for player, states := range sp.snapshot {
+		for _, state := range states {
+			if err := sendRaw(conn, player, newResponse(player, state).toJson()); err != nil {
+				return err
+			}
+		}
+	}
+	if sp.timer != nil {
+		if err := sendRaw(conn, descTimer, newResponse(descTimer, sp.timer).toJson()); err != nil {
+			return err
+		}
+	}
+	return send(conn, descSnapshot, false)
+}
+
+// the observer leaves
+func (sp *spectator) leave(uid int) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	delete(sp.obs, uid)
+}
+
+// stop the broadcaster
+func (sp *spectator) stop() {
+	close(sp.quit)
+}
+
+// spectators of all tables
+var (
+	spectators = make(map[int]*spectator)
+	spMu       sync.Mutex
+)
+
+// get the spectator of the table, create it if not exist
+// nil if the table is deleted, the nil spectator drops everything
+func getSpectator(tid int) *spectator {
+	spMu.Lock()
+	defer spMu.Unlock()
+	sp, ok := spectators[tid]
+	if !ok {
+		if !tables.IsTableExist(tid) {
+			return nil
+		}
+		sp = newSpectator(tid, time.Duration(spectatorDelay)*time.Second)
+		spectators[tid] = sp
+	}
+	return sp
+}
+
+// the observer leaves the spectator of the table, if there is one
+func leaveSpectator(tid, uid int) {
+	spMu.Lock()
+	sp := spectators[tid]
+	spMu.Unlock()
+	sp.leave(uid)
+}
+
+// delete the table and release its spectator
+// every path deleting a table of the game server goes here
+func deleteTable(tid int) {
+	tables.DelTable(tid)
+	releaseSpectator(tid)
+}
+
+// release the spectator of the table
+func releaseSpectator(tid int) {
+	spMu.Lock()
+	defer spMu.Unlock()
+	if sp, ok := spectators[tid]; ok {
+		sp.stop()
+		delete(spectators, tid)
+	}
+}
@@ -75,6 +75,14 @@ type Game struct {
 
 	// score
 	numOfLineSent, combo, ko int
+
+	// events of the game, for the achievements
+	maxCombo, perfectClears, bombHits int
+
+	// lag compensation
+	tick         int     // number of gravity ticks
+	history      []block // positions of the active piece at the last ticks
+	compensation int     // max number of ticks an operation could be late
 }
 
 func NewGame(height, width, numOfNextPieces, interval int) (*Game, error) {
@@ -101,6 +109,8 @@ func NewGame(height, width, numOfNextPieces, interval int) (*Game, error) {
 		GameoverChan: make(chan bool),
 		AttackChan:   make(chan int, buffer),
 		BeingKOChan:  make(chan bool, 5),
+		history:      make([]block, 0, defaultCompensation),
+		compensation: defaultCompensation,
 	}
 	go g.init()
 	return g, nil
@@ -109,6 +119,7 @@ func NewGame(height, width, numOfNextPieces, interval int) (*Game, error) {
 func (g *Game) init() {
 	for {
 		g.timer.Wait()
+		g.gravityTick()
 		g.check(true, false)
 	}
 }
@@ -144,6 +155,7 @@ func (g *Game) check(moveDown, dropDown bool) {
 
 	if genNewPiece {
 		g.holded = false
+		g.clearHistory()
 
 		g.mainZone.putBlockOnMainZone(g.activePiece.block)
 		if lineSent := g.calculate(); lineSent > 0 {
@@ -192,6 +204,21 @@ func (g *Game) GetScore() int {
 	return g.numOfLineSent
 }
 
+// get the max combo of the game
+func (g *Game) GetMaxCombo() int {
+	return g.maxCombo
+}
+
+// get number of times the zone is cleared
+func (g *Game) GetPerfectClears() int {
+	return g.perfectClears
+}
+
+// get number of bombs hit
+func (g *Game) GetBombHits() int {
+	return g.bombHits
+}
+
 // move down
 func (g *Game) MoveDown() {
 	g.check(true, false)
@@ -247,6 +274,7 @@ func (g *Game) Hold() {
 			return
 		}
 		g.holded = true
+		g.clearHistory()
 		if g.holdPiece == nil {
 			g.holdPiece, g.activePiece = g.activePiece, g.nextPieces.getOne(newPiece(g.mainZone.width()/2-2))
 			return
@@ -348,6 +376,7 @@ func (g *Game) calculate() (lineSent int) {
 	// num of bombs hit and lines clear
 	hitBombs := g.mainZone.checkHitBombs(g.activePiece.block)
 	if hitBombs > 0 {
+		g.bombHits += hitBombs
 		g.send(DescAudio, audioHitBomb())
 	}
 	l := g.mainZone.clearLines()
@@ -355,6 +384,7 @@ func (g *Game) calculate() (lineSent int) {
 	// clear
 	if g.mainZone.isClear() {
 		lineSent += 10
+		g.perfectClears++
 		g.send(DescClear, true)
 	}
 
@@ -368,6 +398,9 @@ func (g *Game) calculate() (lineSent int) {
 	g.comboAdd()
 	if c := g.comboAttack(); c > 0 {
 		lineSent += c
+		if g.combo > g.maxCombo {
+			g.maxCombo = g.combo
+		}
 		g.send(DescCombo, g.combo)
 		g.send(DescAudio, audioCombo(c))
 	}
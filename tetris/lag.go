@@ -0,0 +1,112 @@
+// lag compensation
+// the operation of a player on a high latency link may arrive after some gravity ticks,
+// it is applied against the position of the piece at the tick the player saw
+package tetris
+
+// default max number of ticks an operation could be late
+const defaultCompensation = 3
+
+// set max number of ticks an operation could be late
+func (g *Game) SetCompensation(ticks int) {
+	g.Lock()
+	defer g.Unlock()
+	if ticks < 0 {
+		ticks = 0
+	}
+	g.compensation = ticks
+	if len(g.history) > ticks {
+		g.history = g.history[len(g.history)-ticks:]
+	}
+}
+
+// get the current frame number
+func (g *Game) GetFrame() int {
+	g.Lock()
+	defer g.Unlock()
+	return g.tick
+}
+
+// get the interval of gravity ticks in ms
+func (g *Game) GetInterval() int {
+	return g.timer.Interval()
+}
+
+// record the position of the active piece before it falls
+func (g *Game) gravityTick() {
+	g.Lock()
+	defer g.Unlock()
+	if g.compensation > 0 {
+		if len(g.history) >= g.compensation {
+			g.history = g.history[1:]
+		}
+		g.history = append(g.history, g.activePiece.block)
+	}
+	g.tick++
+	g.send(DescFrame, g.tick)
+}
+
+// the active piece changes, the positions are useless
+func (g *Game) clearHistory() {
+	g.history = g.history[:0]
+}
+
+// rewind the active piece to the frame, apply the move, then replay the gravity ticks missed
+// returns false if the frame is out of the window or the move is not possible then
+func (g *Game) compensate(frame, window int, move func(zone ZoneData, b block) (block, bool)) bool {
+	g.Lock()
+	defer g.Unlock()
+	behind := g.tick - frame
+	if behind <= 0 || behind > window || behind > len(g.history) {
+		return false
+	}
+	zone := g.mainZone.toZoneData()
+	b, ok := move(zone, g.history[len(g.history)-behind])
+	if !ok {
+		return false
+	}
+	for i := 0; i < behind && zone.canBlockMoveDown(b); i++ {
+		b = b.moveDown()
+	}
+	g.activePiece.block = b
+	g.clearHistory()
+	return true
+}
+
+// move left at the frame, at most window ticks late
+func (g *Game) MoveLeftAt(frame, window int) {
+	if !g.compensate(frame, window, func(zone ZoneData, b block) (block, bool) {
+		if zone.canBlockMoveLeft(b) {
+			return b.moveLeft(), true
+		}
+		return b, false
+	}) {
+		g.MoveLeft()
+		return
+	}
+	g.check(false, false)
+}
+
+// move right at the frame, at most window ticks late
+func (g *Game) MoveRightAt(frame, window int) {
+	if !g.compensate(frame, window, func(zone ZoneData, b block) (block, bool) {
+		if zone.canBlockMoveRight(b) {
+			return b.moveRight(), true
+		}
+		return b, false
+	}) {
+		g.MoveRight()
+		return
+	}
+	g.check(false, false)
+}
+
+// rotate at the frame, at most window ticks late
+func (g *Game) RotateAt(frame, window int) {
+	if !g.compensate(frame, window, func(zone ZoneData, b block) (block, bool) {
+		return zone.canBlockRotate(b)
+	}) {
+		g.Rotate()
+		return
+	}
+	g.check(false, false)
+}
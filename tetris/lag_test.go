@@ -0,0 +1,39 @@
+package tetris
+
+import "testing"
+
+func Test_Compensate(t *testing.T) {
+	g, err := NewGame(20, 10, 5, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the piece fell one step after the player moved it
+	g.Lock()
+	start := g.activePiece.block
+	g.history = append(g.history, start)
+	b := start
+	g.activePiece.block = b.moveDown()
+	g.tick = 1
+	g.Unlock()
+
+	g.MoveLeftAt(0, defaultCompensation)
+	expect := start
+	expect.moveLeft()
+	expect.moveDown()
+	if g.activePiece.block != expect {
+		t.Errorf("the piece should be at %v, but %v", expect, g.activePiece.block)
+	}
+
+	// out of the window, it is applied at the current position
+	g.Lock()
+	g.history = append(g.history, g.activePiece.block)
+	g.tick = 10
+	curr := g.activePiece.block
+	g.Unlock()
+	g.MoveRightAt(2, defaultCompensation)
+	curr.moveRight()
+	if g.activePiece.block != curr {
+		t.Errorf("the piece should be at %v, but %v", curr, g.activePiece.block)
+	}
+}
@@ -15,6 +15,10 @@ func (d message) MarshalJSON() ([]byte, error) {
 	})
 }
 
+func (d message) GetDescription() string {
+	return d.Description
+}
+
 func NewMessage(desc string, val interface{}) message {
 	return message{
 		Description: desc,
@@ -37,4 +41,5 @@ const (
 	DescPause       = "pause"    // game pause
 	DescOver        = "gameover" // game over
 	DescClear       = "clear"    // game zone clear
+	DescFrame       = "frame"    // gravity tick, the frame number is attached to the operations
 )
@@ -91,6 +91,13 @@ func (t *Timer) Reset() {
 	t.currentTick = tickFrequency
 }
 
+// interval in ms
+func (t *Timer) Interval() int {
+	t.Lock()
+	defer t.Unlock()
+	return t.timerInterval
+}
+
 // wait for next tick
 func (t *Timer) Wait() {
 	<-t.tick
@@ -0,0 +1,133 @@
+package types
+
+import "sync"
+
+// stats an achievement could be based on
+const (
+	StatCombo         = "combo"          // max combo in a game
+	StatPerfectClears = "perfect_clears" // zone clears in a game
+	StatBombHits      = "bomb_hits"      // bombs hit in a game
+	StatKo            = "ko"             // ko the opponent in a game
+	StatLines         = "lines"          // lines sent in a game
+	StatWins          = "wins"           // games won in total
+	StatTitles        = "titles"         // tournaments won in total
+)
+
+// what a user does, the achievements are evaluated against it
+type AchievementEvent struct {
+	MatchEvents
+	Ko, Lines int
+	Won       bool
+	// totals of the user
+	Wins, Titles int
+}
+
+func (e AchievementEvent) stat(name string) int {
+	switch name {
+	case StatCombo:
+		return e.MaxCombo
+	case StatPerfectClears:
+		return e.PerfectClears
+	case StatBombHits:
+		return e.BombHits
+	case StatKo:
+		return e.Ko
+	case StatLines:
+		return e.Lines
+	case StatWins:
+		return e.Wins
+	case StatTitles:
+		return e.Titles
+	}
+	return 0
+}
+
+// an achievement is unlocked once the stat reaches the threshold
+// if WinRequired, it only counts in a game the user wins
+type Achievement struct {
+	Id          string
+	Name        string
+	Description string
+	Stat        string
+	Threshold   int
+	WinRequired bool
+}
+
+func (a Achievement) Reached(e AchievementEvent) bool {
+	if a.WinRequired && !e.Won {
+		return false
+	}
+	return e.stat(a.Stat) >= a.Threshold
+}
+
+// for hprose
+func (a Achievement) Wrap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":          a.Id,
+		"name":        a.Name,
+		"description": a.Description,
+	}
+}
+
+// the achievement rules and the unlocks of users
+type Achievements struct {
+	rules    []Achievement
+	unlocked map[int]map[string]int64 // uid -> achievement id -> unlocked timestamp
+	mu       sync.RWMutex
+}
+
+func NewAchievements(rules ...Achievement) *Achievements {
+	return &Achievements{
+		rules:    rules,
+		unlocked: make(map[int]map[string]int64),
+	}
+}
+
+func (as *Achievements) unlock(uid int, id string, at int64) {
+	if as.unlocked[uid] == nil {
+		as.unlocked[uid] = make(map[string]int64)
+	}
+	as.unlocked[uid][id] = at
+}
+
+// load the unlock from database
+func (as *Achievements) Load(uid int, id string, at int64) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.unlock(uid, id, at)
+}
+
+// evaluate the rules, return the achievements unlocked by the event
+func (as *Achievements) Evaluate(uid int, e AchievementEvent, at int64) []Achievement {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	res := make([]Achievement, 0)
+	for _, a := range as.rules {
+		if _, ok := as.unlocked[uid][a.Id]; ok || !a.Reached(e) {
+			continue
+		}
+		as.unlock(uid, a.Id, at)
+		res = append(res, a)
+	}
+	return res
+}
+
+// get the achievements unlocked by the user, achievement id -> unlocked timestamp
+func (as *Achievements) GetUnlocked(uid int) map[string]int64 {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	res := make(map[string]int64, len(as.unlocked[uid]))
+	for id, at := range as.unlocked[uid] {
+		res[id] = at
+	}
+	return res
+}
+
+// get all the rules
+func (as *Achievements) GetRules() []Achievement {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	res := make([]Achievement, len(as.rules))
+	copy(res, as.rules)
+	return res
+}
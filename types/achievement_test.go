@@ -0,0 +1,33 @@
+package types
+
+import "testing"
+
+func Test_Achievements(t *testing.T) {
+	as := NewAchievements(
+		Achievement{Id: "combo10", Stat: StatCombo, Threshold: 10},
+		Achievement{Id: "clear3", Stat: StatPerfectClears, Threshold: 3, WinRequired: true},
+		Achievement{Id: "champion", Stat: StatTitles, Threshold: 1},
+	)
+
+	e := AchievementEvent{MatchEvents: MatchEvents{MaxCombo: 9, PerfectClears: 3}}
+	if res := as.Evaluate(1, e, 1); len(res) != 0 {
+		t.Errorf("nothing should be unlocked, but %v", res)
+	}
+
+	e.MaxCombo, e.Won = 10, true
+	if res := as.Evaluate(1, e, 2); len(res) != 2 || res[0].Id != "combo10" || res[1].Id != "clear3" {
+		t.Errorf("combo10 and clear3 should be unlocked, but %v", res)
+	}
+	// unlocked only once
+	if res := as.Evaluate(1, e, 3); len(res) != 0 {
+		t.Errorf("the achievements are already unlocked, but %v", res)
+	}
+	if at := as.GetUnlocked(1)["combo10"]; at != 2 {
+		t.Errorf("combo10 should be unlocked at 2, but %d", at)
+	}
+
+	as.Load(2, "champion", 5)
+	if res := as.Evaluate(2, AchievementEvent{Titles: 1}, 6); len(res) != 0 {
+		t.Errorf("champion is loaded, but %v", res)
+	}
+}
@@ -0,0 +1,840 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// formats of the tournament bracket
+const (
+	FormatSingle = "single" // single elimination
+	FormatDouble = "double" // double elimination, a player is out after two losses
+	FormatSwiss  = "swiss"  // players of the similar score meet, nobody is out
+	FormatGroups = "groups" // round robin groups feeding a single elimination knockout
+)
+
+// stages of the matches
+const (
+	StageKnockout   = "knockout"
+	StageUpper      = "upper"
+	StageLower      = "lower"
+	StageGrandFinal = "grandFinal"
+	StageSwiss      = "swiss"
+	StageGroup      = "group"
+)
+
+const (
+	// players in a round robin group
+	groupSize = 4
+	// players of each group advance to the knockout
+	groupAdvance = 2
+)
+
+var (
+	ErrInvalidFormat  = fmt.Errorf("赛制只能是 %s, %s, %s 或者 %s", FormatSingle, FormatDouble, FormatSwiss, FormatGroups)
+	ErrTooFewEntrants = fmt.Errorf("参赛人数不足, 至少需要 2 人")
+	ErrMatchNotExist  = fmt.Errorf("比赛不存在")
+	ErrMatchReported  = fmt.Errorf("比赛结果已经提交")
+	ErrInvalidWinner  = fmt.Errorf("获胜者不是该比赛的选手")
+)
+
+func IsValidFormat(format string) bool {
+	switch format {
+	case FormatSingle, FormatDouble, FormatSwiss, FormatGroups:
+		return true
+	}
+	return false
+}
+
+// a player of the tournament, seeded by the rating
+type Entrant struct {
+	Uid    int
+	Rating float64
+}
+
+// a match of the round
+// P2 is -1 if P1 has a bye, the bye is won already
+type Pairing struct {
+	Id     int
+	Round  int
+	Stage  string
+	P1, P2 int
+	Winner int // -1 if not reported
+}
+
+func (p Pairing) IsBye() bool { return p.P2 < 0 }
+
+// the final or current standing of a player
+type Standing struct {
+	Uid          int
+	Rank         int
+	Wins, Losses int
+	TieBreak     float64
+}
+
+// the bracket engine pairs the rounds and ranks the players
+type Bracket interface {
+	Format() string
+	// pair the next round, nil if the current round is not finished or the bracket is over
+	NextRound() []Pairing
+	// report the winner of the match
+	Report(id, winner int) error
+	// check if the player could still win the event
+	IsAlive(uid int) bool
+	IsOver() bool
+	// the standings, the champion first
+	Standings() []Standing
+	// all matches paired so far, by id
+	Matches() []Pairing
+}
+
+func NewBracket(format string, entrants []Entrant) (Bracket, error) {
+	if len(entrants) < 2 {
+		return nil, ErrTooFewEntrants
+	}
+	mb := newMatchBook(entrants)
+	switch format {
+	case FormatSingle:
+		return newSingleElim(mb, mb.seeds, StageKnockout), nil
+	case FormatDouble:
+		return newDoubleElim(mb), nil
+	case FormatSwiss:
+		return newSwiss(mb), nil
+	case FormatGroups:
+		return newGroupStage(mb), nil
+	}
+	return nil, ErrInvalidFormat
+}
+
+// the record of a player
+type record struct {
+	wins, losses, byes int
+	opponents          []int
+	beaten             []int
+}
+
+// the matches and the records shared by the engines
+type matchBook struct {
+	round   int
+	matches map[int]*Pairing
+	pending int // matches not reported in the current round
+	records map[int]*record
+	seeds   []int // uids by seed, the highest rating first
+	seedOf  map[int]int
+	nextId  int
+	mu      sync.Mutex
+}
+
+func newMatchBook(entrants []Entrant) *matchBook {
+	es := make([]Entrant, len(entrants))
+	copy(es, entrants)
+	sort.Stable(byRating(es))
+	mb := &matchBook{
+		matches: make(map[int]*Pairing),
+		records: make(map[int]*record),
+		seeds:   make([]int, len(es)),
+		seedOf:  make(map[int]int),
+	}
+	for i, e := range es {
+		mb.seeds[i] = e.Uid
+		mb.seedOf[e.Uid] = i
+		mb.records[e.Uid] = new(record)
+	}
+	return mb
+}
+
+type byRating []Entrant
+
+func (br byRating) Len() int           { return len(br) }
+func (br byRating) Less(i, j int) bool { return br[i].Rating > br[j].Rating }
+func (br byRating) Swap(i, j int)      { br[i], br[j] = br[j], br[i] }
+
+// create a match of the current round, a bye is won at once
+func (mb *matchBook) pair(stage string, p1, p2 int) *Pairing {
+	mb.nextId++
+	p := &Pairing{Id: mb.nextId, Round: mb.round, Stage: stage, P1: p1, P2: p2, Winner: -1}
+	mb.matches[p.Id] = p
+	if p.IsBye() {
+		p.Winner = p1
+		mb.records[p1].wins++
+		mb.records[p1].byes++
+	} else {
+		mb.pending++
+	}
+	return p
+}
+
+// report the winner, return the loser
+func (mb *matchBook) report(id, winner int) (int, error) {
+	p, ok := mb.matches[id]
+	if !ok {
+		return -1, ErrMatchNotExist
+	}
+	if p.Winner >= 0 {
+		return -1, ErrMatchReported
+	}
+	loser := p.P2
+	switch winner {
+	case p.P1:
+	case p.P2:
+		loser = p.P1
+	default:
+		return -1, ErrInvalidWinner
+	}
+	p.Winner = winner
+	mb.pending--
+	w, l := mb.records[winner], mb.records[loser]
+	w.wins++
+	w.opponents = append(w.opponents, loser)
+	w.beaten = append(w.beaten, loser)
+	l.losses++
+	l.opponents = append(l.opponents, winner)
+	return loser, nil
+}
+
+func (mb *matchBook) roundOver() bool { return mb.pending == 0 }
+
+func (mb *matchBook) Matches() []Pairing {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	res := make([]Pairing, 0, len(mb.matches))
+	for id := 1; id <= mb.nextId; id++ {
+		res = append(res, *mb.matches[id])
+	}
+	return res
+}
+
+func (mb *matchBook) played(a, b int) bool {
+	for _, o := range mb.records[a].opponents {
+		if o == b {
+			return true
+		}
+	}
+	return false
+}
+
+// sum of the wins of the opponents
+func (mb *matchBook) buchholz(uid int) float64 {
+	sum := 0
+	for _, o := range mb.records[uid].opponents {
+		sum += mb.records[o].wins
+	}
+	return float64(sum)
+}
+
+// sum of the wins of the beaten opponents
+func (mb *matchBook) sonnebornBerger(uid int) float64 {
+	sum := 0
+	for _, o := range mb.records[uid].beaten {
+		sum += mb.records[o].wins
+	}
+	return float64(sum)
+}
+
+// check if a beats b in the matches
+func (mb *matchBook) beats(a, b int) bool {
+	for _, o := range mb.records[a].beaten {
+		if o == b {
+			return true
+		}
+	}
+	return false
+}
+
+func (mb *matchBook) standing(uid int, tieBreak float64) Standing {
+	r := mb.records[uid]
+	return Standing{Uid: uid, Wins: r.wins, Losses: r.losses, TieBreak: tieBreak}
+}
+
+// pair the players of a pool, the highest seed meets the lowest one it has not played
+// if the pool is odd, the highest seed without a bye gets one
+func (mb *matchBook) pairPool(stage string, pool []int, byeToLowest bool) []Pairing {
+	pool = append([]int(nil), pool...)
+	res := make([]Pairing, 0, len(pool)/2+1)
+	if len(pool)%2 == 1 {
+		candidates := pool
+		if byeToLowest {
+			candidates = make([]int, len(pool))
+			for i, uid := range pool {
+				candidates[len(pool)-1-i] = uid
+			}
+		}
+		bye := candidates[0]
+		for _, uid := range candidates {
+			if mb.records[uid].byes == 0 {
+				bye = uid
+				break
+			}
+		}
+		res = append(res, *mb.pair(stage, bye, -1))
+		pool = remove(pool, bye)
+	}
+	for len(pool) > 0 {
+		a := pool[0]
+		b := pool[len(pool)-1]
+		for i := len(pool) - 1; i > 0; i-- {
+			if !mb.played(a, pool[i]) {
+				b = pool[i]
+				break
+			}
+		}
+		res = append(res, *mb.pair(stage, a, b))
+		pool = remove(remove(pool, a), b)
+	}
+	return res
+}
+
+func remove(uids []int, uid int) []int {
+	for i, v := range uids {
+		if v == uid {
+			return append(uids[:i:i], uids[i+1:]...)
+		}
+	}
+	return uids
+}
+
+// sort the standings and assign the ranks, the equal standings share the rank
+type standingSorter struct {
+	ss   []Standing
+	less func(a, b Standing) bool
+}
+
+func (s standingSorter) Len() int           { return len(s.ss) }
+func (s standingSorter) Less(i, j int) bool { return s.less(s.ss[i], s.ss[j]) }
+func (s standingSorter) Swap(i, j int)      { s.ss[i], s.ss[j] = s.ss[j], s.ss[i] }
+
+func rankStandings(ss []Standing, less, equal func(a, b Standing) bool) []Standing {
+	sort.Sort(standingSorter{ss, less})
+	for i := range ss {
+		ss[i].Rank = i + 1
+		if i > 0 && equal(ss[i-1], ss[i]) {
+			ss[i].Rank = ss[i-1].Rank
+		}
+	}
+	return ss
+}
+
+// single elimination
+// the field is filled up to a power of 2 with byes, the top seeds get the byes
+type singleElim struct {
+	*matchBook
+	stage     string
+	slots     []int // players in the bracket order, -1 for a bye
+	current   []int // match ids of the current round in the bracket order
+	elimRound map[int]int
+	players   []int
+}
+
+func newSingleElim(mb *matchBook, seeds []int, stage string) *singleElim {
+	size := 1
+	for size < len(seeds) {
+		size <<= 1
+	}
+	slots := make([]int, size)
+	for i, s := range bracketOrder(size) {
+		slots[i] = -1
+		if s < len(seeds) {
+			slots[i] = seeds[s]
+		}
+	}
+	return &singleElim{
+		matchBook: mb,
+		stage:     stage,
+		slots:     slots,
+		elimRound: make(map[int]int),
+		players:   append([]int(nil), seeds...),
+	}
+}
+
+// the seeds in the bracket order, the top 2 seeds could only meet in the final
+func bracketOrder(size int) []int {
+	order := []int{0}
+	for n := 2; n <= size; n <<= 1 {
+		next := make([]int, 0, n)
+		for _, s := range order {
+			next = append(next, s, n-1-s)
+		}
+		order = next
+	}
+	return order
+}
+
+func (se *singleElim) Format() string { return FormatSingle }
+
+func (se *singleElim) NextRound() []Pairing {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.next()
+}
+
+func (se *singleElim) next() []Pairing {
+	if !se.roundOver() || se.over() {
+		return nil
+	}
+	if len(se.current) > 0 {
+		winners := make([]int, len(se.current))
+		for i, id := range se.current {
+			winners[i] = se.matches[id].Winner
+		}
+		se.slots = winners
+	}
+	se.round++
+	se.current = se.current[:0]
+	res := make([]Pairing, 0, len(se.slots)/2)
+	for i := 0; i+1 < len(se.slots); i += 2 {
+		a, b := se.slots[i], se.slots[i+1]
+		if a < 0 {
+			a, b = b, a
+		}
+		p := se.pair(se.stage, a, b)
+		se.current = append(se.current, p.Id)
+		res = append(res, *p)
+	}
+	return res
+}
+
+func (se *singleElim) over() bool {
+	return len(se.current) == 1 && se.roundOver()
+}
+
+func (se *singleElim) Report(id, winner int) error {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.report(id, winner)
+}
+
+func (se *singleElim) report(id, winner int) error {
+	loser, err := se.matchBook.report(id, winner)
+	if err != nil {
+		return err
+	}
+	se.elimRound[loser] = se.round
+	return nil
+}
+
+func (se *singleElim) IsAlive(uid int) bool {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	_, out := se.elimRound[uid]
+	return !out
+}
+
+func (se *singleElim) IsOver() bool {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.over()
+}
+
+func (se *singleElim) Standings() []Standing {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	return se.standings()
+}
+
+// the later a player is out, the higher the rank
+func (se *singleElim) standings() []Standing {
+	ss := make([]Standing, 0, len(se.players))
+	for _, uid := range se.players {
+		out, ok := se.elimRound[uid]
+		if !ok {
+			out = se.round + 1
+		}
+		ss = append(ss, se.standing(uid, float64(out)))
+	}
+	return rankStandings(ss, func(a, b Standing) bool {
+		if a.TieBreak != b.TieBreak {
+			return a.TieBreak > b.TieBreak
+		}
+		return se.seedOf[a.Uid] < se.seedOf[b.Uid]
+	}, func(a, b Standing) bool {
+		return a.TieBreak == b.TieBreak
+	})
+}
+
+// double elimination
+// the players without loss and with one loss are paired in the upper and lower pools
+// the last players of both pools meet in the grand final, the bracket resets if the lower one wins
+type doubleElim struct {
+	*matchBook
+	elimRound map[int]int
+}
+
+func newDoubleElim(mb *matchBook) *doubleElim {
+	return &doubleElim{matchBook: mb, elimRound: make(map[int]int)}
+}
+
+func (de *doubleElim) Format() string { return FormatDouble }
+
+func (de *doubleElim) pools() (upper, lower []int) {
+	for _, uid := range de.seeds {
+		switch de.records[uid].losses {
+		case 0:
+			upper = append(upper, uid)
+		case 1:
+			lower = append(lower, uid)
+		}
+	}
+	return
+}
+
+func (de *doubleElim) NextRound() []Pairing {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	if !de.roundOver() || de.over() {
+		return nil
+	}
+	upper, lower := de.pools()
+	de.round++
+	if len(upper) == 1 && len(lower) == 1 {
+		return []Pairing{*de.pair(StageGrandFinal, upper[0], lower[0])}
+	}
+	// a single player of a pool waits for the other pool
+	res := make([]Pairing, 0)
+	if len(upper) > 1 {
+		res = append(res, de.pairPool(StageUpper, upper, false)...)
+	}
+	if len(lower) > 1 {
+		res = append(res, de.pairPool(StageLower, lower, false)...)
+	}
+	return res
+}
+
+func (de *doubleElim) over() bool {
+	upper, lower := de.pools()
+	return de.roundOver() && len(upper)+len(lower) <= 1
+}
+
+func (de *doubleElim) Report(id, winner int) error {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	loser, err := de.report(id, winner)
+	if err != nil {
+		return err
+	}
+	if de.records[loser].losses >= 2 {
+		de.elimRound[loser] = de.round
+	}
+	return nil
+}
+
+func (de *doubleElim) IsAlive(uid int) bool {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	_, out := de.elimRound[uid]
+	return !out
+}
+
+func (de *doubleElim) IsOver() bool {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	return de.over()
+}
+
+func (de *doubleElim) Standings() []Standing {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	ss := make([]Standing, 0, len(de.seeds))
+	for _, uid := range de.seeds {
+		out, ok := de.elimRound[uid]
+		if !ok {
+			out = de.round + 1
+		}
+		ss = append(ss, de.standing(uid, float64(out)))
+	}
+	return rankStandings(ss, func(a, b Standing) bool {
+		if a.TieBreak != b.TieBreak {
+			return a.TieBreak > b.TieBreak
+		}
+		if a.Losses != b.Losses {
+			return a.Losses < b.Losses
+		}
+		return de.seedOf[a.Uid] < de.seedOf[b.Uid]
+	}, func(a, b Standing) bool {
+		return a.TieBreak == b.TieBreak && a.Losses == b.Losses
+	})
+}
+
+// swiss
+// the players of the similar score meet in each round, nobody meets twice if possible
+// the rounds are enough to find a single player winning all games
+type swiss struct {
+	*matchBook
+	rounds int
+}
+
+func newSwiss(mb *matchBook) *swiss {
+	rounds := 0
+	for 1<<uint(rounds) < len(mb.seeds) {
+		rounds++
+	}
+	return &swiss{matchBook: mb, rounds: rounds}
+}
+
+func (sw *swiss) Format() string { return FormatSwiss }
+
+func (sw *swiss) NextRound() []Pairing {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if !sw.roundOver() || sw.round >= sw.rounds {
+		return nil
+	}
+	// rank by the score then the seed, the lowest ranked gets the bye
+	ranked := make([]int, 0, len(sw.seeds))
+	for _, s := range sw.standings() {
+		ranked = append(ranked, s.Uid)
+	}
+	sw.round++
+	res := make([]Pairing, 0, len(ranked)/2+1)
+	if len(ranked)%2 == 1 {
+		bye := ranked[len(ranked)-1]
+		for i := len(ranked) - 1; i >= 0; i-- {
+			if sw.records[ranked[i]].byes == 0 {
+				bye = ranked[i]
+				break
+			}
+		}
+		res = append(res, *sw.pair(StageSwiss, bye, -1))
+		ranked = remove(ranked, bye)
+	}
+	for len(ranked) > 0 {
+		a, b := ranked[0], ranked[1]
+		for _, o := range ranked[1:] {
+			if !sw.played(a, o) {
+				b = o
+				break
+			}
+		}
+		res = append(res, *sw.pair(StageSwiss, a, b))
+		ranked = remove(remove(ranked, a), b)
+	}
+	return res
+}
+
+func (sw *swiss) Report(id, winner int) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	_, err := sw.report(id, winner)
+	return err
+}
+
+func (sw *swiss) IsAlive(uid int) bool {
+	return !sw.IsOver()
+}
+
+func (sw *swiss) IsOver() bool {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.round >= sw.rounds && sw.roundOver()
+}
+
+func (sw *swiss) Standings() []Standing {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.standings()
+}
+
+// by the wins, the buchholz, the head to head, then the seed
+func (sw *swiss) standings() []Standing {
+	ss := make([]Standing, 0, len(sw.seeds))
+	for _, uid := range sw.seeds {
+		ss = append(ss, sw.standing(uid, sw.buchholz(uid)))
+	}
+	return rankStandings(ss, func(a, b Standing) bool {
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if a.TieBreak != b.TieBreak {
+			return a.TieBreak > b.TieBreak
+		}
+		if sw.beats(a.Uid, b.Uid) != sw.beats(b.Uid, a.Uid) {
+			return sw.beats(a.Uid, b.Uid)
+		}
+		return sw.seedOf[a.Uid] < sw.seedOf[b.Uid]
+	}, func(a, b Standing) bool {
+		return false
+	})
+}
+
+// round robin groups feeding a single elimination knockout
+// the players are seeded into the groups by snake, the top of each group advance
+type groupStage struct {
+	*matchBook
+	groups    [][]int
+	schedule  [][][2]int // round -> matches of all groups
+	ko        *singleElim
+	groupRank map[int]int // rank in the group of the players out in the group stage
+}
+
+func newGroupStage(mb *matchBook) *groupStage {
+	n := len(mb.seeds)
+	numGroups := (n + groupSize - 1) / groupSize
+	groups := make([][]int, numGroups)
+	for i, uid := range mb.seeds {
+		g := i % numGroups
+		if (i/numGroups)%2 == 1 {
+			g = numGroups - 1 - g
+		}
+		groups[g] = append(groups[g], uid)
+	}
+	gs := &groupStage{matchBook: mb, groups: groups, groupRank: make(map[int]int)}
+	for _, g := range groups {
+		for r, matches := range roundRobin(g) {
+			if r >= len(gs.schedule) {
+				gs.schedule = append(gs.schedule, nil)
+			}
+			gs.schedule[r] = append(gs.schedule[r], matches...)
+		}
+	}
+	return gs
+}
+
+// the circle method, a player rests in each round if the group is odd
+func roundRobin(players []int) [][][2]int {
+	ps := append([]int(nil), players...)
+	if len(ps)%2 == 1 {
+		ps = append(ps, -1)
+	}
+	n := len(ps)
+	rounds := make([][][2]int, 0, n-1)
+	for r := 0; r < n-1; r++ {
+		matches := make([][2]int, 0, n/2)
+		for i := 0; i < n/2; i++ {
+			a, b := ps[i], ps[n-1-i]
+			if a >= 0 && b >= 0 {
+				matches = append(matches, [2]int{a, b})
+			}
+		}
+		rounds = append(rounds, matches)
+		// keep the first one, rotate the others
+		ps = append([]int{ps[0], ps[n-1]}, ps[1:n-1]...)
+	}
+	return rounds
+}
+
+func (gs *groupStage) Format() string { return FormatGroups }
+
+func (gs *groupStage) NextRound() []Pairing {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if !gs.roundOver() {
+		return nil
+	}
+	if gs.round < len(gs.schedule) {
+		gs.round++
+		res := make([]Pairing, 0, len(gs.schedule[gs.round-1]))
+		for _, m := range gs.schedule[gs.round-1] {
+			res = append(res, *gs.pair(StageGroup, m[0], m[1]))
+		}
+		return res
+	}
+	if gs.ko == nil {
+		gs.ko = newSingleElim(gs.matchBook, gs.advance(), StageKnockout)
+	}
+	return gs.ko.next()
+}
+
+// the top players of the groups, the winners are seeded first
+func (gs *groupStage) advance() []int {
+	seeds := make([]int, 0)
+	ranked := make([][]Standing, len(gs.groups))
+	for i, g := range gs.groups {
+		ranked[i] = gs.groupStandings(g)
+	}
+	for place := 0; place < groupAdvance; place++ {
+		for _, ss := range ranked {
+			if place < len(ss) {
+				seeds = append(seeds, ss[place].Uid)
+			}
+		}
+	}
+	for _, ss := range ranked {
+		for place := groupAdvance; place < len(ss); place++ {
+			gs.groupRank[ss[place].Uid] = place + 1
+		}
+	}
+	return seeds
+}
+
+// by the wins, the head to head, the sonneborn berger, then the seed
+func (gs *groupStage) groupStandings(g []int) []Standing {
+	ss := make([]Standing, 0, len(g))
+	for _, uid := range g {
+		ss = append(ss, gs.standing(uid, gs.sonnebornBerger(uid)))
+	}
+	return rankStandings(ss, func(a, b Standing) bool {
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if gs.beats(a.Uid, b.Uid) != gs.beats(b.Uid, a.Uid) {
+			return gs.beats(a.Uid, b.Uid)
+		}
+		if a.TieBreak != b.TieBreak {
+			return a.TieBreak > b.TieBreak
+		}
+		return gs.seedOf[a.Uid] < gs.seedOf[b.Uid]
+	}, func(a, b Standing) bool {
+		return false
+	})
+}
+
+func (gs *groupStage) Report(id, winner int) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.ko != nil {
+		return gs.ko.report(id, winner)
+	}
+	_, err := gs.report(id, winner)
+	return err
+}
+
+func (gs *groupStage) IsAlive(uid int) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.ko == nil {
+		return true
+	}
+	_, out := gs.ko.elimRound[uid]
+	_, outInGroup := gs.groupRank[uid]
+	return !out && !outInGroup
+}
+
+func (gs *groupStage) IsOver() bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.ko != nil && gs.ko.over()
+}
+
+// the knockout players first, then the players out in the group stage
+func (gs *groupStage) Standings() []Standing {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.ko == nil {
+		res := make([]Standing, 0, len(gs.seeds))
+		for _, g := range gs.groups {
+			res = append(res, gs.groupStandings(g)...)
+		}
+		return res
+	}
+	res := gs.ko.standings()
+	offset := len(res)
+	out := make([]Standing, 0)
+	for uid, rank := range gs.groupRank {
+		out = append(out, gs.standing(uid, float64(rank)))
+	}
+	out = rankStandings(out, func(a, b Standing) bool {
+		if a.TieBreak != b.TieBreak {
+			return a.TieBreak < b.TieBreak
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		return gs.seedOf[a.Uid] < gs.seedOf[b.Uid]
+	}, func(a, b Standing) bool {
+		return a.TieBreak == b.TieBreak && a.Wins == b.Wins
+	})
+	for i := range out {
+		out[i].Rank += offset
+	}
+	return append(res, out...)
+}
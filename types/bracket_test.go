@@ -0,0 +1,255 @@
+package types
+
+import "testing"
+
+// entrants with uid 1..n, the lower uid has the higher rating
+func entrants(n int) []Entrant {
+	es := make([]Entrant, n)
+	// shuffled, the engine seeds them by the rating
+	for i := range es {
+		uid := n - i
+		es[i] = Entrant{Uid: uid, Rating: float64(2000 - uid)}
+	}
+	return es
+}
+
+// play the bracket to the end, return the number of rounds
+func playBracket(t *testing.T, b Bracket, win func(p Pairing) int) (rounds int, pairings [][]Pairing) {
+	for !b.IsOver() {
+		ps := b.NextRound()
+		if len(ps) == 0 {
+			t.Fatalf("%s: no pairings but the bracket is not over", b.Format())
+		}
+		rounds++
+		pairings = append(pairings, ps)
+		for _, p := range ps {
+			if p.IsBye() {
+				if err := b.Report(p.Id, p.P1); err != ErrMatchReported {
+					t.Errorf("%s: the bye should be reported already, but %v", b.Format(), err)
+				}
+				continue
+			}
+			if b.NextRound() != nil {
+				t.Errorf("%s: the next round should not be paired before the current one is over", b.Format())
+			}
+			if err := b.Report(p.Id, win(p)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if rounds > 20 {
+			t.Fatalf("%s: too many rounds", b.Format())
+		}
+	}
+	return
+}
+
+// the higher rating always wins
+func favourite(p Pairing) int {
+	if p.P1 < p.P2 {
+		return p.P1
+	}
+	return p.P2
+}
+
+func Test_NewBracket(t *testing.T) {
+	if _, err := NewBracket("knockout", entrants(4)); err != ErrInvalidFormat {
+		t.Errorf("the format should be invalid, but %v", err)
+	}
+	if _, err := NewBracket(FormatSingle, entrants(1)); err != ErrTooFewEntrants {
+		t.Errorf("the entrants should be too few, but %v", err)
+	}
+	b, err := NewBracket(FormatSingle, entrants(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := b.NextRound()[0]
+	if err := b.Report(p.Id+1, p.P1); err != ErrMatchNotExist {
+		t.Errorf("the match should not exist, but %v", err)
+	}
+	if err := b.Report(p.Id, 3); err != ErrInvalidWinner {
+		t.Errorf("the winner should be invalid, but %v", err)
+	}
+}
+
+func Test_SingleElimination(t *testing.T) {
+	b, err := NewBracket(FormatSingle, entrants(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rounds, pairings := playBracket(t, b, favourite)
+	if rounds != 3 {
+		t.Errorf("5 players should play 3 rounds, but %d", rounds)
+	}
+	// the top 3 seeds get the byes
+	byes := 0
+	for _, p := range pairings[0] {
+		if p.IsBye() {
+			byes++
+			if p.P1 > 3 {
+				t.Errorf("the bye should go to the top seeds, but %d", p.P1)
+			}
+		}
+	}
+	if byes != 3 {
+		t.Errorf("5 players should have 3 byes, but %d", byes)
+	}
+	// the top 2 seeds meet in the final
+	if final := pairings[2][0]; favourite(final) != 1 || final.P1+final.P2 != 3 {
+		t.Errorf("the final should be 1 vs 2, but %d vs %d", final.P1, final.P2)
+	}
+	ss := b.Standings()
+	if ss[0].Uid != 1 || ss[0].Rank != 1 || ss[1].Uid != 2 || ss[1].Rank != 2 {
+		t.Errorf("the gold and silver should be 1 and 2, but %v", ss[:2])
+	}
+	// the semi final losers share the rank
+	if ss[2].Rank != 3 || ss[3].Rank != 3 {
+		t.Errorf("the semi final losers should be both 3rd, but %v", ss[2:4])
+	}
+	if b.IsAlive(2) || !b.IsAlive(1) {
+		t.Error("only the champion should be alive")
+	}
+	// 2 matches and 3 byes, 2 semi finals and the final
+	ms := b.Matches()
+	if len(ms) != 7 {
+		t.Fatalf("there should be 7 matches, but %d", len(ms))
+	}
+	for i, m := range ms {
+		if m.Id != i+1 || m.Winner < 0 {
+			t.Errorf("the matches should be sorted by id and reported, but %v", m)
+		}
+	}
+	if final := ms[6]; final.Round != 3 || final.Winner != 1 {
+		t.Errorf("the last match should be the final won by 1, but %v", final)
+	}
+}
+
+func Test_DoubleElimination(t *testing.T) {
+	b, err := NewBracket(FormatDouble, entrants(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	playBracket(t, b, favourite)
+	ss := b.Standings()
+	if ss[0].Uid != 1 || ss[0].Losses != 0 {
+		t.Errorf("the champion should be 1 without loss, but %v", ss[0])
+	}
+	for _, s := range ss[1:] {
+		if s.Losses != 2 {
+			t.Errorf("the others should be out after 2 losses, but %v", s)
+		}
+	}
+
+	// the lower one wins the grand final, the bracket resets
+	b, _ = NewBracket(FormatDouble, entrants(2))
+	underdog := func(p Pairing) int { return p.P1 + p.P2 - favourite(p) }
+	rounds, pairings := playBracket(t, b, func(p Pairing) int {
+		if p.Stage == StageUpper {
+			return favourite(p)
+		}
+		return underdog(p)
+	})
+	if rounds != 3 {
+		t.Errorf("the bracket should reset, 3 rounds, but %d", rounds)
+	}
+	if pairings[1][0].Stage != StageGrandFinal || pairings[2][0].Stage != StageLower {
+		t.Errorf("the stages should be grand final then reset, but %v", pairings)
+	}
+	if ss := b.Standings(); ss[0].Uid != 2 {
+		t.Errorf("the underdog should win, but %v", ss[0])
+	}
+}
+
+func Test_Swiss(t *testing.T) {
+	b, err := NewBracket(FormatSwiss, entrants(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rounds, pairings := playBracket(t, b, favourite)
+	if rounds != 3 {
+		t.Errorf("7 players should play 3 rounds, but %d", rounds)
+	}
+	byes := make(map[int]bool)
+	met := make(map[[2]int]bool)
+	for _, ps := range pairings {
+		for _, p := range ps {
+			if p.IsBye() {
+				if byes[p.P1] {
+					t.Errorf("%d has 2 byes", p.P1)
+				}
+				byes[p.P1] = true
+				continue
+			}
+			key := [2]int{p.P1, p.P2}
+			if p.P1 > p.P2 {
+				key = [2]int{p.P2, p.P1}
+			}
+			if met[key] {
+				t.Errorf("%d and %d meet twice", p.P1, p.P2)
+			}
+			met[key] = true
+		}
+	}
+	ss := b.Standings()
+	if ss[0].Uid != 1 || ss[0].Wins != 3 {
+		t.Errorf("1 should win all games, but %v", ss[0])
+	}
+	for i := 1; i < len(ss); i++ {
+		if ss[i].Wins > ss[i-1].Wins || (ss[i].Wins == ss[i-1].Wins && ss[i].TieBreak > ss[i-1].TieBreak) {
+			t.Errorf("the standings should be sorted by wins and buchholz, but %v", ss)
+		}
+	}
+}
+
+func Test_Groups(t *testing.T) {
+	b, err := NewBracket(FormatGroups, entrants(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rounds, pairings := playBracket(t, b, favourite)
+	// 2 groups of 3 play 3 rounds, the top 4 play 2 knockout rounds
+	if rounds != 5 {
+		t.Errorf("6 players should play 5 rounds, but %d", rounds)
+	}
+	for _, p := range pairings[3] {
+		if p.Stage != StageKnockout {
+			t.Errorf("the 4th round should be knockout, but %v", p)
+		}
+		// snake seeded groups are 1, 4, 5 and 2, 3, 6
+		// the group winners meet the runners up of the other group
+		if p.P1+p.P2 != 1+3 && p.P1+p.P2 != 2+4 {
+			t.Errorf("the semi final should be 1 vs 3 and 2 vs 4, but %d vs %d", p.P1, p.P2)
+		}
+	}
+	ss := b.Standings()
+	if len(ss) != 6 || ss[0].Uid != 1 || ss[1].Uid != 2 {
+		t.Errorf("the gold and silver should be 1 and 2, but %v", ss)
+	}
+	// out in the group stage
+	if b.IsAlive(5) || b.IsAlive(6) || ss[4].Rank != 5 {
+		t.Errorf("5 and 6 should be out in the groups, but %v", ss)
+	}
+}
+
+func Test_RoundRobin(t *testing.T) {
+	rounds := roundRobin([]int{1, 2, 3, 4, 5})
+	if len(rounds) != 5 {
+		t.Fatalf("5 players should play 5 rounds, but %d", len(rounds))
+	}
+	met := make(map[[2]int]int)
+	for _, matches := range rounds {
+		for _, m := range matches {
+			if m[0] > m[1] {
+				m[0], m[1] = m[1], m[0]
+			}
+			met[m]++
+		}
+	}
+	if len(met) != 10 {
+		t.Errorf("5 players should have 10 matches, but %d", len(met))
+	}
+	for m, n := range met {
+		if n != 1 {
+			t.Errorf("%v meet %d times", m, n)
+		}
+	}
+}
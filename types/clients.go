@@ -12,10 +12,16 @@ type gameServerStub struct {
 	Start               func(tid int) error
 	Delete              func(tid int) error
 	Create              func(tid int) error
-	SetNormalGameResult func(tid, winnerUid, bet int) error
-	SetTournamentResult func(tid, winnerUid int) error
+	SetNormalGameResult func(tid, winnerUid, bet, winnerScore, loserScore int, seriesOver bool) error
+	HoldGameResult      func(tid, winnerUid int) error
+	UnlockAchievements  func(tid, uid int, achievements []map[string]interface{}) error
+	Advance             func(tid, uid, nid int) error
+	Notify              func(tid int, text string) error
+	Eliminate           func(tid, uid int, text string) error
+	Bracket             func(tid int, bracket map[string]interface{}) error
 	SysText             func(text string) error
 	Deactivate          func() error
+	QueueStats          func() (map[string]map[string]interface{}, error)
 }
 
 func newGameServerStub() *gameServerStub { return new(gameServerStub) }
@@ -0,0 +1,192 @@
+package types
+
+import (
+	"fmt"
+	"sync"
+)
+
+// relation of a user to another
+const (
+	FriendRequested = iota
+	FriendAccepted
+	FriendBlocked
+)
+
+var (
+	ErrFriendSelf       = fmt.Errorf("不能添加自己为好友")
+	ErrAlreadyFriend    = fmt.Errorf("你们已经是好友了")
+	ErrAlreadyRequested = fmt.Errorf("已经发送过好友请求, 请等待对方回应")
+	ErrNoFriendRequest  = fmt.Errorf("对方没有向你发送好友请求")
+	ErrNotFriend        = fmt.Errorf("你们还不是好友")
+	ErrBlocked          = fmt.Errorf("对方拒绝接收你的消息")
+	ErrNotBlocked       = fmt.Errorf("你没有屏蔽该用户")
+)
+
+// relations between users
+// a relation is directed, the friendship is accepted in both directions
+type Friends struct {
+	relations map[int]map[int]int // uid -> uid -> relation
+	mu        sync.RWMutex
+}
+
+func NewFriends() *Friends {
+	return &Friends{relations: make(map[int]map[int]int)}
+}
+
+func (fs *Friends) get(from, to int) (int, bool) {
+	r, ok := fs.relations[from][to]
+	return r, ok
+}
+
+func (fs *Friends) set(from, to, relation int) {
+	if fs.relations[from] == nil {
+		fs.relations[from] = make(map[int]int)
+	}
+	fs.relations[from][to] = relation
+}
+
+func (fs *Friends) del(from, to int) {
+	delete(fs.relations[from], to)
+}
+
+// load the relation from database
+func (fs *Friends) Load(from, to, relation int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.set(from, to, relation)
+}
+
+// get the relation, false if there is no relation
+func (fs *Friends) GetRelation(from, to int) (int, bool) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.get(from, to)
+}
+
+// send a friend request, it is accepted if the other one already sends a request
+func (fs *Friends) Request(from, to int) error {
+	if from == to {
+		return ErrFriendSelf
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if r, ok := fs.get(to, from); ok {
+		switch r {
+		case FriendBlocked:
+			return ErrBlocked
+		case FriendRequested:
+			fs.set(from, to, FriendAccepted)
+			fs.set(to, from, FriendAccepted)
+			return nil
+		}
+	}
+	if r, ok := fs.get(from, to); ok {
+		switch r {
+		case FriendAccepted:
+			return ErrAlreadyFriend
+		case FriendRequested:
+			return ErrAlreadyRequested
+		}
+	}
+	fs.set(from, to, FriendRequested)
+	return nil
+}
+
+// accept the friend request
+func (fs *Friends) Accept(uid, requester int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if r, ok := fs.get(requester, uid); !ok || r != FriendRequested {
+		return ErrNoFriendRequest
+	}
+	fs.set(requester, uid, FriendAccepted)
+	fs.set(uid, requester, FriendAccepted)
+	return nil
+}
+
+// decline the friend request
+func (fs *Friends) Decline(uid, requester int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if r, ok := fs.get(requester, uid); !ok || r != FriendRequested {
+		return ErrNoFriendRequest
+	}
+	fs.del(requester, uid)
+	return nil
+}
+
+// remove the friend in both directions
+func (fs *Friends) Remove(uid, friend int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !fs.isFriend(uid, friend) {
+		return ErrNotFriend
+	}
+	fs.del(uid, friend)
+	fs.del(friend, uid)
+	return nil
+}
+
+// block the user, the friendship and the requests are removed
+func (fs *Friends) Block(uid, blocked int) error {
+	if uid == blocked {
+		return ErrFriendSelf
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.set(uid, blocked, FriendBlocked)
+	if r, ok := fs.get(blocked, uid); ok && r != FriendBlocked {
+		fs.del(blocked, uid)
+	}
+	return nil
+}
+
+// unblock the user
+func (fs *Friends) Unblock(uid, blocked int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if r, ok := fs.get(uid, blocked); !ok || r != FriendBlocked {
+		return ErrNotBlocked
+	}
+	fs.del(uid, blocked)
+	return nil
+}
+
+// check if the users are friends
+func (fs *Friends) IsFriend(a, b int) bool {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.isFriend(a, b)
+}
+
+func (fs *Friends) isFriend(a, b int) bool {
+	ra, oka := fs.get(a, b)
+	rb, okb := fs.get(b, a)
+	return oka && okb && ra == FriendAccepted && rb == FriendAccepted
+}
+
+// get the users with the relation to the user
+func (fs *Friends) GetRelated(uid, relation int) []int {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	res := make([]int, 0)
+	for to, r := range fs.relations[uid] {
+		if r == relation {
+			res = append(res, to)
+		}
+	}
+	return res
+}
+
+// get the users who send friend requests to the user
+func (fs *Friends) GetRequests(uid int) []int {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	res := make([]int, 0)
+	for from, rs := range fs.relations {
+		if r, ok := rs[uid]; ok && r == FriendRequested {
+			res = append(res, from)
+		}
+	}
+	return res
+}
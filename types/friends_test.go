@@ -0,0 +1,62 @@
+package types
+
+import "testing"
+
+func Test_Friends(t *testing.T) {
+	fs := NewFriends()
+
+	if err := fs.Request(1, 1); err != ErrFriendSelf {
+		t.Errorf("can not request self, but %v", err)
+	}
+	if err := fs.Request(1, 2); err != nil {
+		t.Error(err)
+	}
+	if err := fs.Request(1, 2); err != ErrAlreadyRequested {
+		t.Errorf("already requested, but %v", err)
+	}
+	if reqs := fs.GetRequests(2); len(reqs) != 1 || reqs[0] != 1 {
+		t.Errorf("2 should have the request of 1, but %v", reqs)
+	}
+	if err := fs.Accept(1, 2); err != ErrNoFriendRequest {
+		t.Errorf("2 does not request 1, but %v", err)
+	}
+	if err := fs.Accept(2, 1); err != nil {
+		t.Error(err)
+	}
+	if !fs.IsFriend(1, 2) || !fs.IsFriend(2, 1) {
+		t.Error("1 and 2 should be friends")
+	}
+
+	// requests of both sides are accepted
+	fs.Request(3, 4)
+	fs.Request(4, 3)
+	if !fs.IsFriend(3, 4) {
+		t.Error("3 and 4 should be friends")
+	}
+
+	// block removes the friendship
+	if err := fs.Block(2, 1); err != nil {
+		t.Error(err)
+	}
+	if fs.IsFriend(1, 2) {
+		t.Error("1 is blocked by 2")
+	}
+	if err := fs.Request(1, 2); err != ErrBlocked {
+		t.Errorf("1 is blocked by 2, but %v", err)
+	}
+	if err := fs.Unblock(2, 1); err != nil {
+		t.Error(err)
+	}
+	if err := fs.Request(1, 2); err != nil {
+		t.Error(err)
+	}
+	if err := fs.Decline(2, 1); err != nil {
+		t.Error(err)
+	}
+	if _, ok := fs.GetRelation(1, 2); ok {
+		t.Error("the request should be removed")
+	}
+	if err := fs.Remove(3, 4); err != nil || fs.IsFriend(3, 4) {
+		t.Errorf("3 and 4 should not be friends, error %v", err)
+	}
+}
@@ -28,6 +28,13 @@ func (h NormalHall) Wrap() []map[string]interface{} {
 	return h.Tables.Wrap()
 }
 
+// for hprose, only rated or unrated tables
+func (h *NormalHall) WrapRated(rated bool) []map[string]interface{} {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.Tables.WrapRated(rated)
+}
+
 func (h NormalHall) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]interface{}{
 		"tables": h.Tables,
@@ -62,6 +69,7 @@ const (
 // tournament hall
 type TournamentHall struct {
 	*Tables
+	id                             int
 	winners                        map[int][]string
 	losers                         map[int][]string
 	numCandidate, currentCandidate int
@@ -92,6 +100,27 @@ func NewTournamentHall(numCandidate, awardGold, awardSilver int, host string) *T
 	}
 }
 
+// table ids of the tournament start from id*tournamentIdBase, several tournaments could run at once
+const tournamentIdBase = 1e7
+
+// the tournament id of the table
+func TournamentIdOf(tableId int) int {
+	return tableId / tournamentIdBase
+}
+
+// set the tournament id, it should be set before any application
+func (th *TournamentHall) SetId(id int) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	th.id = id
+}
+
+func (th *TournamentHall) GetId() int {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	return th.id
+}
+
 func (th *TournamentHall) GetStat() (stat string) {
 	th.mu.RLock()
 	defer th.mu.RUnlock()
@@ -118,6 +147,7 @@ func (th *TournamentHall) Wrap() map[string]interface{} {
 	th.mu.RLock()
 	defer th.mu.RUnlock()
 	return map[string]interface{}{
+		"id":               th.id,
 		"numCandidate":     th.numCandidate,
 		"currNumCandidate": th.currentCandidate,
 		"awardGold":        fmt.Sprintf("%d mBTC", th.awardGold),
@@ -231,7 +261,11 @@ func (th *TournamentHall) Allocate(u *User) (int, error) {
 func (th *TournamentHall) Quit(tid, uid int) {
 	th.mu.Lock()
 	defer th.mu.Unlock()
-	switch table := th.GetTableById(tid); uid {
+	table := th.GetTableById(tid)
+	if table == nil {
+		return
+	}
+	switch uid {
 	case table._1p.GetUid():
 		th.currentCandidate--
 		th.idleTables[tid]++
@@ -249,7 +283,7 @@ func (th *TournamentHall) nextTableId() int {
 	switch th.stat {
 	case TournamentStatInGame, TournamentStatWaiting:
 		th.tBase++
-		nid = (th.round+1)*1e5 + th.tBase
+		nid = th.id*tournamentIdBase + (th.round+1)*1e5 + th.tBase
 		th.currentTableId = nid
 	default:
 		nid = -1
@@ -295,11 +329,11 @@ func (th *TournamentHall) SetWinnerLoser(tableId, uidWin int) {
 	win, lose := "", ""
 	switch uidWin {
 	case t._1p.GetUid():
-		win = t._1p.Nickname
-		lose = t._2p.Nickname
+		win = t._1p.GetNickname()
+		lose = t._2p.GetNickname()
 	case t._2p.GetUid():
-		win = t._2p.Nickname
-		lose = t._1p.Nickname
+		win = t._2p.GetNickname()
+		lose = t._1p.GetNickname()
 	default:
 		return
 	}
@@ -314,6 +348,67 @@ func (th *TournamentHall) SetWinnerLoser(tableId, uidWin int) {
 	th.DelTable(tableId)
 }
 
+// check if the table is in the final round of the tournament
+func (th *TournamentHall) IsFinal(tableId int) bool {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	round := tableId%tournamentIdBase/1e5 - 1
+	return round >= 0 && th.numCandidate>>uint(round+1) == 1
+}
+
+// the player advances without playing, the opponent does not show up
+func (th *TournamentHall) SetWalkover(tableId, uidWin int) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	t := th.GetTableById(tableId)
+	if t == nil {
+		return
+	}
+	u := t.GetPlayer(uidWin)
+	if u == nil {
+		return
+	}
+	th.winners[th.round] = append(th.winners[th.round], u.GetNickname())
+	th.DelTable(tableId)
+}
+
+// create the table of the n-th match in the current round, the players join in order
+func (th *TournamentHall) NewMatchTable(n int, us ...*User) (int, error) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	nid := th.id*tournamentIdBase + (th.round+1)*1e5 + n
+	if err := th.newTable(nid); err != nil {
+		return nid, err
+	}
+	for _, u := range us {
+		if err := th.GetTableById(nid).Join(u); err != nil {
+			return nid, err
+		}
+	}
+	return nid, nil
+}
+
+// get the status code of the tournament
+func (th *TournamentHall) GetStatus() int {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	return th.stat
+}
+
+// get the host of the game server
+func (th *TournamentHall) GetHost() string {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	return th.host
+}
+
+// get the awards of the gold and silver getter, in mBTC
+func (th *TournamentHall) GetAwards() (gold, silver int) {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	return th.awardGold, th.awardSilver
+}
+
 func (th *TournamentHall) SetGold(gold string) {
 	th.mu.Lock()
 	defer th.mu.Unlock()
@@ -326,6 +421,18 @@ func (th *TournamentHall) SetSilver(silver string) {
 	th.silverGetter = silver
 }
 
+func (th *TournamentHall) GetGold() string {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	return th.goldGetter
+}
+
+func (th *TournamentHall) GetSilver() string {
+	th.mu.RLock()
+	defer th.mu.RUnlock()
+	return th.silverGetter
+}
+
 func (th *TournamentHall) ShouldEnd() bool {
 	th.mu.Lock()
 	defer th.mu.Unlock()
@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 )
 
@@ -50,3 +51,221 @@ func Test_TournamentHall(t *testing.T) {
 	})
 	t.Log(string(b))
 }
+
+func Test_Series(t *testing.T) {
+	h := NewNormalHall()
+	id := h.NextTableId()
+	if err := h.NewTable(id, "series", "192.122.14.1", 10); err != nil {
+		t.Error(err)
+	}
+	table := h.GetTableById(id)
+	if err := table.SetBestOf(4); err == nil {
+		t.Error("best of 4 should be invalid")
+	}
+	if err := table.SetBestOf(3); err != nil {
+		t.Error(err)
+	}
+	table.Join(NewUser(1, "lol@163.com", "hi", "zoneT_T", "Lt1423"))
+	table.Join(NewUser(2, "sbchao@qq.com", "hi", "sb_chao", "Lt1323"))
+
+	table.StartSeries()
+	if _, err := table.OfferRematch(1); err != ErrSeriesNotOver {
+		t.Errorf("should not offer rematch during the series, but %v", err)
+	}
+	if table.AddScore(1) {
+		t.Error("the series should not be over after one game")
+	}
+	if table.AddScore(2) {
+		t.Error("the series should not be over after two games")
+	}
+	if !table.AddScore(2) {
+		t.Error("the series should be over")
+	}
+	if table.GetSeriesScore(2) != 2 || table.GetSeriesScore(1) != 1 {
+		t.Errorf("the score should be 1:2, but %d:%d", table.GetSeriesScore(1), table.GetSeriesScore(2))
+	}
+	if _, ok := table.Forfeit(1); ok {
+		t.Error("can not forfeit a finished series")
+	}
+
+	if agreed, _ := table.OfferRematch(1); agreed {
+		t.Error("2p does not agree yet")
+	}
+	if !table.IsRematchOffered(2) {
+		t.Error("1p offered a rematch")
+	}
+	if agreed, _ := table.OfferRematch(2); !agreed {
+		t.Error("both players agree")
+	}
+
+	table.StartSeries()
+	if table.GetSeriesId() != 2 {
+		t.Errorf("it should be the second series, but %d", table.GetSeriesId())
+	}
+	if w, ok := table.Forfeit(2); !ok || w != 1 {
+		t.Errorf("1p should win the forfeited series, but %d", w)
+	}
+	if table.IsInSeries() {
+		t.Error("the series should be over")
+	}
+}
+
+func Test_PrivateTable(t *testing.T) {
+	h := NewNormalHall()
+	id := h.NextTableId()
+	if err := h.NewTable(id, "private", "192.122.14.1", 10); err != nil {
+		t.Error(err)
+	}
+	table := h.GetTableById(id)
+	table.SetPrivate(1, "secret", "code1234")
+
+	if len(h.Wrap()) != 0 {
+		t.Error("private table should be hidden from the hall")
+	}
+	if !table.CanEnter(1, "") {
+		t.Error("the owner can always enter")
+	}
+	if table.CanEnter(2, "") || table.CanEnter(2, "wrong") {
+		t.Error("should not enter without the password")
+	}
+	if !table.CanEnter(2, "secret") || !table.CanEnter(2, "code1234") {
+		t.Error("should enter with the password or the invite code")
+	}
+	if h.GetTableByInviteCode("code1234") != table {
+		t.Error("should find the table by the invite code")
+	}
+	if _, err := table.Invite(3, 4); err != ErrCantInvite {
+		t.Errorf("only the owner or the players can invite, but %v", err)
+	}
+	if code, err := table.Invite(1, 4); err != nil || code != "code1234" {
+		t.Errorf("the owner should invite, but %v", err)
+	}
+	if !table.CanEnter(4, "") {
+		t.Error("the invited user can enter")
+	}
+}
+
+func Test_FindUser(t *testing.T) {
+	h := NewNormalHall()
+	id := h.NextTableId()
+	h.NewTable(id, "find", "192.122.14.1", 0)
+	h.JoinTable(id, NewUser(1, "lol@163.com", "hi", "zoneT_T", "Lt1423"), false)
+	h.JoinTable(id, NewUser(2, "sbchao@qq.com", "hi", "sb_chao", "Lt1323"), true)
+
+	if tid, isOb, ok := h.FindUser(1); !ok || isOb || tid != id {
+		t.Errorf("1 should play in table %d, but %d, %v, %v", id, tid, isOb, ok)
+	}
+	if tid, isOb, ok := h.FindUser(2); !ok || !isOb || tid != id {
+		t.Errorf("2 should observe table %d, but %d, %v, %v", id, tid, isOb, ok)
+	}
+	if _, _, ok := h.FindUser(3); ok {
+		t.Error("3 is not in any table")
+	}
+}
+
+func Test_TournamentRounds(t *testing.T) {
+	th := NewTournamentHall(4, 7, 3, "192.168.0.1:9901")
+	us := make([]*User, 4)
+	for i := range us {
+		us[i] = NewUser(i+1, "", "", fmt.Sprintf("user%d", i+1), "")
+		if _, err := th.Apply(us[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !th.IsFull() {
+		t.Fatal("the tournament should be full")
+	}
+	th.SetStatPending()
+	th.SetStatInGame()
+
+	if th.IsFinal(100001) {
+		t.Error("the first round is not the final")
+	}
+	th.SetWinnerLoser(100001, 1)
+	nid, err := th.Allocate(us[0])
+	if err != nil || nid != 200001 {
+		t.Errorf("the winner should be allocated to 200001, but %d: %v", nid, err)
+	}
+	// the opponent does not show up
+	th.SetWalkover(100002, 3)
+	th.Allocate(us[2])
+	if !th.IsFull() {
+		t.Error("the final should be full")
+	}
+	if !th.IsFinal(200001) {
+		t.Error("the second round is the final")
+	}
+}
+
+func Test_TournamentId(t *testing.T) {
+	th := NewTournamentHall(2, 7, 3, "192.168.0.1:9901")
+	th.SetId(12)
+	tid, err := th.Apply(NewUser(1, "", "", "user1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tid != 120100001 {
+		t.Errorf("the table id should be 120100001, but %d", tid)
+	}
+	if TournamentIdOf(tid) != 12 {
+		t.Errorf("the tournament id should be 12, but %d", TournamentIdOf(tid))
+	}
+	if !th.IsFinal(tid) {
+		t.Error("the first round of 2 candidates is the final")
+	}
+}
+
+func Test_NewMatchTable(t *testing.T) {
+	th := NewTournamentHall(3, 7, 3, "192.168.0.1:9901")
+	th.SetId(12)
+	th.SetStatPending()
+	th.SetStatInGame()
+	u1, u2 := NewUser(1, "", "", "user1", ""), NewUser(2, "", "", "user2", "")
+	tid, err := th.NewMatchTable(3, u1, u2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tid != 120200003 {
+		t.Errorf("the table id should be 120200003, but %d", tid)
+	}
+	if table := th.GetTableById(tid); table.Get1pUid() != 1 || table.Get2pUid() != 2 {
+		t.Errorf("the players should be 1 and 2, but %v", table.GetPlayers())
+	}
+}
+
+func Test_BusyUsers(t *testing.T) {
+	us := NewUsers()
+	if us.IsBusyUser(1) {
+		t.Error("the user is not busy yet")
+	}
+	us.SetBusy(1, 2)
+	if !us.IsBusyUser(1) || !us.IsBusyUser(2) {
+		t.Error("the users should be busy")
+	}
+	us.SetFree(1)
+	if us.IsBusyUser(1) {
+		t.Error("the user should be free")
+	}
+}
+
+func Test_NewTableWith(t *testing.T) {
+	h := NewNormalHall()
+	id := h.NextTableId()
+	if err := h.NewTableWith(id, "private", "192.122.14.1", 10, func(t *Table) error {
+		return t.SetBestOf(4)
+	}); err == nil {
+		t.Error("the setup fails, the table should not be created")
+	}
+	if h.IsTableExist(id) {
+		t.Error("the table should not be listed")
+	}
+	if err := h.NewTableWith(id, "private", "192.122.14.1", 10, func(t *Table) error {
+		t.SetPrivate(1, "", "code")
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+	if !h.GetTableById(id).IsPrivate() {
+		t.Error("the table should be private once it is listed")
+	}
+}
@@ -0,0 +1,125 @@
+package types
+
+import (
+	"sort"
+	"sync"
+)
+
+// an entry of the leaderboard
+type RankEntry struct {
+	Uid   int
+	Score float64
+}
+
+// a leaderboard sorted by the score, the higher the better
+// the same score is ranked by uid, the earlier registered user first
+// it is updated incrementally, the query does not sort all entries
+type Leaderboard struct {
+	entries []RankEntry
+	scores  map[int]float64
+	mu      sync.RWMutex
+}
+
+func NewLeaderboard() *Leaderboard {
+	return &Leaderboard{
+		entries: make([]RankEntry, 0),
+		scores:  make(map[int]float64),
+	}
+}
+
+func (lb *Leaderboard) less(a, b RankEntry) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.Uid < b.Uid
+}
+
+// position of the entry, or where it should be inserted
+func (lb *Leaderboard) search(e RankEntry) int {
+	return sort.Search(len(lb.entries), func(i int) bool {
+		return !lb.less(lb.entries[i], e)
+	})
+}
+
+func (lb *Leaderboard) set(uid int, score float64) {
+	if old, ok := lb.scores[uid]; ok {
+		if old == score {
+			return
+		}
+		i := lb.search(RankEntry{Uid: uid, Score: old})
+		lb.entries = append(lb.entries[:i], lb.entries[i+1:]...)
+	}
+	e := RankEntry{Uid: uid, Score: score}
+	i := lb.search(e)
+	lb.entries = append(lb.entries, RankEntry{})
+	copy(lb.entries[i+1:], lb.entries[i:])
+	lb.entries[i] = e
+	lb.scores[uid] = score
+}
+
+// set the score of the user
+func (lb *Leaderboard) Set(uid int, score float64) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.set(uid, score)
+}
+
+// add delta to the score of the user, return the new score
+func (lb *Leaderboard) Add(uid int, delta float64) float64 {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	score := lb.scores[uid] + delta
+	lb.set(uid, score)
+	return score
+}
+
+// remove the user from the leaderboard
+func (lb *Leaderboard) Remove(uid int) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	old, ok := lb.scores[uid]
+	if !ok {
+		return
+	}
+	i := lb.search(RankEntry{Uid: uid, Score: old})
+	lb.entries = append(lb.entries[:i], lb.entries[i+1:]...)
+	delete(lb.scores, uid)
+}
+
+// get the rank of the user, starts from 1
+// false if the user is not on the leaderboard
+func (lb *Leaderboard) Rank(uid int) (int, float64, bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	score, ok := lb.scores[uid]
+	if !ok {
+		return -1, 0, false
+	}
+	return lb.search(RankEntry{Uid: uid, Score: score}) + 1, score, true
+}
+
+// get the entries in [offset, offset+limit)
+func (lb *Leaderboard) Page(offset, limit int) []RankEntry {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(lb.entries) || limit <= 0 {
+		return []RankEntry{}
+	}
+	end := offset + limit
+	if end > len(lb.entries) {
+		end = len(lb.entries)
+	}
+	res := make([]RankEntry, end-offset)
+	copy(res, lb.entries[offset:end])
+	return res
+}
+
+// number of users on the leaderboard
+func (lb *Leaderboard) Len() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return len(lb.entries)
+}
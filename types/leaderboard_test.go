@@ -0,0 +1,41 @@
+package types
+
+import "testing"
+
+func Test_Leaderboard(t *testing.T) {
+	lb := NewLeaderboard()
+	lb.Set(1, 10)
+	lb.Set(2, 30)
+	lb.Set(3, 20)
+	lb.Set(4, 20)
+
+	for uid, want := range map[int]int{2: 1, 3: 2, 4: 3, 1: 4} {
+		if rank, _, ok := lb.Rank(uid); !ok || rank != want {
+			t.Errorf("rank of %d should be %d, but %d", uid, want, rank)
+		}
+	}
+
+	if score := lb.Add(1, 25); score != 35 {
+		t.Errorf("score of 1 should be 35, but %v", score)
+	}
+	if rank, _, _ := lb.Rank(1); rank != 1 {
+		t.Errorf("1 should be the first, but %d", rank)
+	}
+	if page := lb.Page(1, 2); len(page) != 2 || page[0].Uid != 2 || page[1].Uid != 3 {
+		t.Errorf("the second page should be 2 and 3, but %v", page)
+	}
+	if page := lb.Page(10, 2); len(page) != 0 {
+		t.Errorf("page out of range should be empty, but %v", page)
+	}
+
+	lb.Remove(2)
+	if _, _, ok := lb.Rank(2); ok {
+		t.Error("2 should be removed")
+	}
+	if lb.Len() != 3 {
+		t.Errorf("there should be 3 users, but %d", lb.Len())
+	}
+	if rank, _, _ := lb.Rank(4); rank != 3 {
+		t.Errorf("rank of 4 should be 3, but %d", rank)
+	}
+}
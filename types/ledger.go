@@ -0,0 +1,268 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a double-entry ledger of the balances, the frozen bets and the energy
+// every movement is a journal entry, the postings of each asset sum to 0
+// the balances and the bets are in a currency, the currencies are assets
+
+// assets
+const (
+	AssetMBTC   = "mBTC"
+	AssetChips  = "chips" // play money given out by the house, can not be deposited or withdrawn
+	AssetEnergy = "energy"
+)
+
+// the currencies of the balances and the bets
+var Currencies = []string{AssetMBTC, AssetChips}
+
+func IsCurrency(asset string) bool {
+	for _, c := range Currencies {
+		if c == asset {
+			return true
+		}
+	}
+	return false
+}
+
+// accounts, the user accounts are suffixed with the uid, e.g. available:12
+const (
+	AccountAvailable = "available"
+	AccountFrozen    = "frozen"
+	AccountEnergy    = "energy"
+	AccountHouse     = "house"  // the house contribution, the prize pools and the energy issued
+	AccountFees      = "fees"   // the fees charged by the house
+	AccountWallet    = "wallet" // the external bitcoin wallet, negative by the deposits held
+)
+
+var (
+	ErrEmptyEntry      = fmt.Errorf("记账分录不能为空")
+	ErrUnbalancedEntry = fmt.Errorf("记账分录借贷不平衡")
+	ErrInvalidAccount  = fmt.Errorf("记账科目错误")
+	ErrOverdraft       = fmt.Errorf("余额不足")
+)
+
+func UserAccount(kind string, uid int) string { return kind + ":" + strconv.Itoa(uid) }
+
+// parse the user account, ok is false if it is not a user account
+func ParseUserAccount(account string) (kind string, uid int, ok bool) {
+	i := strings.IndexByte(account, ':')
+	if i < 0 {
+		return "", 0, false
+	}
+	uid, err := strconv.Atoi(account[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return account[:i], uid, true
+}
+
+func isValidAccount(account string) bool {
+	if kind, _, ok := ParseUserAccount(account); ok {
+		return kind == AccountAvailable || kind == AccountFrozen || kind == AccountEnergy
+	}
+	return account == AccountHouse || account == AccountFees || account == AccountWallet
+}
+
+// the amount is added to the balance of the account
+type Posting struct {
+	Account string
+	Asset   string
+	Amount  int
+}
+
+func NewPosting(account, asset string, amount int) Posting {
+	return Posting{Account: account, Asset: asset, Amount: amount}
+}
+
+// move the amount of the asset from one account to another
+func Transfer(from, to, asset string, amount int) []Posting {
+	return []Posting{{from, asset, -amount}, {to, asset, amount}}
+}
+
+type JournalEntry struct {
+	Id       int
+	Kind     string
+	Ref      string // the table, the tournament or the txid of the movement
+	Postings []Posting
+	Created  int64
+}
+
+// the postings of 0 are dropped, the rest should be balanced
+func NewJournalEntry(kind, ref string, postings ...Posting) (*JournalEntry, error) {
+	ps := make([]Posting, 0, len(postings))
+	sums := make(map[string]int)
+	for _, p := range postings {
+		if !isValidAccount(p.Account) {
+			return nil, ErrInvalidAccount
+		}
+		if p.Amount == 0 {
+			continue
+		}
+		sums[p.Asset] += p.Amount
+		ps = append(ps, p)
+	}
+	if len(ps) == 0 {
+		return nil, ErrEmptyEntry
+	}
+	for _, sum := range sums {
+		if sum != 0 {
+			return nil, ErrUnbalancedEntry
+		}
+	}
+	return &JournalEntry{Kind: kind, Ref: ref, Postings: ps, Created: time.Now().Unix()}, nil
+}
+
+// the kind of the user account in the asset
+type UserBalance struct{ Kind, Asset string }
+
+// the user balances mirrored by the user fields
+var userBalances = map[UserBalance]string{
+	{AccountAvailable, AssetMBTC}:  UF_Balance,
+	{AccountFrozen, AssetMBTC}:     UF_Freezed,
+	{AccountAvailable, AssetChips}: UF_Chips,
+	{AccountFrozen, AssetChips}:    UF_FreezedChips,
+	{AccountEnergy, AssetEnergy}:   UF_Energy,
+}
+
+// the user field mirroring the balance, false if it is not mirrored
+func (ub UserBalance) Field() (string, bool) {
+	f, ok := userBalances[ub]
+	return f, ok
+}
+
+// the movements of the user by the entry
+func (e *JournalEntry) UserDeltas() map[int]map[UserBalance]int {
+	res := make(map[int]map[UserBalance]int)
+	for _, p := range e.Postings {
+		kind, uid, ok := ParseUserAccount(p.Account)
+		if !ok {
+			continue
+		}
+		if res[uid] == nil {
+			res[uid] = make(map[UserBalance]int)
+		}
+		res[uid][UserBalance{kind, p.Asset}] += p.Amount
+	}
+	return res
+}
+
+type ledgerKey struct{ account, asset string }
+
+// the balances of the accounts
+// the user accounts can not be overdrawn, the house, fees and wallet can
+type Ledger struct {
+	balances map[ledgerKey]int
+	mu       sync.RWMutex
+}
+
+func NewLedger() *Ledger { return &Ledger{balances: make(map[ledgerKey]int)} }
+
+// set the balance of the account, loaded from the database
+func (l *Ledger) Load(account, asset string, balance int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.balances[ledgerKey{account, asset}] = balance
+}
+
+func (l *Ledger) Balance(account, asset string) int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.balances[ledgerKey{account, asset}]
+}
+
+// is there any balance in the ledger
+func (l *Ledger) IsEmpty() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.balances) == 0
+}
+
+// post the entries, all or none
+func (l *Ledger) Post(entries ...*JournalEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	after := make(map[ledgerKey]int)
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			k := ledgerKey{p.Account, p.Asset}
+			b, ok := after[k]
+			if !ok {
+				b = l.balances[k]
+			}
+			after[k] = b + p.Amount
+		}
+	}
+	for k, b := range after {
+		if _, _, ok := ParseUserAccount(k.account); ok && b < 0 {
+			return ErrOverdraft
+		}
+	}
+	for k, b := range after {
+		l.balances[k] = b
+	}
+	return nil
+}
+
+// the balance of the cache differs from the ledger
+type Mismatch struct {
+	Uid     int
+	Account string
+	Asset   string
+	Cached  int
+	Ledger  int
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("user %d %s %s: cached %d, ledger %d", m.Uid, m.Account, m.Asset, m.Cached, m.Ledger)
+}
+
+// check the cached balances of the users against the ledger
+// the accounts in the ledger of the users not cached are mismatches too
+func (l *Ledger) Reconcile(us []*User) []Mismatch {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	res := make([]Mismatch, 0)
+	checked := make(map[ledgerKey]bool)
+	check := func(uid int, kind, asset string, cached int) {
+		k := ledgerKey{UserAccount(kind, uid), asset}
+		checked[k] = true
+		if b := l.balances[k]; b != cached {
+			res = append(res, Mismatch{uid, kind, asset, cached, b})
+		}
+	}
+	for _, u := range us {
+		uid := u.GetUid()
+		for ub := range userBalances {
+			check(uid, ub.Kind, ub.Asset, u.GetUserBalance(ub))
+		}
+	}
+	for k, b := range l.balances {
+		if kind, uid, ok := ParseUserAccount(k.account); ok && !checked[k] && b != 0 {
+			res = append(res, Mismatch{uid, kind, k.asset, 0, b})
+		}
+	}
+	sort.Sort(byUidAccount(res))
+	return res
+}
+
+type byUidAccount []Mismatch
+
+func (ms byUidAccount) Len() int      { return len(ms) }
+func (ms byUidAccount) Swap(i, j int) { ms[i], ms[j] = ms[j], ms[i] }
+func (ms byUidAccount) Less(i, j int) bool {
+	if ms[i].Uid != ms[j].Uid {
+		return ms[i].Uid < ms[j].Uid
+	}
+	if ms[i].Account != ms[j].Account {
+		return ms[i].Account < ms[j].Account
+	}
+	return ms[i].Asset < ms[j].Asset
+}
@@ -0,0 +1,120 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ParseUserAccount(t *testing.T) {
+	if kind, uid, ok := ParseUserAccount(UserAccount(AccountFrozen, 12)); !ok || kind != AccountFrozen || uid != 12 {
+		t.Errorf("should be frozen of 12, but %v %v %v", kind, uid, ok)
+	}
+	if _, _, ok := ParseUserAccount(AccountHouse); ok {
+		t.Error("the house is not a user account")
+	}
+}
+
+func Test_NewJournalEntry(t *testing.T) {
+	if _, err := NewJournalEntry("bet", "1", Posting{"available:1", AssetMBTC, -10}, Posting{"frozen:1", AssetMBTC, 9}); err != ErrUnbalancedEntry {
+		t.Errorf("the entry should be unbalanced, but %v", err)
+	}
+	// balanced by the asset
+	if _, err := NewJournalEntry("energy", "1", Posting{"available:1", AssetMBTC, -10}, Posting{"energy:1", AssetEnergy, 10}); err != ErrUnbalancedEntry {
+		t.Errorf("the entry should be unbalanced by the asset, but %v", err)
+	}
+	if _, err := NewJournalEntry("bet", "1", Transfer("pocket:1", "frozen:1", AssetMBTC, 10)...); err != ErrInvalidAccount {
+		t.Errorf("the account should be invalid, but %v", err)
+	}
+	if _, err := NewJournalEntry("bet", "1", Transfer("available:1", "frozen:1", AssetMBTC, 0)...); err != ErrEmptyEntry {
+		t.Errorf("the entry should be empty, but %v", err)
+	}
+	e, err := NewJournalEntry("settle", "1",
+		Posting{"frozen:1", AssetMBTC, -10}, Posting{"frozen:2", AssetMBTC, -10}, Posting{"available:1", AssetMBTC, 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deltas := e.UserDeltas()
+	if deltas[1][UserBalance{AccountFrozen, AssetMBTC}] != -10 || deltas[1][UserBalance{AccountAvailable, AssetMBTC}] != 20 ||
+		deltas[2][UserBalance{AccountFrozen, AssetMBTC}] != -10 {
+		t.Errorf("the deltas are wrong: %v", deltas)
+	}
+}
+
+func Test_Ledger(t *testing.T) {
+	l := NewLedger()
+	if !l.IsEmpty() {
+		t.Error("the new ledger should be empty")
+	}
+	deposit, _ := NewJournalEntry("deposit", "tx", Transfer(AccountWallet, "available:1", AssetMBTC, 100)...)
+	if err := l.Post(deposit); err != nil {
+		t.Fatal(err)
+	}
+	if l.Balance(AccountWallet, AssetMBTC) != -100 || l.Balance("available:1", AssetMBTC) != 100 {
+		t.Errorf("the wallet should be -100 and user 100, but %d and %d", l.Balance(AccountWallet, AssetMBTC), l.Balance("available:1", AssetMBTC))
+	}
+	// all or none
+	freeze, _ := NewJournalEntry("bet", "1", Transfer("available:1", "frozen:1", AssetMBTC, 60)...)
+	if err := l.Post(freeze, freeze); err != ErrOverdraft {
+		t.Errorf("the user should be overdrawn, but %v", err)
+	}
+	if l.Balance("available:1", AssetMBTC) != 100 || l.Balance("frozen:1", AssetMBTC) != 0 {
+		t.Error("nothing should be posted")
+	}
+	if err := l.Post(freeze); err != nil {
+		t.Fatal(err)
+	}
+
+	u := NewUser(1, "", "", "a", "")
+	u.Update(NewUpdateInt(UF_Balance, 40), NewUpdateInt(UF_Freezed, 50))
+	ms := l.Reconcile([]*User{u})
+	if len(ms) != 1 || ms[0].Account != AccountFrozen || ms[0].Cached != 50 || ms[0].Ledger != 60 {
+		t.Errorf("the frozen should mismatch, but %v", ms)
+	}
+	// the user not cached
+	l.Load("energy:2", AssetEnergy, 10)
+	if ms := l.Reconcile([]*User{u}); len(ms) != 2 || ms[1].Uid != 2 || ms[1].Ledger != 10 {
+		t.Errorf("the energy of 2 should mismatch, but %v", ms)
+	}
+}
+
+func Test_LedgerCurrencies(t *testing.T) {
+	l := NewLedger()
+	// the chips and the mBTC of the same account are apart
+	e, _ := NewJournalEntry("giveout", "1", Transfer(AccountHouse, "available:1", AssetChips, 100)...)
+	if err := l.Post(e); err != nil {
+		t.Fatal(err)
+	}
+	bet, _ := NewJournalEntry("bet", "1", Transfer("available:1", "frozen:1", AssetMBTC, 10)...)
+	if err := l.Post(bet); err != ErrOverdraft {
+		t.Errorf("the chips can not pay the mBTC bet, but %v", err)
+	}
+	deltas := e.UserDeltas()
+	if deltas[1][UserBalance{AccountAvailable, AssetChips}] != 100 || deltas[1][UserBalance{AccountAvailable, AssetMBTC}] != 0 {
+		t.Errorf("the deltas should be in chips, but %v", deltas)
+	}
+	u := NewUser(1, "", "", "a", "")
+	u.Update(NewUpdateInt(UF_Chips, 90))
+	if ms := l.Reconcile([]*User{u}); len(ms) != 1 || ms[0].Asset != AssetChips || ms[0].Cached != 90 || ms[0].Ledger != 100 {
+		t.Errorf("the chips should mismatch, but %v", ms)
+	}
+	if !IsCurrency(AssetChips) || IsCurrency(AssetEnergy) {
+		t.Error("the chips are a currency, the energy is not")
+	}
+}
+
+func Test_SqlGeneratorUpdateLedgerFields(t *testing.T) {
+	u := NewUser(1, "hello@world.com", "pass", "hello", "addr")
+	u.Update(NewUpdateInt(UF_Balance, 100), NewUpdateInt(UF_Win, 3))
+	sql, args := u.SqlGeneratorUpdate()
+	if strings.Contains(sql, "Balance = ?") || strings.Contains(sql, "Energy = ?") {
+		t.Errorf("the ledger fields should not be updated: %s", sql)
+	}
+	if !strings.Contains(sql, "Win = ?") {
+		t.Errorf("the win should be updated: %s", sql)
+	}
+	for _, arg := range args {
+		if arg == 100 {
+			t.Errorf("the balance should be inserted as 0: %v", args)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package types
+
+import "encoding/json"
+
+// stats of a game, the game server reports it with the result
+type GameStats struct {
+	WinnerKo    int `json:"winner_ko"`
+	LoserKo     int `json:"loser_ko"`
+	WinnerLines int `json:"winner_lines"`
+	LoserLines  int `json:"loser_lines"`
+	Duration    int `json:"duration"` // in seconds
+	EndReason   int `json:"end_reason"`
+	// events of the players, for the achievements
+	WinnerEvents MatchEvents `json:"winner_events"`
+	LoserEvents  MatchEvents `json:"loser_events"`
+}
+
+// events of a player in a game
+type MatchEvents struct {
+	MaxCombo      int `json:"max_combo"`
+	PerfectClears int `json:"perfect_clears"`
+	BombHits      int `json:"bomb_hits"`
+}
+
+// encode the stats for the rpc
+func (gs GameStats) String() string {
+	b, _ := json.Marshal(gs)
+	return string(b)
+}
+
+// decode the stats from the rpc
+func ParseGameStats(s string) (GameStats, error) {
+	var gs GameStats
+	err := json.Unmarshal([]byte(s), &gs)
+	return gs, err
+}
@@ -0,0 +1,114 @@
+package types
+
+import (
+	"fmt"
+	"sync"
+)
+
+// the side a spectator predicts to win
+const (
+	PredictSide1p = iota
+	PredictSide2p
+)
+
+var (
+	ErrInvalidSide      = fmt.Errorf("只能预测 1P 或者 2P 获胜")
+	ErrInvalidStake     = fmt.Errorf("预测金额必须大于 0")
+	ErrPredictionClosed = fmt.Errorf("预测已经截止")
+	ErrOtherSide        = fmt.Errorf("你已经预测了另一方获胜")
+)
+
+// a pari-mutuel prediction pool of a game
+// the stakes on the losing side, less the house fee, are shared by the winning side
+type PredictionPool struct {
+	stakes [2]map[int]int // side -> uid -> stake
+	closed bool
+	mu     sync.Mutex
+}
+
+func NewPredictionPool() *PredictionPool {
+	return &PredictionPool{stakes: [2]map[int]int{make(map[int]int), make(map[int]int)}}
+}
+
+// place a stake on the side, the stakes on the same side are added up
+func (p *PredictionPool) Place(uid, side, stake int) error {
+	if side != PredictSide1p && side != PredictSide2p {
+		return ErrInvalidSide
+	}
+	if stake <= 0 {
+		return ErrInvalidStake
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrPredictionClosed
+	}
+	if _, ok := p.stakes[1-side][uid]; ok {
+		return ErrOtherSide
+	}
+	p.stakes[side][uid] += stake
+	return nil
+}
+
+// close the pool, no more stakes are accepted
+func (p *PredictionPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
+
+// total stakes on both sides
+func (p *PredictionPool) Totals() [2]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return [2]int{sumStakes(p.stakes[0]), sumStakes(p.stakes[1])}
+}
+
+func sumStakes(stakes map[int]int) int {
+	var total int
+	for _, s := range stakes {
+		total += s
+	}
+	return total
+}
+
+// get the stakes of all users, uid -> stake
+func (p *PredictionPool) Stakes() map[int]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make(map[int]int)
+	for _, stakes := range p.stakes {
+		for uid, s := range stakes {
+			res[uid] = s
+		}
+	}
+	return res
+}
+
+// settle the pool, return the payout of every user and the house fee
+// the pool is refunded without fee if nobody predicts one of the sides
+// the remainder of the integer division goes to the house
+func (p *PredictionPool) Settle(winner, feePercent int) (map[int]int, int) {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	totals := p.Totals()
+	if totals[0] == 0 || totals[1] == 0 {
+		return p.Stakes(), 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pot := totals[0] + totals[1]
+	prize := pot - pot*feePercent/100
+	payouts := make(map[int]int)
+	paid := 0
+	for uid, s := range p.stakes[winner] {
+		payouts[uid] = prize * s / totals[winner]
+		paid += payouts[uid]
+	}
+	for uid := range p.stakes[1-winner] {
+		payouts[uid] = 0
+	}
+	return payouts, pot - paid
+}
@@ -0,0 +1,41 @@
+package types
+
+import "testing"
+
+func Test_PredictionPool(t *testing.T) {
+	p := NewPredictionPool()
+	if err := p.Place(1, 2, 10); err != ErrInvalidSide {
+		t.Errorf("side 2 is invalid, but %v", err)
+	}
+	if err := p.Place(1, PredictSide1p, 0); err != ErrInvalidStake {
+		t.Errorf("stake 0 is invalid, but %v", err)
+	}
+	p.Place(1, PredictSide1p, 30)
+	p.Place(1, PredictSide1p, 30)
+	p.Place(2, PredictSide1p, 40)
+	p.Place(3, PredictSide2p, 100)
+	if err := p.Place(3, PredictSide1p, 10); err != ErrOtherSide {
+		t.Errorf("3 predicts 2p, but %v", err)
+	}
+	if totals := p.Totals(); totals != [2]int{100, 100} {
+		t.Errorf("totals should be 100 and 100, but %v", totals)
+	}
+
+	payouts, fee := p.Settle(PredictSide1p, 5)
+	if payouts[1] != 114 || payouts[2] != 76 || payouts[3] != 0 {
+		t.Errorf("payouts should be 114, 76 and 0, but %v", payouts)
+	}
+	if fee != 10 {
+		t.Errorf("fee should be 10, but %d", fee)
+	}
+	if err := p.Place(4, PredictSide1p, 10); err != ErrPredictionClosed {
+		t.Errorf("the pool is settled, but %v", err)
+	}
+
+	// refund if nobody predicts the other side
+	p = NewPredictionPool()
+	p.Place(1, PredictSide2p, 50)
+	if payouts, fee := p.Settle(PredictSide1p, 5); payouts[1] != 50 || fee != 0 {
+		t.Errorf("the stake should be refunded, but %v, fee %d", payouts, fee)
+	}
+}
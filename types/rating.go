@@ -0,0 +1,200 @@
+package types
+
+import (
+	"math"
+	"time"
+)
+
+// glicko-2 rating system
+// see http://www.glicko.net/glicko/glicko2.pdf
+const (
+	DefaultRating     = 1500.0
+	DefaultRatingDev  = 350.0
+	DefaultVolatility = 0.06
+	// convert between glicko and glicko-2 scale
+	glickoScale = 173.7178
+	// constrains the change in volatility over time
+	glickoTau     = 0.5
+	glickoEpsilon = 0.000001
+)
+
+// rating of a player
+type Rating struct {
+	Rating, Dev, Vol float64
+}
+
+func NewRating() Rating {
+	return Rating{Rating: DefaultRating, Dev: DefaultRatingDev, Vol: DefaultVolatility}
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func glickoE(mu, muj, phij float64) float64 {
+	return 1 / (1 + math.Exp(-glickoG(phij)*(mu-muj)))
+}
+
+// rate the player after a rating period
+// scores are 1 for win, 0 for lose, 0.5 for draw
+func (r Rating) rate(opponents []Rating, scores []float64) Rating {
+	mu := (r.Rating - DefaultRating) / glickoScale
+	phi := r.Dev / glickoScale
+	if len(opponents) == 0 {
+		return r.decay()
+	}
+
+	// estimated variance and improvement
+	var v, delta float64
+	for i, o := range opponents {
+		muj, phij := (o.Rating-DefaultRating)/glickoScale, o.Dev/glickoScale
+		g, e := glickoG(phij), glickoE(mu, muj, phij)
+		v += g * g * e * (1 - e)
+		delta += g * (scores[i] - e)
+	}
+	v = 1 / v
+	delta *= v
+
+	// new volatility by the illinois algorithm
+	a := math.Log(r.Vol * r.Vol)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	vol := math.Exp(A / 2)
+
+	// new deviation and rating
+	phiStar := math.Sqrt(phi*phi + vol*vol)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * delta / v
+	return Rating{
+		Rating: mu*glickoScale + DefaultRating,
+		Dev:    math.Min(phi*glickoScale, DefaultRatingDev),
+		Vol:    vol,
+	}
+}
+
+// a rating period without games, the deviation grows
+func (r Rating) decay() Rating {
+	phi := r.Dev / glickoScale
+	phi = math.Sqrt(phi*phi + r.Vol*r.Vol)
+	r.Dev = math.Min(phi*glickoScale, DefaultRatingDev)
+	return r
+}
+
+// get the rating of the user
+func (u *User) GetRating() Rating {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.rating()
+}
+
+// get the timestamp of the last rated game
+func (u *User) GetLastRated() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.LastRated
+}
+
+func (u *User) rating() Rating {
+	return Rating{Rating: u.Rating, Dev: u.RatingDev, Vol: u.Volatility}
+}
+
+func (u *User) setRating(r Rating) {
+	u.Rating, u.RatingDev, u.Volatility = r.Rating, r.Dev, r.Vol
+}
+
+// update the ratings of both players after a rated game
+// both users are locked during the update, nobody could see a half rated game
+func RateGame(winner, loser *User) {
+	first, second := winner, loser
+	if first.Uid > second.Uid {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	wr, lr := winner.rating(), loser.rating()
+	winner.setRating(wr.rate([]Rating{lr}, []float64{1}))
+	loser.setRating(lr.rate([]Rating{wr}, []float64{0}))
+	tNow := int(time.Now().Unix())
+	winner.LastRated, loser.LastRated = tNow, tNow
+	winner.Updated, loser.Updated = tNow, tNow
+}
+
+// the user is not rated since the timestamp, the deviation grows for a rating period
+// return true if the rating changes
+func (u *User) DecayRating(since int) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.LastRated >= since || u.RatingDev >= DefaultRatingDev {
+		return false
+	}
+	u.setRating(u.rating().decay())
+	return true
+}
+
+// decay the ratings of the users who are not rated since the timestamp
+// return the users whose rating changes
+func (us *Users) DecayRatings(since int) []*User {
+	decayed := make([]*User, 0)
+	for _, u := range us.GetAllUsers() {
+		if u.DecayRating(since) {
+			decayed = append(decayed, u)
+		}
+	}
+	return decayed
+}
+
+// pull the rating toward the default at a season rollover
+// the deviation is raised to at least minDev, the placement matches move the rating fast
+func (r Rating) SoftReset(carry, minDev float64) Rating {
+	r.Rating = DefaultRating + (r.Rating-DefaultRating)*carry
+	r.Dev = math.Min(math.Max(r.Dev, minDev), DefaultRatingDev)
+	return r
+}
+
+// soft reset the ratings of the users who ever played a rated game
+// return the users whose rating changes
+func (us *Users) SoftResetRatings(carry, minDev float64) []*User {
+	reset := make([]*User, 0)
+	for _, u := range us.GetAllUsers() {
+		if func() bool {
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			if u.LastRated == 0 {
+				return false
+			}
+			u.setRating(u.rating().SoftReset(carry, minDev))
+			return true
+		}() {
+			reset = append(reset, u)
+		}
+	}
+	return reset
+}
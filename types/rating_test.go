@@ -0,0 +1,63 @@
+package types
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_Rating(t *testing.T) {
+	// the example in the glicko-2 paper
+	r := Rating{Rating: 1500, Dev: 200, Vol: 0.06}
+	r = r.rate([]Rating{
+		{Rating: 1400, Dev: 30, Vol: 0.06},
+		{Rating: 1550, Dev: 100, Vol: 0.06},
+		{Rating: 1700, Dev: 300, Vol: 0.06},
+	}, []float64{1, 0, 0})
+	if math.Abs(r.Rating-1464.06) > 0.01 {
+		t.Errorf("the rating should be 1464.06, but %v", r.Rating)
+	}
+	if math.Abs(r.Dev-151.52) > 0.01 {
+		t.Errorf("the deviation should be 151.52, but %v", r.Dev)
+	}
+	if math.Abs(r.Vol-0.05999) > 0.00001 {
+		t.Errorf("the volatility should be 0.05999, but %v", r.Vol)
+	}
+
+	if d := (Rating{Rating: 1500, Dev: 50, Vol: 0.06}).decay(); d.Dev <= 50 {
+		t.Errorf("the deviation should grow, but %v", d.Dev)
+	}
+	if d := NewRating().decay(); d.Dev != DefaultRatingDev {
+		t.Errorf("the deviation should not exceed %v, but %v", DefaultRatingDev, d.Dev)
+	}
+}
+
+func Test_RateGame(t *testing.T) {
+	w := NewUser(1, "lol@163.com", "hi", "zoneT_T", "Lt1423")
+	l := NewUser(2, "sbchao@qq.com", "hi", "sb_chao", "Lt1323")
+	RateGame(w, l)
+	if w.GetRating().Rating <= DefaultRating || l.GetRating().Rating >= DefaultRating {
+		t.Errorf("the winner should gain and the loser should lose, but %v, %v", w.GetRating(), l.GetRating())
+	}
+	if w.GetRating().Dev >= DefaultRatingDev {
+		t.Errorf("the deviation should shrink, but %v", w.GetRating().Dev)
+	}
+	if w.DecayRating(w.LastRated) {
+		t.Error("the user is rated just now")
+	}
+	if !w.DecayRating(w.LastRated + 1) {
+		t.Error("the user should decay")
+	}
+}
+
+func Test_SoftReset(t *testing.T) {
+	r := Rating{Rating: 2100, Dev: 60, Vol: 0.06}.SoftReset(0.5, 150)
+	if r.Rating != 1800 {
+		t.Errorf("the rating should be pulled to 1800, but %v", r.Rating)
+	}
+	if r.Dev != 150 {
+		t.Errorf("the deviation should be raised to 150, but %v", r.Dev)
+	}
+	if r = (Rating{Rating: 1200, Dev: 340, Vol: 0.06}).SoftReset(0.5, 150); r.Rating != 1350 || r.Dev != 340 {
+		t.Errorf("the rating should be 1350 with deviation 340, but %v", r)
+	}
+}
@@ -0,0 +1,82 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RecurDaily  = "daily"
+	RecurWeekly = "weekly"
+)
+
+var ErrInvalidRecurrence = fmt.Errorf("周期格式错误, 例如: daily 20:00 +8 或者 weekly sat 20:00 +8")
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// the recurrence of a scheduled event, e.g. daily at 20:00 UTC+8
+type Recurrence struct {
+	Period       string
+	Weekday      time.Weekday // only for weekly
+	Hour, Minute int
+	TzOffset     int // in hours
+}
+
+// parse the recurrence like "daily 20:00 +8" or "weekly sat 20:00 +8"
+func ParseRecurrence(s string) (r Recurrence, err error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 {
+		return r, ErrInvalidRecurrence
+	}
+	r.Period = fields[0]
+	switch {
+	case r.Period == RecurDaily && len(fields) == 3:
+		fields = fields[1:]
+	case r.Period == RecurWeekly && len(fields) == 4:
+		wd, ok := weekdays[fields[1]]
+		if !ok {
+			return r, ErrInvalidRecurrence
+		}
+		r.Weekday = wd
+		fields = fields[2:]
+	default:
+		return r, ErrInvalidRecurrence
+	}
+	at, err := time.Parse("15:04", fields[0])
+	if err != nil {
+		return r, ErrInvalidRecurrence
+	}
+	r.Hour, r.Minute = at.Hour(), at.Minute()
+	if r.TzOffset, err = strconv.Atoi(fields[1]); err != nil || r.TzOffset < -12 || r.TzOffset > 14 {
+		return r, ErrInvalidRecurrence
+	}
+	return r, nil
+}
+
+func (r Recurrence) String() string {
+	at := fmt.Sprintf("%02d:%02d %+d", r.Hour, r.Minute, r.TzOffset)
+	if r.Period == RecurWeekly {
+		return fmt.Sprintf("%s %s %s", r.Period, strings.ToLower(r.Weekday.String()[:3]), at)
+	}
+	return fmt.Sprintf("%s %s", r.Period, at)
+}
+
+// the first occurrence after the time
+func (r Recurrence) Next(after time.Time) time.Time {
+	local := after.In(time.FixedZone("", r.TzOffset*3600))
+	next := time.Date(local.Year(), local.Month(), local.Day(), r.Hour, r.Minute, 0, 0, local.Location())
+	for !next.After(after) || (r.Period == RecurWeekly && next.Weekday() != r.Weekday) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,44 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Recurrence(t *testing.T) {
+	for _, s := range []string{"", "daily", "daily 25:00 +8", "weekly 20:00 +8", "weekly abc 20:00 +8", "daily 20:00 +20"} {
+		if _, err := ParseRecurrence(s); err != ErrInvalidRecurrence {
+			t.Errorf("%q should be invalid, but %v", s, err)
+		}
+	}
+
+	r, err := ParseRecurrence("daily 20:00 +8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.String() != "daily 20:00 +8" {
+		t.Errorf("the recurrence should be daily 20:00 +8, but %s", r)
+	}
+	// 11:00 UTC is 19:00 UTC+8
+	after := time.Date(2014, 5, 1, 11, 0, 0, 0, time.UTC)
+	if next := r.Next(after); !next.Equal(time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("the next should be 12:00 UTC on the same day, but %v", next.UTC())
+	}
+	// exactly at the time, the next is tomorrow
+	after = time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	if next := r.Next(after); !next.Equal(time.Date(2014, 5, 2, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("the next should be 12:00 UTC tomorrow, but %v", next.UTC())
+	}
+
+	r, err = ParseRecurrence("Weekly SAT 20:00 +8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.String() != "weekly sat 20:00 +8" {
+		t.Errorf("the recurrence should be weekly sat 20:00 +8, but %s", r)
+	}
+	// 2014-05-01 is thursday
+	if next := r.Next(after); !next.Equal(time.Date(2014, 5, 3, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("the next should be saturday 12:00 UTC, but %v", next.UTC())
+	}
+}
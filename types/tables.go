@@ -65,17 +65,63 @@ func (ts *Tables) ReleaseExpireTable(tid int) {
 	delete(ts.expires, tid)
 }
 
+// for hprose, only rated or unrated tables
+func (ts *Tables) WrapRated(rated bool) []map[string]interface{} {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	res := make([]map[string]interface{}, 0)
+	for _, t := range ts.Tables {
+		if t.IsRated() == rated && !t.IsPrivate() {
+			res = append(res, t.WrapTable())
+		}
+	}
+	return res
+}
+
 // for hprose
 func (ts Tables) Wrap() []map[string]interface{} {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 	res := make([]map[string]interface{}, 0)
 	for _, t := range ts.Tables {
-		res = append(res, t.WrapTable())
+		if !t.IsPrivate() {
+			res = append(res, t.WrapTable())
+		}
 	}
 	return res
 }
 
+// find the table where the user plays or observes
+func (ts *Tables) FindUser(uid int) (tid int, isOb, ok bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	for id, t := range ts.Tables {
+		for _, p := range t.GetPlayers() {
+			if p == uid {
+				return id, false, true
+			}
+		}
+		for _, o := range t.GetObservers() {
+			if o == uid {
+				return id, true, true
+			}
+		}
+	}
+	return -1, false, false
+}
+
+// get the private table by the invite code
+func (ts *Tables) GetTableByInviteCode(code string) *Table {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	for _, t := range ts.Tables {
+		if t.IsInviteCode(code) {
+			return t
+		}
+	}
+	return nil
+}
+
 func (ts Tables) MarshalJSON() ([]byte, error) {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
@@ -101,6 +147,17 @@ func (ts *Tables) GetAllConnsInAllTables() []*net.TCPConn {
 	return conns
 }
 
+// get all tables
+func (ts *Tables) GetAllTables() []*Table {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	res := make([]*Table, 0, len(ts.Tables))
+	for _, t := range ts.Tables {
+		res = append(res, t)
+	}
+	return res
+}
+
 // check if the Table exist
 func (ts *Tables) IsTableExist(id int) bool {
 	return ts.GetTableById(id) != nil
@@ -122,12 +179,23 @@ func (ts *Tables) DelTable(id int) {
 
 // create a new Table
 func (ts *Tables) NewTable(id int, title, host string, bet int) error {
-	if ts.IsTableExist(id) {
-		return ErrExisted
+	return ts.NewTableWith(id, title, host, bet, nil)
+}
+
+// create the table set up by the function before it is listed and joinable
+func (ts *Tables) NewTableWith(id int, title, host string, bet int, setup func(*Table) error) error {
+	t := newTable(id, title, host, bet)
+	if setup != nil {
+		if err := setup(t); err != nil {
+			return err
+		}
 	}
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
-	ts.Tables[id] = newTable(id, title, host, bet)
+	if _, ok := ts.Tables[id]; ok {
+		return ErrExisted
+	}
+	ts.Tables[id] = t
 	return nil
 }
 
@@ -158,11 +226,13 @@ const (
 
 // basic table info
 type tableInfo struct {
-	TId    int    `json:"table_id"`
-	TTitle string `json:"table_title"`
-	TStat  string `json:"table_status"`
-	TBet   int    `json:"table_bet"`
-	THost  string `json:"table_host"`
+	TId       int    `json:"table_id"`
+	TTitle    string `json:"table_title"`
+	TStat     string `json:"table_status"`
+	TBet      int    `json:"table_bet"`
+	TCurrency string `json:"table_currency"`
+	THost     string `json:"table_host"`
+	TRated    bool   `json:"table_rated"`
 }
 
 func (ti tableInfo) IsStart() bool {
@@ -181,6 +251,11 @@ func (ti tableInfo) IsGamble() bool {
 	return ti.TBet > 0
 }
 
+// the games of rated table change the ratings of the players
+func (ti tableInfo) IsRated() bool {
+	return ti.TRated
+}
+
 const (
 	zoneHeight            = 20
 	zoneWidth             = 10
@@ -214,16 +289,60 @@ type Table struct {
 	RemainedSecondsChan chan int
 	// game over
 	GameoverChan chan int
+	// best of n series, the bet is settled once the series is over
+	series
+	// private table is hidden from the hall
+	access
+}
+
+var (
+	ErrPrivateTable = fmt.Errorf("这是私人桌子, 需要密码或者邀请才能加入")
+	ErrNotPrivate   = fmt.Errorf("公开的桌子无需邀请")
+	ErrCantInvite   = fmt.Errorf("只有桌主和桌子上的玩家才能邀请")
+)
+
+// who can enter the table
+type access struct {
+	private    bool
+	owner      int
+	password   string
+	inviteCode string
+	invited    map[int]bool
+}
+
+// valid length of a series
+var validBestOf = map[int]bool{1: true, 3: true, 5: true, 7: true}
+
+// check if the series could be best of n
+func IsValidBestOf(n int) bool {
+	return validBestOf[n]
+}
+
+var (
+	ErrInvalidBestOf  = fmt.Errorf("系列赛只能是 1, 3, 5 或者 7 局")
+	ErrSeriesNotOver  = fmt.Errorf("系列赛尚未结束, 无法发起再来一局")
+	ErrNoRematchOffer = fmt.Errorf("对手没有发起再来一局")
+)
+
+// series of games between the same players
+type series struct {
+	seriesId int
+	bestOf   int
+	players  [2]int // uid of the players when the series start
+	scores   [2]int
+	playing  bool
+	rematch  [2]bool
 }
 
 func newTable(id int, title, host string, bet int) *Table {
 	return &Table{
 		tableInfo: tableInfo{
-			TId:    id,
-			TTitle: title,
-			TStat:  statWaiting,
-			TBet:   bet,
-			THost:  host,
+			TId:       id,
+			TTitle:    title,
+			TStat:     statWaiting,
+			TBet:      bet,
+			TCurrency: AssetMBTC,
+			THost:     host,
 		},
 		obs:                 NewObs(),
 		startTime:           time.Now().Unix(),
@@ -231,6 +350,7 @@ func newTable(id int, title, host string, bet int) *Table {
 		timer:               timer.NewTimer(1000),
 		RemainedSecondsChan: make(chan int, 1<<3),
 		GameoverChan:        make(chan int, 1<<3),
+		series:              series{bestOf: 1, players: [2]int{-1, -1}},
 	}
 }
 
@@ -258,6 +378,7 @@ func (t *Table) WrapTable() map[string]interface{} {
 	defer t.mu.Unlock()
 	return map[string]interface{}{
 		"table_bet":      t.TBet,
+		"table_currency": t.TCurrency,
 		"table_id":       t.TId,
 		"table_host":     t.THost,
 		"table_status":   t.TStat,
@@ -267,6 +388,11 @@ func (t *Table) WrapTable() map[string]interface{} {
 		"table_1p_ready": t.ready1p,
 		"table_2p_ready": t.ready2p,
 		"table_obs":      t.obs.Wrap(),
+		"table_rated":    t.TRated,
+		"table_private":  t.private,
+		"table_best_of":  t.bestOf,
+		"table_score_1p": t.scoreOf(t._1p.GetUid()),
+		"table_score_2p": t.scoreOf(t._2p.GetUid()),
 	}
 }
 
@@ -365,6 +491,13 @@ func (t *Table) Start() {
 	t.TStat = statInGame
 }
 
+// seconds since the game starts
+func (t *Table) GetElapsed() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int(time.Now().Unix() - t.startTime)
+}
+
 // stop the game
 func (t *Table) Stop() {
 	t.mu.Lock()
@@ -428,6 +561,13 @@ func (t *Table) GetBet() int {
 	return t.TBet
 }
 
+// get the currency of the bet
+func (t *Table) GetCurrency() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.TCurrency
+}
+
 // get all users
 func (t *Table) GetAllUsers() []int {
 	t.mu.Lock()
@@ -466,6 +606,19 @@ func (t *Table) Get2pConn() *net.TCPConn {
 	return t._2p.GetConn()
 }
 
+// get the player by uid, nil if the user is not a player of the table
+func (t *Table) GetPlayer(uid int) *User {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch uid {
+	case t._1p.GetUid():
+		return t._1p
+	case t._2p.GetUid():
+		return t._2p
+	}
+	return nil
+}
+
 // get 1p uid
 func (t *Table) Get1pUid() int {
 	t.mu.Lock()
@@ -534,3 +687,219 @@ func (t *Table) GetGame2p() *tetris.Game {
 	defer t.mu.Unlock()
 	return t.g2p
 }
+
+// set the length of the series
+func (t *Table) SetBestOf(n int) error {
+	if !IsValidBestOf(n) {
+		return ErrInvalidBestOf
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bestOf = n
+	return nil
+}
+
+// get the length of the series
+func (t *Table) GetBestOf() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.bestOf
+}
+
+// start a new series with the players in the table
+func (t *Table) StartSeries() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seriesId++
+	t.players = [2]int{t._1p.GetUid(), t._2p.GetUid()}
+	t.scores = [2]int{0, 0}
+	t.rematch = [2]bool{false, false}
+	t.playing = true
+}
+
+// get the number of the series of the table, it increases on every start
+func (t *Table) GetSeriesId() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.seriesId
+}
+
+// check if a series is being played, the bet of the players is freezed
+func (t *Table) IsInSeries() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.playing
+}
+
+// the winner of a game gets a point
+// return true if the series is over
+func (t *Table) AddScore(winner int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, uid := range t.players {
+		if uid == winner {
+			t.scores[i]++
+			if t.scores[i]*2 > t.bestOf {
+				t.playing = false
+			}
+			return !t.playing
+		}
+	}
+	return false
+}
+
+// the player leaves during the series, the opponent wins the series
+// return the uid of the opponent, false if the player is not in a series
+func (t *Table) Forfeit(uid int) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.playing {
+		return -1, false
+	}
+	switch uid {
+	case t.players[0]:
+		t.playing = false
+		return t.players[1], true
+	case t.players[1]:
+		t.playing = false
+		return t.players[0], true
+	}
+	return -1, false
+}
+
+// get the opponent of the player in the series
+func (t *Table) GetSeriesOpponent(uid int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch uid {
+	case t.players[0]:
+		return t.players[1]
+	case t.players[1]:
+		return t.players[0]
+	}
+	return -1
+}
+
+// get the score of the player in the series
+func (t *Table) GetSeriesScore(uid int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.scoreOf(uid)
+}
+
+func (t *Table) scoreOf(uid int) int {
+	if uid < 0 {
+		return 0
+	}
+	for i, p := range t.players {
+		if p == uid {
+			return t.scores[i]
+		}
+	}
+	return 0
+}
+
+// offer a rematch after the series, or accept the offer of the opponent
+// return true if both players agree
+func (t *Table) OfferRematch(uid int) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.playing {
+		return false, ErrSeriesNotOver
+	}
+	switch uid {
+	case t._1p.GetUid():
+		t.rematch[0] = true
+	case t._2p.GetUid():
+		t.rematch[1] = true
+	default:
+		return false, ErrNotExist
+	}
+	return t.rematch[0] && t.rematch[1], nil
+}
+
+// check if the opponent of the player offered a rematch
+func (t *Table) IsRematchOffered(uid int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch uid {
+	case t._1p.GetUid():
+		return t.rematch[1]
+	case t._2p.GetUid():
+		return t.rematch[0]
+	}
+	return false
+}
+
+// decline the rematch, clear the offers
+func (t *Table) DeclineRematch() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rematch = [2]bool{false, false}
+}
+
+// set the currency of the bet
+func (t *Table) SetCurrency(currency string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.TCurrency = currency
+}
+
+// set the table rated or not
+func (t *Table) SetRated(rated bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.TRated = rated
+}
+
+// make the table private, it is entered by the password, the invite code or an invitation
+func (t *Table) SetPrivate(owner int, password, inviteCode string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.private = true
+	t.owner = owner
+	t.password = password
+	t.inviteCode = inviteCode
+	t.invited = map[int]bool{owner: true}
+}
+
+// check if the table is private
+func (t *Table) IsPrivate() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.private
+}
+
+// check if the user can enter the table with the secret, a password or an invite code
+func (t *Table) CanEnter(uid int, secret string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.private || t.invited[uid] {
+		return true
+	}
+	if secret == "" {
+		return false
+	}
+	return secret == t.password || secret == t.inviteCode
+}
+
+// check if the code is the invite code of the private table
+func (t *Table) IsInviteCode(code string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.private && code != "" && code == t.inviteCode
+}
+
+// invite the user, only the owner and the players could invite
+func (t *Table) Invite(from, uid int) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.private {
+		return "", ErrNotPrivate
+	}
+	if from != t.owner && from != t._1p.GetUid() && from != t._2p.GetUid() {
+		return "", ErrCantInvite
+	}
+	t.invited[uid] = true
+	return t.inviteCode, nil
+}
@@ -167,7 +167,7 @@ func (us *Users) GetByNickname(nickname string) *User {
 // set users in busy mode
 func (us *Users) SetBusy(uids ...int) {
 	us.bmu.Lock()
-	defer us.bmu.RUnlock()
+	defer us.bmu.Unlock()
 	t := time.Now().Unix()
 	for _, uid := range uids {
 		us.busyUsers[uid] = t
@@ -187,7 +187,7 @@ func (us *Users) SetFree(uids ...int) {
 func (us *Users) IsBusyUser(uid int) bool {
 	us.bmu.RLock()
 	defer us.bmu.RUnlock()
-	return us.busyUsers[uid] == 0
+	return us.busyUsers[uid] != 0
 }
 
 // interface for updating user information
@@ -295,6 +295,8 @@ const (
 	UF_Balance         = "Balance"
 	UF_Freezed         = "Freezed"
 	UF_Updated         = "Updated"
+	UF_Chips           = "Chips"
+	UF_FreezedChips    = "FreezedChips"
 )
 
 // initialize user field cache
@@ -306,32 +308,48 @@ func init() {
 		if val.Field(i).CanInterface() && typ.Field(i).Tag.Get("fixed") != "true" {
 			userFields[typ.Field(i).Name] = true
 		}
+		if typ.Field(i).Tag.Get("ledger") == "true" {
+			ledgerFields[typ.Field(i).Name] = true
+		}
 	}
 }
 
-var userFields = make(map[string]bool)
+var (
+	userFields = make(map[string]bool)
+	// the fields mirrored from the ledger, they are stored by the journal entries only
+	ledgerFields = make(map[string]bool)
+)
 
 func canUserFieldUpdate(field string) bool {
 	return userFields[field]
 }
 
 // fixed tag means it is not going to update the field by reflect method
+// ledger tag means the field is not stored by SqlGeneratorUpdate, the journal entries move it
 type User struct {
 	Uid      int `fixed:"true"`
 	Avatar   []byte
 	Email    string `fixed:"true"`
 	Password string
 	Nickname string `fixed:"true"`
-	Energy   int
+	Energy   int    `ledger:"true"`
 	Level    int
 	Win      int
 	Lose     int
 	Addr     string `fixed:"true"`
-	Balance  int
-	Freezed  int
+	Balance  int    `ledger:"true"`
+	Freezed  int    `ledger:"true"`
 	Updated  int
-	conn     *net.TCPConn
-	mu       sync.Mutex
+	// glicko-2 rating, level is only a badge for display
+	Rating     float64
+	RatingDev  float64
+	Volatility float64
+	LastRated  int
+	// the play chips, the balance and the freezed of the chips currency
+	Chips        int `ledger:"true"`
+	FreezedChips int `ledger:"true"`
+	conn         *net.TCPConn
+	mu           sync.Mutex
 }
 
 func (u User) String() string {
@@ -341,12 +359,15 @@ func (u User) String() string {
 
 func NewUser(uid int, email, password, nickname, addr string) *User {
 	return &User{
-		Uid:      uid,
-		Email:    email,
-		Password: password,
-		Nickname: nickname,
-		Addr:     addr,
-		Updated:  int(time.Now().Unix()),
+		Uid:        uid,
+		Email:      email,
+		Password:   password,
+		Nickname:   nickname,
+		Addr:       addr,
+		Updated:    int(time.Now().Unix()),
+		Rating:     DefaultRating,
+		RatingDev:  DefaultRatingDev,
+		Volatility: DefaultVolatility,
 	}
 }
 
@@ -362,6 +383,16 @@ func (u *User) GetUid() int {
 	return u.Uid
 }
 
+// get nickname, empty if the user is nil
+func (u *User) GetNickname() string {
+	if u == nil {
+		return ""
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.Nickname
+}
+
 // get current energy
 func (u *User) GetEnergy() int {
 	u.mu.Lock()
@@ -383,6 +414,39 @@ func (u *User) GetFreezed() int {
 	return u.Freezed
 }
 
+// get current chips
+func (u *User) GetChips() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.Chips
+}
+
+// get current freezed chips
+func (u *User) GetFreezedChips() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.FreezedChips
+}
+
+// get current balance of the currency
+func (u *User) GetBalanceOf(currency string) int {
+	if currency == AssetChips {
+		return u.GetChips()
+	}
+	return u.GetBalance()
+}
+
+// get the cached balance of the user account, 0 if it is not mirrored
+func (u *User) GetUserBalance(ub UserBalance) int {
+	f, ok := ub.Field()
+	if !ok {
+		return 0
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return int(reflect.Indirect(reflect.ValueOf(u)).FieldByName(f).Int())
+}
+
 // update user
 func (u *User) Update(upts ...UpdateInterface) error {
 	u.mu.Lock()
@@ -447,15 +511,18 @@ func (this User) MarshalJSON() ([]byte, error) {
 	this.mu.Lock()
 	defer this.mu.Unlock()
 	return json.Marshal(map[string]interface{}{
-		"id":       this.Uid,
-		"email":    this.Email,
-		"nickname": this.Nickname,
-		"level":    this.Level,
-		"win":      this.Win,
-		"lose":     this.Lose,
-		"addr":     this.Addr,
-		"balance":  this.Balance,
-		"updated":  this.Updated,
+		"id":         this.Uid,
+		"email":      this.Email,
+		"nickname":   this.Nickname,
+		"level":      this.Level,
+		"win":        this.Win,
+		"lose":       this.Lose,
+		"addr":       this.Addr,
+		"balance":    this.Balance,
+		"chips":      this.Chips,
+		"updated":    this.Updated,
+		"rating":     this.Rating,
+		"rating_dev": this.RatingDev,
 	})
 }
 
@@ -478,10 +545,15 @@ func (this *User) SqlGeneratorUpdate() (sql string, args []interface{}) {
 
 			l++
 			f := typ.Field(i).Name
+			sql += f
+			// a new user starts from 0, the journal entries move it
+			if ledgerFields[f] {
+				args = append(args, reflect.Zero(typ.Field(i).Type).Interface())
+				continue
+			}
 			if canUserFieldUpdate(f) {
 				updates = append(updates, f)
 			}
-			sql += f
 			args = append(args, val.Field(i).Interface())
 		}
 	}
@@ -516,6 +588,8 @@ func (this User) SqlGeneratorCreate() (sqls []string) {
 			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 				sql += "INT, "
+			case reflect.Float32, reflect.Float64:
+				sql += "DOUBLE, "
 			case reflect.String:
 				sql += "VARCHAR(255), "
 			case reflect.Slice:
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// word filter for chat messages
+// the words are matched case insensitively and replaced by asterisks
+type wordFilter struct {
+	words []string
+	mu    sync.RWMutex
+}
+
+func NewWordFilter(words ...string) *wordFilter {
+	wf := new(wordFilter)
+	wf.Set(words...)
+	return wf
+}
+
+// replace the words of the filter
+func (wf *wordFilter) Set(words ...string) {
+	ws := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			ws = append(ws, w)
+		}
+	}
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	wf.words = ws
+}
+
+// filter the text, return true if any word is replaced
+func (wf *wordFilter) Filter(text string) (string, bool) {
+	wf.mu.RLock()
+	defer wf.mu.RUnlock()
+	var filtered bool
+	lower := strings.ToLower(text)
+	// the lower case could change the length of the text, do not filter it by index then
+	if len(lower) != len(text) {
+		lower = text
+	}
+	res := []byte(text)
+	for _, w := range wf.words {
+		for i := strings.Index(lower, w); i >= 0; {
+			filtered = true
+			stars := strings.Repeat("*", utf8.RuneCountInString(w))
+			res = append(res[:i], append([]byte(stars), res[i+len(w):]...)...)
+			lower = lower[:i] + stars + lower[i+len(w):]
+			j := strings.Index(lower[i+len(stars):], w)
+			if j < 0 {
+				break
+			}
+			i += len(stars) + j
+		}
+	}
+	return string(res), filtered
+}
@@ -0,0 +1,22 @@
+package utils
+
+import "testing"
+
+func Test_WordFilter(t *testing.T) {
+	wf := NewWordFilter("bad", " 傻瓜 ", "")
+
+	if res, ok := wf.Filter("a BAD word, bad bad"); !ok || res != "a *** word, *** ***" {
+		t.Errorf("the words should be filtered, but %v", res)
+	}
+	if res, ok := wf.Filter("你是傻瓜吗"); !ok || res != "你是**吗" {
+		t.Errorf("the chinese words should be filtered, but %v", res)
+	}
+	if res, ok := wf.Filter("good words"); ok || res != "good words" {
+		t.Errorf("nothing should be filtered, but %v", res)
+	}
+
+	wf.Set("good")
+	if res, _ := wf.Filter("good bad"); res != "**** bad" {
+		t.Errorf("the words should be replaced, but %v", res)
+	}
+}
@@ -70,6 +70,19 @@ func (ss *sessionStore) NumOfOnlineUsers() int {
 	return len(ss.sess)
 }
 
+// check if any session has the value of the key
+// e.g. if the user is online
+func (ss *sessionStore) HasValue(key string, val interface{}) bool {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	for _, sess := range ss.sess {
+		if sess.get(key) == val {
+			return true
+		}
+	}
+	return false
+}
+
 // generate unique session id
 func (ss *sessionStore) generateSessionId(ctx interface{}) string {
 	return getRand()
@@ -19,10 +19,10 @@ const errCantGenerateToken = "can not generate token for user %s, %d"
 
 func GenerateToken(uid int, nickname string, isApply bool, isOb bool, tid int) (string, error) {
 	var token string
-	// uid|nickname
+	// uid|nickname|tournamentId
 	// uid|nickname|isObserver|tableId|isTournament
 	if isApply {
-		token = fmt.Sprintf("%d|%s", uid, nickname)
+		token = fmt.Sprintf("%d|%s|%d", uid, nickname, tid)
 	} else {
 		token = fmt.Sprintf("%d|%s|%v|%d|%v", uid, nickname, isOb, tid, tid >= 1e5)
 	}
@@ -58,13 +58,17 @@ func ParseToken(token string) (uid int, nickname string, isApply bool, isOb bool
 		tid, err = strconv.Atoi(vals[3])
 		// isTournament
 		isTournament = vals[4] == "true"
-	case 2:
+	case 3:
 		// apply for tournament
 		isApply = true
 		// nickname
 		nickname = vals[1]
 		// uid
-		uid, err = strconv.Atoi(vals[0])
+		if uid, err = strconv.Atoi(vals[0]); err != nil {
+			return
+		}
+		// the tid is the tournament id
+		tid, err = strconv.Atoi(vals[2])
 	default:
 		err = fmt.Errorf(errTokenError, token)
 	}
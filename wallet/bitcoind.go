@@ -0,0 +1,185 @@
+package wallet
+
+import (
+	"fmt"
+
+	"github.com/conformal/btcjson"
+)
+
+var errUnexpectedResult = fmt.Errorf("比特币钱包返回了无法识别的结果")
+
+// the error codes of bitcoind the send is rejected with
+const (
+	rpcInvalidAddress    = -5
+	rpcInsufficientFunds = -6
+)
+
+// the error returned by bitcoind
+type rpcError struct {
+	method string
+	code   int
+	msg    string
+}
+
+func (e *rpcError) Error() string { return fmt.Sprintf("%s error %d: %s", e.method, e.code, e.msg) }
+
+// the rejection of the send, the other errors leave the send unknown
+func sendError(err error) error {
+	if e, ok := err.(*rpcError); ok {
+		switch e.code {
+		case rpcInvalidAddress:
+			return ErrInvalidAddress
+		case rpcInsufficientFunds:
+			return ErrInsufficientFunds
+		}
+	}
+	return err
+}
+
+// the wallet of bitcoind through the json rpc
+type Bitcoind struct {
+	rpc func(msg []byte) (btcjson.Reply, error)
+}
+
+func NewBitcoind(user, pass, server string) *Bitcoind {
+	return &Bitcoind{rpc: func(msg []byte) (btcjson.Reply, error) {
+		return btcjson.RpcCommand(user, pass, server, msg)
+	}}
+}
+
+func (b *Bitcoind) call(method string, args ...interface{}) (interface{}, error) {
+	msg, err := btcjson.CreateMessage(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := b.rpc(msg)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Error != nil {
+		return nil, &rpcError{method, reply.Error.Code, reply.Error.Message}
+	}
+	return reply.Result, nil
+}
+
+func (b *Bitcoind) NewAddress(account string) (string, error) {
+	res, err := b.call("getnewaddress", account)
+	if err != nil {
+		return "", err
+	}
+	addr, ok := res.(string)
+	if !ok {
+		return "", errUnexpectedResult
+	}
+	return addr, nil
+}
+
+func (b *Bitcoind) Send(address string, amount int) (string, error) {
+	res, err := b.call("sendtoaddress", address, ToBTC(amount))
+	if err != nil {
+		return "", sendError(err)
+	}
+	txid, ok := res.(string)
+	if !ok {
+		return "", errUnexpectedResult
+	}
+	return txid, nil
+}
+
+// the number of the transactions listed in a page when the key of the send is searched
+const transactionsPage = 200
+
+// the txid of the send of the key, empty if it is not sent
+// the key is the comment of the transaction, all the transactions of the wallet are searched page by page
+func (b *Bitcoind) sentWith(key string) (string, error) {
+	for skip := 0; ; skip += transactionsPage {
+		res, err := b.call("listtransactions", "*", transactionsPage, skip)
+		if err != nil {
+			return "", err
+		}
+		txs, ok := res.([]btcjson.ListTransactionsResult)
+		if !ok {
+			return "", errUnexpectedResult
+		}
+		for _, tx := range txs {
+			if tx.Category == CategorySend && tx.Comment == key {
+				return tx.TxID, nil
+			}
+		}
+		if len(txs) < transactionsPage {
+			return "", nil
+		}
+	}
+}
+
+func (b *Bitcoind) SendMany(key string, outputs map[string]int) (string, error) {
+	if txid, err := b.sentWith(key); err != nil || txid != "" {
+		return txid, err
+	}
+	amounts := make(map[string]float64, len(outputs))
+	for address, amount := range outputs {
+		amounts[address] = ToBTC(amount)
+	}
+	res, err := b.call("sendmany", "", amounts, 1, key)
+	if err != nil {
+		return "", sendError(err)
+	}
+	txid, ok := res.(string)
+	if !ok {
+		return "", errUnexpectedResult
+	}
+	return txid, nil
+}
+
+func (b *Bitcoind) ValidateAddress(address string) (bool, error) {
+	res, err := b.call("validateaddress", address)
+	if err != nil {
+		return false, err
+	}
+	v, ok := res.(*btcjson.ValidateAddressResult)
+	if !ok {
+		return false, errUnexpectedResult
+	}
+	return v.IsValid, nil
+}
+
+func (b *Bitcoind) ListSince(cursor string) (*Transactions, error) {
+	args := []interface{}{}
+	if cursor != "" {
+		args = append(args, cursor)
+	}
+	res, err := b.call("listsinceblock", args...)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := res.(*btcjson.ListSinceBlockResult)
+	if !ok {
+		return nil, errUnexpectedResult
+	}
+	txs := make([]Transaction, 0, len(r.Transactions))
+	for _, v := range r.Transactions {
+		txs = append(txs, Transaction{
+			TxId:          v.TxID,
+			Account:       v.Account,
+			Address:       v.Address,
+			Category:      v.Category,
+			Amount:        ToMBTC(v.Amount),
+			Confirmations: int(v.Confirmations),
+			BlockHash:     v.BlockHash,
+			Comment:       v.Comment,
+		})
+	}
+	return &Transactions{Transactions: txs, Cursor: r.LastBlock}, nil
+}
+
+func (b *Bitcoind) Confirmations(txid string) (int, error) {
+	res, err := b.call("gettransaction", txid)
+	if err != nil {
+		return 0, err
+	}
+	r, ok := res.(*btcjson.GetTransactionResult)
+	if !ok {
+		return 0, errUnexpectedResult
+	}
+	return int(r.Confirmations), nil
+}
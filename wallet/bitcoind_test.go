@@ -0,0 +1,94 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/conformal/btcjson"
+)
+
+var _ Wallet = NewBitcoind("", "", "")
+
+func bitcoindOf(result interface{}, err *btcjson.Error) *Bitcoind {
+	return &Bitcoind{rpc: func(msg []byte) (btcjson.Reply, error) {
+		return btcjson.Reply{Result: result, Error: err}, nil
+	}}
+}
+
+func Test_BitcoindListSince(t *testing.T) {
+	b := bitcoindOf(&btcjson.ListSinceBlockResult{
+		Transactions: []btcjson.ListTransactionsResult{
+			{TxID: "tx", Account: "alice", Category: CategoryReceive, Amount: 0.0125, Confirmations: 2},
+		},
+		LastBlock: "tip",
+	}, nil)
+	res, err := b.ListSince("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Cursor != "tip" || len(res.Transactions) != 1 {
+		t.Fatalf("should list 1 transaction to the tip, but %v", res)
+	}
+	if tx := res.Transactions[0]; tx.Amount != 12 || tx.Confirmations != 2 || tx.Account != "alice" {
+		t.Errorf("the transaction is wrong: %v", tx)
+	}
+}
+
+func Test_BitcoindError(t *testing.T) {
+	if _, err := bitcoindOf(nil, &btcjson.Error{Code: -5, Message: "invalid address"}).Send("addr", 1); err == nil {
+		t.Error("the rpc error should be returned")
+	}
+	if _, err := bitcoindOf(12, nil).NewAddress("alice"); err != errUnexpectedResult {
+		t.Errorf("the result should be unexpected, but %v", err)
+	}
+}
+
+func Test_BitcoindSendManyOnce(t *testing.T) {
+	calls := 0
+	b := &Bitcoind{rpc: func(msg []byte) (btcjson.Reply, error) {
+		calls++
+		return btcjson.Reply{Result: []btcjson.ListTransactionsResult{
+			{TxID: "sent", Category: CategorySend, Amount: -0.05, Comment: "batch-1"},
+		}}, nil
+	}}
+	// the key sent already is not sent again
+	txid, err := b.SendMany("batch-1", map[string]int{"addr": 50})
+	if err != nil || txid != "sent" || calls != 1 {
+		t.Errorf("should return the txid of the first send, but %s, %v, %d calls", txid, err, calls)
+	}
+}
+
+func Test_BitcoindSendManyPaged(t *testing.T) {
+	calls := 0
+	b := &Bitcoind{rpc: func(msg []byte) (btcjson.Reply, error) {
+		calls++
+		// a full page of the other transactions, then the page with the key
+		if calls == 1 {
+			return btcjson.Reply{Result: make([]btcjson.ListTransactionsResult, transactionsPage)}, nil
+		}
+		return btcjson.Reply{Result: []btcjson.ListTransactionsResult{
+			{TxID: "sent", Category: CategorySend, Amount: -0.05, Comment: "batch-1"},
+		}}, nil
+	}}
+	txid, err := b.SendMany("batch-1", map[string]int{"addr": 50})
+	if err != nil || txid != "sent" || calls != 2 {
+		t.Errorf("should find the key sent before the recent page, but %s, %v, %d calls", txid, err, calls)
+	}
+}
+
+func Test_BitcoindSendManyRejected(t *testing.T) {
+	for code, want := range map[int]error{rpcInvalidAddress: ErrInvalidAddress, rpcInsufficientFunds: ErrInsufficientFunds, -1: nil} {
+		// no send of the key, then the error of sendmany
+		listed := false
+		b := &Bitcoind{rpc: func(msg []byte) (btcjson.Reply, error) {
+			if !listed {
+				listed = true
+				return btcjson.Reply{Result: []btcjson.ListTransactionsResult{}}, nil
+			}
+			return btcjson.Reply{Error: &btcjson.Error{Code: code, Message: "rejected"}}, nil
+		}}
+		_, err := b.SendMany("batch-1", map[string]int{"addr": 50})
+		if want != nil && err != want || want == nil && (err == nil || IsRejected(err)) {
+			t.Errorf("error %d should be %v, but %v", code, want, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package wallet
+
+import "sync"
+
+// status of the deposit
+const (
+	DepositPending   = iota // seen, not enough confirmations
+	DepositConfirmed        // enough confirmations, not credited yet
+	DepositCredited
+	DepositDropped     // conflicted before credited
+	DepositReversed    // conflicted after credited, the credit is reversed
+	DepositUnconfirmed // reorged below the confirmations after credited, the credit is reversed until confirmed again
+)
+
+// the credited deposits are tracked for the reorg until the confirmations are this deeper
+const DepositReorgDepth = 6
+
+type Deposit struct {
+	TxId, Account, Address string
+	Amount                 int
+	Confirmations          int
+	Status                 int
+	// the part of the credit the handler could not take back when it is unconfirmed, it is not credited again
+	Covered int
+}
+
+func (d *Deposit) key() string { return d.TxId + ":" + d.Address }
+
+// the hooks of the tracker, the credit and the reversal store the status with the movement
+// the reversal of an unconfirmed deposit is undone by the credit once it is confirmed again
+type DepositHandler interface {
+	// store the new deposit, false if it is known already
+	Seen(d *Deposit) (bool, error)
+	// store the confirmations and the status
+	Update(d *Deposit) error
+	Credit(d *Deposit) error
+	Reverse(d *Deposit) error
+	SaveCursor(cursor string) error
+}
+
+// track the deposits of the wallet to the confirmations
+// the deposits are credited with enough confirmations, and reversed if they are reorged away
+type DepositTracker struct {
+	wallet        Wallet
+	handler       DepositHandler
+	confirmations int
+	cursor        string
+	deposits      map[string]*Deposit // the deposits not final
+	mu            sync.Mutex
+}
+
+// the cursor and the deposits not final are loaded by the caller
+func NewDepositTracker(w Wallet, h DepositHandler, confirmations int, cursor string, tracked ...*Deposit) *DepositTracker {
+	if confirmations < 1 {
+		confirmations = 1
+	}
+	t := &DepositTracker{
+		wallet:        w,
+		handler:       h,
+		confirmations: confirmations,
+		cursor:        cursor,
+		deposits:      make(map[string]*Deposit),
+	}
+	for _, d := range tracked {
+		t.deposits[d.key()] = d
+	}
+	return t
+}
+
+func (t *DepositTracker) Confirmations() int { return t.confirmations }
+
+// list the new deposits since the cursor, update the tracked deposits
+func (t *DepositTracker) Scan() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res, err := t.wallet.ListSince(t.cursor)
+	if err != nil {
+		return err
+	}
+	for _, tx := range res.Transactions {
+		if tx.Category != CategoryReceive || tx.Amount <= 0 {
+			continue
+		}
+		d := &Deposit{TxId: tx.TxId, Account: tx.Account, Address: tx.Address, Amount: tx.Amount, Confirmations: tx.Confirmations}
+		if _, ok := t.deposits[d.key()]; ok {
+			continue
+		}
+		isNew, err := t.handler.Seen(d)
+		if err != nil {
+			return err
+		}
+		if isNew {
+			t.deposits[d.key()] = d
+		}
+	}
+	// the deposits are stored, a failed one is retried in the next scan
+	var failed error
+	for key, d := range t.deposits {
+		n, err := t.wallet.Confirmations(d.TxId)
+		if err == nil {
+			var final bool
+			if final, err = t.advance(d, n); final {
+				delete(t.deposits, key)
+			}
+		}
+		if err != nil && failed == nil {
+			failed = err
+		}
+	}
+	if err := t.handler.SaveCursor(res.Cursor); err != nil {
+		return err
+	}
+	t.cursor = res.Cursor
+	return failed
+}
+
+// move the deposit by the confirmations, return true if it is final
+func (t *DepositTracker) advance(d *Deposit, n int) (bool, error) {
+	changed := d.Confirmations != n
+	d.Confirmations = n
+	switch {
+	case n < 0 && d.Status == DepositCredited:
+		d.Status = DepositReversed
+		if err := t.handler.Reverse(d); err != nil {
+			d.Status = DepositCredited
+			return false, err
+		}
+		return true, nil
+	case n < 0:
+		d.Status = DepositDropped
+		return true, t.handler.Update(d)
+	case d.Status == DepositCredited && n < t.confirmations:
+		d.Status = DepositUnconfirmed
+		if err := t.handler.Reverse(d); err != nil {
+			d.Status = DepositCredited
+			return false, err
+		}
+		return false, nil
+	case (d.Status == DepositPending || d.Status == DepositUnconfirmed) && n >= t.confirmations:
+		d.Status, changed = DepositConfirmed, true
+	}
+	if d.Status == DepositConfirmed {
+		d.Status = DepositCredited
+		if err := t.handler.Credit(d); err != nil {
+			d.Status = DepositConfirmed
+			if changed {
+				t.handler.Update(d)
+			}
+			return false, err
+		}
+		changed = false
+	}
+	if changed {
+		if err := t.handler.Update(d); err != nil {
+			return false, err
+		}
+	}
+	return d.Status == DepositCredited && n >= t.confirmations+DepositReorgDepth, nil
+}
+
+// the deposits of the account not credited yet
+func (t *DepositTracker) Pending(account string) []Deposit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make([]Deposit, 0)
+	for _, d := range t.deposits {
+		if d.Account == account && d.Status != DepositCredited {
+			res = append(res, *d)
+		}
+	}
+	return res
+}
@@ -0,0 +1,167 @@
+package wallet
+
+import (
+	"fmt"
+	"testing"
+)
+
+// the handler of the tests keeps the deposits and the balances in memory
+type memoryHandler struct {
+	known    map[string]int // key -> status
+	balances map[string]int // account -> balance
+	cursor   string
+	fail     bool
+}
+
+func newMemoryHandler() *memoryHandler {
+	return &memoryHandler{known: make(map[string]int), balances: make(map[string]int)}
+}
+
+func (h *memoryHandler) Seen(d *Deposit) (bool, error) {
+	if _, ok := h.known[d.key()]; ok {
+		return false, nil
+	}
+	h.known[d.key()] = d.Status
+	return true, nil
+}
+
+func (h *memoryHandler) Update(d *Deposit) error {
+	h.known[d.key()] = d.Status
+	return nil
+}
+
+func (h *memoryHandler) Credit(d *Deposit) error {
+	if h.fail {
+		return fmt.Errorf("the user %s is not exist", d.Account)
+	}
+	h.balances[d.Account] += d.Amount
+	return h.Update(d)
+}
+
+func (h *memoryHandler) Reverse(d *Deposit) error {
+	h.balances[d.Account] -= d.Amount
+	return h.Update(d)
+}
+
+func (h *memoryHandler) SaveCursor(cursor string) error {
+	h.cursor = cursor
+	return nil
+}
+
+func Test_DepositTracker(t *testing.T) {
+	f, h := NewFake(), newMemoryHandler()
+	tr := NewDepositTracker(f, h, 3, "")
+	addr, _ := f.NewAddress("alice")
+	txid, _ := f.Deposit(addr, 100)
+	f.Mine(1)
+	if err := tr.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if ps := tr.Pending("alice"); len(ps) != 1 || ps[0].TxId != txid || ps[0].Confirmations != 1 || ps[0].Status != DepositPending {
+		t.Fatalf("the deposit should be pending with 1 confirmation, but %v", ps)
+	}
+	if h.balances["alice"] != 0 {
+		t.Error("the pending deposit should not be credited")
+	}
+
+	// the credit fails, retried in the next scan
+	f.Mine(2)
+	h.fail = true
+	if err := tr.Scan(); err == nil {
+		t.Error("the credit should fail")
+	}
+	if ps := tr.Pending("alice"); len(ps) != 1 || ps[0].Status != DepositConfirmed {
+		t.Fatalf("the deposit should be confirmed, but %v", ps)
+	}
+	h.fail = false
+	if err := tr.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if h.balances["alice"] != 100 || len(tr.Pending("alice")) != 0 {
+		t.Errorf("the deposit should be credited, but %d, %v", h.balances["alice"], tr.Pending("alice"))
+	}
+	if h.cursor != f.Mine(0) {
+		t.Error("the cursor should be saved")
+	}
+
+	// restart with the saved cursor, the deposit is not credited twice
+	tr = NewDepositTracker(f, h, 3, "")
+	if err := tr.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if h.balances["alice"] != 100 {
+		t.Errorf("the known deposit should not be credited again, but %d", h.balances["alice"])
+	}
+}
+
+func Test_DepositReorg(t *testing.T) {
+	f, h := NewFake(), newMemoryHandler()
+	tr := NewDepositTracker(f, h, 2, "")
+	addr, _ := f.NewAddress("alice")
+	credited, _ := f.Deposit(addr, 100)
+	f.Mine(2)
+	tr.Scan()
+	if h.balances["alice"] != 100 {
+		t.Fatalf("the deposit should be credited, but %d", h.balances["alice"])
+	}
+	pending, _ := f.Deposit(addr, 50)
+	f.Mine(1)
+	tr.Scan()
+
+	// the blocks of both are replaced, both are double spent
+	f.Reorg(3, true)
+	tr.Scan()
+	if h.balances["alice"] != 0 {
+		t.Errorf("the credit should be reversed, but %d", h.balances["alice"])
+	}
+	if h.known[credited+":"+addr] != DepositReversed || h.known[pending+":"+addr] != DepositDropped {
+		t.Errorf("the deposits should be reversed and dropped, but %v", h.known)
+	}
+	if len(tr.deposits) != 0 {
+		t.Errorf("the conflicted deposits should not be tracked, but %v", tr.deposits)
+	}
+
+	// final after the reorg depth
+	another, _ := f.Deposit(addr, 10)
+	f.Mine(2)
+	tr.Scan()
+	f.Mine(DepositReorgDepth)
+	tr.Scan()
+	if _, ok := tr.deposits[another+":"+addr]; ok || h.balances["alice"] != 10 {
+		t.Errorf("the deposit should be credited and final, but %v, %d", tr.deposits, h.balances["alice"])
+	}
+}
+
+func Test_DepositUnconfirmed(t *testing.T) {
+	f, h := NewFake(), newMemoryHandler()
+	tr := NewDepositTracker(f, h, 2, "")
+	addr, _ := f.NewAddress("alice")
+	txid, _ := f.Deposit(addr, 100)
+	f.Mine(2)
+	tr.Scan()
+	if h.balances["alice"] != 100 {
+		t.Fatalf("the deposit should be credited, but %d", h.balances["alice"])
+	}
+
+	// the block of the deposit is orphaned, the deposit is back in the mempool
+	f.Orphan(2)
+	tr.Scan()
+	if h.balances["alice"] != 0 || h.known[txid+":"+addr] != DepositUnconfirmed {
+		t.Errorf("the credit of the unconfirmed deposit should be reversed, but %d, %v", h.balances["alice"], h.known)
+	}
+	if ps := tr.Pending("alice"); len(ps) != 1 || ps[0].Status != DepositUnconfirmed {
+		t.Errorf("the unconfirmed deposit should be pending, but %v", ps)
+	}
+
+	// credited again once it is confirmed again
+	f.Mine(1)
+	tr.Scan()
+	if h.balances["alice"] != 0 {
+		t.Errorf("the deposit with 1 confirmation should not be credited, but %d", h.balances["alice"])
+	}
+	f.Mine(1)
+	tr.Scan()
+	if h.balances["alice"] != 100 || h.known[txid+":"+addr] != DepositCredited || len(tr.Pending("alice")) != 0 {
+		t.Errorf("the deposit should be credited again, but %d, %v", h.balances["alice"], h.known)
+	}
+}
@@ -0,0 +1,265 @@
+package wallet
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// the in-memory wallet of a regtest-style chain for the tests and offline
+// the deposits and the sends wait in the mempool until the blocks are mined,
+// the reorg replaces the last blocks and may drop their transactions
+type Fake struct {
+	blocks map[string]*fakeBlock // the blocks ever mined, orphans too
+	chain  []*fakeBlock          // the main chain from the genesis
+	txs    map[string]*fakeTx
+	order  []string          // txids by the time
+	addrs  map[string]string // address -> account
+	sends  map[string]string // key of the send -> txid
+	seq    int
+	mu     sync.Mutex
+}
+
+type fakeBlock struct {
+	hash   string
+	height int
+	parent *fakeBlock
+	txs    []string
+}
+
+type fakeTx struct {
+	Transaction
+	block      *fakeBlock // nil in the mempool
+	conflicted bool
+}
+
+var ErrBlockNotExist = fmt.Errorf("区块不存在")
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func NewFake() *Fake {
+	f := &Fake{
+		blocks: make(map[string]*fakeBlock),
+		txs:    make(map[string]*fakeTx),
+		addrs:  make(map[string]string),
+		sends:  make(map[string]string),
+	}
+	f.mine()
+	return f
+}
+
+func (f *Fake) nextHash() string {
+	f.seq++
+	return fmt.Sprintf("%064x", f.seq)
+}
+
+// mine a block with the transactions in the mempool
+func (f *Fake) mine() *fakeBlock {
+	b := &fakeBlock{hash: f.nextHash(), height: len(f.chain)}
+	if b.height > 0 {
+		b.parent = f.chain[b.height-1]
+	}
+	for _, txid := range f.order {
+		if tx := f.txs[txid]; tx.block == nil && !tx.conflicted {
+			tx.block = b
+			b.txs = append(b.txs, txid)
+		}
+	}
+	f.blocks[b.hash] = b
+	f.chain = append(f.chain, b)
+	return b
+}
+
+func (f *Fake) isOnChain(b *fakeBlock) bool {
+	return b.height < len(f.chain) && f.chain[b.height] == b
+}
+
+func (f *Fake) confirmations(tx *fakeTx) int {
+	switch {
+	case tx.conflicted:
+		return -1
+	case tx.block == nil:
+		return 0
+	}
+	return len(f.chain) - tx.block.height
+}
+
+func (f *Fake) NewAddress(account string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	var enc []byte
+	for n := f.seq; n > 0; n /= 58 {
+		enc = append([]byte{base58Alphabet[n%58]}, enc...)
+	}
+	addr := "mFake" + strings.Repeat("1", 29-len(enc)) + string(enc)
+	f.addrs[addr] = account
+	return addr, nil
+}
+
+func (f *Fake) Send(address string, amount int) (string, error) {
+	if ok, _ := f.ValidateAddress(address); !ok {
+		return "", ErrInvalidAddress
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if amount <= 0 || f.balance() < amount {
+		return "", ErrInsufficientFunds
+	}
+	return f.add(Transaction{Address: address, Category: CategorySend, Amount: -amount}), nil
+}
+
+func (f *Fake) SendMany(key string, outputs map[string]int) (string, error) {
+	total := 0
+	for address, amount := range outputs {
+		if ok, _ := f.ValidateAddress(address); !ok {
+			return "", ErrInvalidAddress
+		}
+		if amount <= 0 {
+			return "", ErrInsufficientFunds
+		}
+		total += amount
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if txid, ok := f.sends[key]; ok {
+		return txid, nil
+	}
+	if total == 0 || f.balance() < total {
+		return "", ErrInsufficientFunds
+	}
+	txid := f.add(Transaction{Category: CategorySend, Amount: -total, Comment: key})
+	f.sends[key] = txid
+	return txid, nil
+}
+
+// the coins received less the coins sent, the conflicted are not counted
+func (f *Fake) balance() int {
+	sum := 0
+	for _, tx := range f.txs {
+		if !tx.conflicted {
+			sum += tx.Amount
+		}
+	}
+	return sum
+}
+
+func (f *Fake) add(t Transaction) string {
+	t.TxId = f.nextHash()
+	f.txs[t.TxId] = &fakeTx{Transaction: t}
+	f.order = append(f.order, t.TxId)
+	return t.TxId
+}
+
+// the address of base58 with the length of 26 to 35
+func (f *Fake) ValidateAddress(address string) (bool, error) {
+	if len(address) < 26 || len(address) > 35 {
+		return false, nil
+	}
+	for _, c := range address {
+		if !strings.ContainsRune(base58Alphabet, c) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (f *Fake) ListSince(cursor string) (*Transactions, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	from := 0
+	if cursor != "" {
+		b, ok := f.blocks[cursor]
+		if !ok {
+			return nil, ErrBlockNotExist
+		}
+		// the cursor is orphaned by the reorg, list since the fork
+		for !f.isOnChain(b) {
+			b = b.parent
+		}
+		from = b.height + 1
+	}
+	res := &Transactions{Transactions: make([]Transaction, 0), Cursor: f.chain[len(f.chain)-1].hash}
+	for _, txid := range f.order {
+		tx := f.txs[txid]
+		if tx.block != nil && tx.block.height < from {
+			continue
+		}
+		t := tx.Transaction
+		t.Confirmations = f.confirmations(tx)
+		if tx.block != nil {
+			t.BlockHash = tx.block.hash
+		}
+		res.Transactions = append(res.Transactions, t)
+	}
+	return res, nil
+}
+
+func (f *Fake) Confirmations(txid string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tx, ok := f.txs[txid]
+	if !ok {
+		return 0, ErrTxNotExist
+	}
+	return f.confirmations(tx), nil
+}
+
+// the coins from outside to the address of the wallet, in the mempool
+func (f *Fake) Deposit(address string, amount int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	account, ok := f.addrs[address]
+	if !ok {
+		return "", ErrInvalidAddress
+	}
+	return f.add(Transaction{Account: account, Address: address, Category: CategoryReceive, Amount: amount}), nil
+}
+
+// mine n blocks, return the hash of the tip
+func (f *Fake) Mine(n int) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < n; i++ {
+		f.mine()
+	}
+	return f.chain[len(f.chain)-1].hash
+}
+
+// take the last depth blocks off the chain, their transactions are back in the mempool
+// return the hash of the tip
+func (f *Fake) Orphan(depth int) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if depth >= len(f.chain) {
+		depth = len(f.chain) - 1
+	}
+	for _, b := range f.chain[len(f.chain)-depth:] {
+		for _, txid := range b.txs {
+			f.txs[txid].block = nil
+		}
+	}
+	f.chain = f.chain[:len(f.chain)-depth]
+	return f.chain[len(f.chain)-1].hash
+}
+
+// replace the last depth blocks with depth+1 new blocks, return the hash of the tip
+// the transactions of the replaced blocks are mined again, or conflicted if dropped
+func (f *Fake) Reorg(depth int, drop bool) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if depth >= len(f.chain) {
+		depth = len(f.chain) - 1
+	}
+	for _, b := range f.chain[len(f.chain)-depth:] {
+		for _, txid := range b.txs {
+			tx := f.txs[txid]
+			tx.block, tx.conflicted = nil, drop
+		}
+	}
+	f.chain = f.chain[:len(f.chain)-depth]
+	for i := 0; i <= depth; i++ {
+		f.mine()
+	}
+	return f.chain[len(f.chain)-1].hash
+}
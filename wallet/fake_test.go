@@ -0,0 +1,129 @@
+package wallet
+
+import "testing"
+
+var _ Wallet = NewFake()
+
+func Test_FakeDeposit(t *testing.T) {
+	f := NewFake()
+	addr, _ := f.NewAddress("alice")
+	if ok, _ := f.ValidateAddress(addr); !ok {
+		t.Fatalf("the new address %s should be valid", addr)
+	}
+	if _, err := f.Deposit("unknown", 10); err != ErrInvalidAddress {
+		t.Errorf("the deposit to the unknown address should fail, but %v", err)
+	}
+	txid, err := f.Deposit(addr, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _ := f.ListSince("")
+	if len(res.Transactions) != 1 || res.Transactions[0].Confirmations != 0 || res.Transactions[0].Account != "alice" {
+		t.Fatalf("the deposit should be in the mempool, but %v", res.Transactions)
+	}
+	cursor := res.Cursor
+	f.Mine(3)
+	if n, _ := f.Confirmations(txid); n != 3 {
+		t.Errorf("the deposit should have 3 confirmations, but %d", n)
+	}
+	res, _ = f.ListSince(cursor)
+	if len(res.Transactions) != 1 || res.Transactions[0].Confirmations != 3 || res.Transactions[0].BlockHash == "" {
+		t.Errorf("the deposit should be mined after the cursor, but %v", res.Transactions)
+	}
+	if res, _ = f.ListSince(res.Cursor); len(res.Transactions) != 0 {
+		t.Errorf("nothing should be listed since the tip, but %v", res.Transactions)
+	}
+	if _, err := f.ListSince("unknown"); err != ErrBlockNotExist {
+		t.Errorf("the cursor should not exist, but %v", err)
+	}
+	if _, err := f.Confirmations("unknown"); err != ErrTxNotExist {
+		t.Errorf("the transaction should not exist, but %v", err)
+	}
+}
+
+func Test_FakeReorg(t *testing.T) {
+	f := NewFake()
+	addr, _ := f.NewAddress("alice")
+	kept, _ := f.Deposit(addr, 100)
+	f.Mine(1)
+	dropped, _ := f.Deposit(addr, 50)
+	cursor := f.Mine(1)
+
+	// the block of the dropped deposit is replaced, the deposit is double spent
+	f.Reorg(1, true)
+	if n, _ := f.Confirmations(dropped); n != -1 {
+		t.Errorf("the dropped deposit should conflict, but %d confirmations", n)
+	}
+	if n, _ := f.Confirmations(kept); n != 3 {
+		t.Errorf("the kept deposit should have 3 confirmations, but %d", n)
+	}
+	// listed since the fork
+	res, err := f.ListSince(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Transactions) != 1 || res.Transactions[0].TxId != dropped || res.Transactions[0].Confirmations != -1 {
+		t.Errorf("the conflicted deposit should be listed, but %v", res.Transactions)
+	}
+
+	// mined again in the new chain
+	before, _ := f.ListSince("")
+	f.Reorg(3, false)
+	after, _ := f.ListSince("")
+	if n, _ := f.Confirmations(kept); n != 4 {
+		t.Errorf("the kept deposit should be mined again at height 1, but %d confirmations", n)
+	}
+	if before.Transactions[0].BlockHash == after.Transactions[0].BlockHash {
+		t.Error("the kept deposit should be in the new block")
+	}
+}
+
+func Test_FakeSend(t *testing.T) {
+	f := NewFake()
+	addr, _ := f.NewAddress("alice")
+	f.Deposit(addr, 100)
+	f.Mine(1)
+	if _, err := f.Send("0OIl", 10); err != ErrInvalidAddress {
+		t.Errorf("the address should be invalid, but %v", err)
+	}
+	to, _ := NewFake().NewAddress("bob")
+	if _, err := f.Send(to, 101); err != ErrInsufficientFunds {
+		t.Errorf("the funds should be insufficient, but %v", err)
+	}
+	txid, err := f.Send(to, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _ := f.ListSince(f.Mine(0))
+	if len(res.Transactions) != 1 || res.Transactions[0].TxId != txid || res.Transactions[0].Amount != -60 {
+		t.Errorf("the send should be in the mempool, but %v", res.Transactions)
+	}
+	if _, err := f.Send(to, 50); err != ErrInsufficientFunds {
+		t.Errorf("only 40 is left, but %v", err)
+	}
+}
+
+func Test_FakeSendMany(t *testing.T) {
+	f := NewFake()
+	addr, _ := f.NewAddress("alice")
+	f.Deposit(addr, 100)
+	to := NewFake()
+	bob, _ := to.NewAddress("bob")
+	carol, _ := to.NewAddress("carol")
+	outputs := map[string]int{bob: 30, carol: 20}
+	txid, err := f.SendMany("batch-1", outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same key is sent once
+	if again, err := f.SendMany("batch-1", outputs); err != nil || again != txid {
+		t.Errorf("the same key should return the txid %s, but %s, %v", txid, again, err)
+	}
+	if _, err := f.SendMany("batch-2", map[string]int{bob: 51}); err != ErrInsufficientFunds {
+		t.Errorf("only 50 is left, but %v", err)
+	}
+	res, _ := f.ListSince("")
+	if len(res.Transactions) != 2 || res.Transactions[1].Amount != -50 || res.Transactions[1].Comment != "batch-1" {
+		t.Errorf("the batch should be sent once, but %v", res.Transactions)
+	}
+}
@@ -0,0 +1,73 @@
+// Package wallet is the bitcoin wallet backend of the auth server.
+// The amounts are in mBTC.
+package wallet
+
+import "fmt"
+
+// category of the transaction
+const (
+	CategoryReceive = "receive"
+	CategorySend    = "send"
+)
+
+var (
+	ErrInvalidAddress    = fmt.Errorf("比特币地址无效")
+	ErrInsufficientFunds = fmt.Errorf("钱包余额不足")
+	ErrTxNotExist        = fmt.Errorf("交易不存在")
+)
+
+// a transaction of the wallet
+// the confirmations are 0 in the mempool, and negative if it conflicts with the chain
+type Transaction struct {
+	TxId          string
+	Account       string
+	Address       string
+	Category      string
+	Amount        int
+	Confirmations int
+	BlockHash     string
+	Comment       string // the idempotency key of the send
+}
+
+// the transactions since the cursor, and the cursor for the next listing
+type Transactions struct {
+	Transactions []Transaction
+	Cursor       string
+}
+
+type Wallet interface {
+	// new address for the account
+	NewAddress(account string) (string, error)
+	// send the amount to the address, return the txid
+	Send(address string, amount int) (string, error)
+	// send the amounts to the addresses in a transaction, return the txid
+	// the key is idempotent, the same key returns the txid of the first send
+	// the rejected send returns ErrInvalidAddress or ErrInsufficientFunds,
+	// the other errors leave it unknown, it is sent again with the same key
+	SendMany(key string, outputs map[string]int) (string, error)
+	ValidateAddress(address string) (bool, error)
+	// the transactions in the blocks after the cursor and in the mempool
+	// the empty cursor lists all, the cursor is a block hash
+	ListSince(cursor string) (*Transactions, error)
+	Confirmations(txid string) (int, error)
+}
+
+// the send is rejected for sure, it is not sent with the key
+func IsRejected(err error) bool {
+	return err == ErrInvalidAddress || err == ErrInsufficientFunds
+}
+
+// the error of the float of the amount in BTC, it is far less than a satoshi
+const btcEpsilon = 1e-9
+
+// mBTC of the amount in BTC, the fraction of a mBTC is dropped
+// the credit is never more than the coins
+func ToMBTC(btc float64) int {
+	if btc < 0 {
+		return -ToMBTC(-btc)
+	}
+	return int(btc*1e3 + btcEpsilon)
+}
+
+// BTC of the amount in mBTC
+func ToBTC(mBTC int) float64 { return float64(mBTC) / 1e3 }
@@ -0,0 +1,24 @@
+package wallet
+
+import "testing"
+
+func Test_ToMBTC(t *testing.T) {
+	if m := ToMBTC(0.0129); m != 12 {
+		t.Errorf("0.0129 BTC should be 12 mBTC, but %d", m)
+	}
+	if m := ToMBTC(0.0125); m != 12 {
+		t.Errorf("0.0125 BTC should be 12 mBTC, but %d", m)
+	}
+	if m := ToMBTC(0.0129999); m != 12 {
+		t.Errorf("0.0129999 BTC should be 12 mBTC, but %d", m)
+	}
+	if m := ToMBTC(0.013); m != 13 {
+		t.Errorf("0.013 BTC should be 13 mBTC, but %d", m)
+	}
+	if m := ToMBTC(-0.003); m != -3 {
+		t.Errorf("-0.003 BTC should be -3 mBTC, but %d", m)
+	}
+	if b := ToBTC(1500); b != 1.5 {
+		t.Errorf("1500 mBTC should be 1.5 BTC, but %v", b)
+	}
+}